@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+// currentAdmin returns the username the request was authenticated as
+func currentAdmin(c *gin.Context) string {
+	id, _ := tokenClaims(c)["id"].(string)
+	return id
+}
+
+// adminErrorStatus maps admin account errors to http statuses
+func adminErrorStatus(err error) int {
+	switch err {
+	case dist.ErrAdminNotFound:
+		return http.StatusNotFound
+	case dist.ErrAdminExists, dist.ErrLastAdmin, dist.ErrAdminProvider:
+		return http.StatusConflict
+	case dist.ErrInvalidCredentials:
+		return http.StatusForbidden
+	case dist.ErrAccountLocked:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+func reportAdminError(c *gin.Context, err error) {
+	if status := adminErrorStatus(err); status != http.StatusInternalServerError {
+		c.Status(status)
+	}
+	reportError(c, err)
+}
+
+// useAdmins adds admin account management to the api
+func useAdmins(api *gin.RouterGroup) {
+	api.GET("/admin", func(c *gin.Context) {
+		list, err := dist.ListAdmins()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	api.POST("/admin", func(c *gin.Context) {
+		req := struct {
+			Username string
+			Password string
+			Roles    []string
+		}{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		admin, err := dist.CreateAdmin(req.Username, req.Password, req.Roles)
+		if err != nil {
+			reportAdminError(c, err)
+			return
+		}
+		auditCreated(c, admin.Username)
+		c.JSON(http.StatusCreated, admin)
+	})
+
+	api.GET("/admin/:username", func(c *gin.Context) {
+		admin, err := dist.GetAdmin(c.Param("username"))
+		if err != nil {
+			reportAdminError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, admin)
+	})
+
+	api.DELETE("/admin/:username", func(c *gin.Context) {
+		if err := dist.DeleteAdmin(c.Param("username")); err != nil {
+			reportAdminError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// another admin sets a new password, e.g. when it was forgotten
+	api.PUT("/admin/:username/password", func(c *gin.Context) {
+		req := struct {
+			Password string
+		}{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		if err := dist.SetAdminPassword(c.Param("username"), req.Password); err != nil {
+			reportAdminError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	api.PUT("/admin/:username/roles", func(c *gin.Context) {
+		req := struct {
+			Roles []string
+		}{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		admin, err := dist.SetAdminRoles(c.Param("username"), req.Roles)
+		if err != nil {
+			reportAdminError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, admin)
+	})
+
+	api.POST("/admin/:username/unlock", func(c *gin.Context) {
+		admin, err := dist.UnlockAdmin(c.Param("username"))
+		if err != nil {
+			reportAdminError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, admin)
+	})
+
+	// the signed in admin changes their own password
+	api.PUT("/password", func(c *gin.Context) {
+		req := struct {
+			CurrentPassword string
+			Password        string
+		}{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		auditTarget(c, currentAdmin(c))
+		if err := dist.ChangeAdminPassword(currentAdmin(c), req.CurrentPassword, req.Password); err != nil {
+			reportAdminError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminErrorStatus(t *testing.T) {
+	for err, status := range map[error]int{
+		dist.ErrAdminNotFound:      http.StatusNotFound,
+		dist.ErrAdminExists:        http.StatusConflict,
+		dist.ErrLastAdmin:          http.StatusConflict,
+		dist.ErrAdminProvider:      http.StatusConflict,
+		dist.ErrInvalidCredentials: http.StatusForbidden,
+		dist.ErrAccountLocked:      http.StatusTooManyRequests,
+		errors.New("disk full"):    http.StatusInternalServerError,
+	} {
+		assert.Equal(t, status, adminErrorStatus(err), err.Error())
+	}
+}
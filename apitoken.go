@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+// apiTokenKey holds the *dist.APIToken a request was authenticated with
+const apiTokenKey = "apiToken"
+
+// bearerAPIToken returns the api token of an "Authorization: Bearer" header, if it is one
+func bearerAPIToken(c *gin.Context) (string, bool) {
+	auth := c.Request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	return token, strings.HasPrefix(token, dist.APITokenPrefix)
+}
+
+// apiTokenOr authenticates requests bearing an api token, and passes others to the jwt middleware
+func apiTokenOr(jwtMiddleware gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerAPIToken(c)
+		if !ok {
+			jwtMiddleware(c)
+			return
+		}
+		t, err := dist.AuthenticateAPIToken(token)
+		if err != nil {
+			code := http.StatusUnauthorized
+			if err != dist.ErrAPITokenInvalid {
+				log.Printf("api token: %s", err.Error())
+				code = http.StatusInternalServerError
+			}
+			c.JSON(code, gin.H{"code": code, "message": err.Error()})
+			c.Abort()
+			return
+		}
+		if !scopesAllow(t.Scopes, c.Request.Method, c.Request.URL.Path) {
+			c.JSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden, "message": "api token scopes do not allow this request"})
+			c.Abort()
+			return
+		}
+		c.Set(apiTokenKey, t)
+		c.Next()
+	}
+}
+
+// useAPITokens adds api token management to the api
+func useAPITokens(api *gin.RouterGroup) {
+	api.GET("/apitoken", func(c *gin.Context) {
+		list, err := dist.ListAPITokens()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	// the token itself is only in this response
+	api.POST("/apitoken", func(c *gin.Context) {
+		req := struct {
+			Name    string
+			Scopes  []string
+			Expires *time.Time
+		}{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		actor, actorType := auditActor(c)
+		token, t, err := dist.CreateAPIToken(req.Name, req.Scopes, req.Expires, actor, actorType)
+		if err != nil {
+			reportError(c, err)
+			return
+		}
+		auditCreated(c, t.ID)
+		c.JSON(http.StatusCreated, gin.H{"Token": token, "APIToken": t})
+	})
+
+	api.DELETE("/apitoken/:id", func(c *gin.Context) {
+		if err := dist.RevokeAPIToken(c.Param("id")); err != nil {
+			if err == dist.ErrAPITokenNotFound {
+				c.Status(http.StatusNotFound)
+			}
+			c.Error(err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+// auditKey holds the *auditState of a mutating request
+const auditKey = "audit"
+
+// auditState is what handlers add to the audit entry of a request
+type auditState struct {
+	resource string
+	targetID string
+	before   interface{}
+}
+
+// auditSnapshots load the current state of a resource, by the first segment of its /api path
+var auditSnapshots = map[string]func(id string) (interface{}, error){
+	"release": func(id string) (interface{}, error) {
+		r, err := dist.Get(id)
+		if r == nil {
+			return nil, err
+		}
+		return r, err
+	},
+	// subscribers are recorded without their name and email, the audit log outlives erasure
+	"sub": func(id string) (interface{}, error) {
+		s, err := dist.GetSub(id)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			ID     string
+			Date   time.Time
+			Status string
+			Tags   []string `json:",omitempty"`
+			Locale string   `json:",omitempty"`
+		}{s.ID, s.Date, s.Status, s.Tags, s.Locale}, nil
+	},
+	"segment": func(id string) (interface{}, error) {
+		return dist.GetSegment(id)
+	},
+	"admin": func(id string) (interface{}, error) {
+		return dist.GetAdmin(id)
+	},
+	"password": func(id string) (interface{}, error) {
+		return dist.GetAdmin(id)
+	},
+	"template": func(id string) (interface{}, error) {
+		return dist.GetTemplate(id)
+	},
+	"config": func(id string) (interface{}, error) {
+		return dist.GetRuntimeConfig()
+	},
+}
+
+// auditResource returns the first segment of an /api path, e.g. "release"
+func auditResource(path string) string {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/api/"), "/", 2)
+	return parts[0]
+}
+
+func auditSnapshot(resource, id string) interface{} {
+	snapshot, ok := auditSnapshots[resource]
+	if !ok || id == "" {
+		return nil
+	}
+	v, err := snapshot(id)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+func getAuditState(c *gin.Context) *auditState {
+	if v, ok := c.Get(auditKey); ok {
+		return v.(*auditState)
+	}
+	return nil
+}
+
+// auditTarget names the resource a request changes, it must be called before the change
+func auditTarget(c *gin.Context, id string) {
+	if s := getAuditState(c); s != nil && s.targetID == "" {
+		s.targetID = id
+		s.before = auditSnapshot(s.resource, id)
+	}
+}
+
+// auditCreated names the resource a request created
+func auditCreated(c *gin.Context, id string) {
+	if s := getAuditState(c); s != nil && s.targetID == "" {
+		s.targetID = id
+	}
+}
+
+// auditActor returns who made a request and whether it was an admin or an api token
+func auditActor(c *gin.Context) (actor, actorType string) {
+	if v, ok := c.Get(apiTokenKey); ok {
+		return v.(*dist.APIToken).ID, dist.ActorAPIToken
+	}
+	return currentAdmin(c), dist.ActorAdmin
+}
+
+func marshalSnapshot(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return j
+}
+
+// useAudit records every mutating api call, it must be used after authentication
+func useAudit(g *gin.RouterGroup) {
+	g.Use(func(c *gin.Context) {
+		if c.Request.Method == "GET" || c.Request.Method == "HEAD" || c.Request.Method == "OPTIONS" {
+			c.Next()
+			return
+		}
+		action := c.Request.Method + " " + c.Request.URL.Path
+		if rp := findRoutePermission(c.Request.Method, c.Request.URL.Path); rp != nil {
+			action = rp.Method + " " + rp.Path
+		}
+		s := &auditState{resource: auditResource(c.Request.URL.Path)}
+		c.Set(auditKey, s)
+		for _, p := range c.Params {
+			if p.Key == "id" || p.Key == "username" {
+				auditTarget(c, p.Value)
+			}
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		if len(c.Errors) > 0 && status < http.StatusBadRequest {
+			status = http.StatusInternalServerError
+		}
+		e := dist.AuditEntry{
+			Action:   action,
+			TargetID: s.targetID,
+			Status:   status,
+			IP:       c.ClientIP(),
+			Before:   marshalSnapshot(s.before),
+		}
+		e.Actor, e.ActorType = auditActor(c)
+		if status < http.StatusBadRequest {
+			e.After = marshalSnapshot(auditSnapshot(s.resource, s.targetID))
+		}
+		if err := dist.RecordAudit(e); err != nil {
+			log.Printf("audit %s: %s", action, err.Error())
+		}
+	})
+}
+
+// auditFilter reads the actor, target, action, since and until query parameters
+func auditFilter(c *gin.Context) (f dist.AuditFilter, ok bool) {
+	f = dist.AuditFilter{
+		Actor:    c.Query("actor"),
+		TargetID: c.Query("target"),
+		Action:   c.Query("action"),
+	}
+	for _, p := range []struct {
+		name string
+		t    **time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err).SetMeta(gin.H{"code": p.name, "message": p.name + " must be an RFC 3339 time"})
+			return f, false
+		}
+		*p.t = &t
+	}
+	return f, true
+}
+
+// useAuditLog adds the audit log listing and export to the api
+func useAuditLog(api *gin.RouterGroup) {
+	api.GET("/audit", func(c *gin.Context) {
+		filter, ok := auditFilter(c)
+		if !ok {
+			return
+		}
+		opts, ok := pageOptions(c)
+		if !ok {
+			return
+		}
+		if c.Query("limit") == "" {
+			opts.Limit = 100
+		}
+		list, info, err := dist.ListAudit(filter, opts)
+		if err != nil {
+			reportError(c, err)
+			return
+		}
+		setPageHeaders(c, info)
+		c.JSON(http.StatusOK, list)
+	})
+
+	api.GET("/audit/export", func(c *gin.Context) {
+		filter, ok := auditFilter(c)
+		if !ok {
+			return
+		}
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", "attachment; filename=audit.json")
+		c.Status(http.StatusOK)
+		if err := dist.ExportAudit(c.Writer, filter); err != nil {
+			log.Printf("export audit log: %s", err.Error())
+		}
+	})
+}
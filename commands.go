@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+)
+
+// command is a maintenance task run instead of the server, e.g. `sc2a-service reencrypt`
+type command struct {
+	Usage string
+	// Offline commands run without opening the database
+	Offline bool
+	Run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"config": {
+		Usage:   "print, show the effective config with secrets redacted",
+		Offline: true,
+		Run: func(args []string) error {
+			if len(args) != 1 || args[0] != "print" {
+				return errors.New("usage: config print")
+			}
+			j, err := json.MarshalIndent(redactConfig(config), "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(j))
+			return validateConfig(config)
+		},
+	},
+	"reencrypt": {
+		Usage: "bring stored release files in line with the current encryption key",
+		Run: func(args []string) error {
+			rv, err := dist.Reencrypt()
+			if err != nil {
+				return err
+			}
+			log.Printf("reencrypt: %d rewrapped, %d encrypted, %d decrypted", rv.Rewrapped, rv.Encrypted, rv.Decrypted)
+			return nil
+		},
+	},
+	"create-admin": {
+		Usage: "<username> [role...], add an admin account, with the admin role by default, the password is read from stdin",
+		Run: func(args []string) error {
+			if len(args) < 1 {
+				return errors.New("usage: create-admin <username> [role...]")
+			}
+			roles := args[1:]
+			if len(roles) == 0 {
+				roles = []string{dist.RoleAdmin}
+			}
+			fmt.Fprint(os.Stderr, "password: ")
+			password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && password == "" {
+				return fmt.Errorf("read password: %s", err.Error())
+			}
+			admin, err := dist.CreateAdmin(args[0], strings.TrimRight(password, "\r\n"), roles)
+			if err != nil {
+				return err
+			}
+			log.Printf("create-admin: created %s", admin.Username)
+			return nil
+		},
+	},
+}
+
+func printUsage() {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] [command]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].Usage)
+	}
+}
+
+func runCommand(name string, args []string) {
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage()
+		os.Exit(2)
+	}
+	if err := cmd.Run(args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+// useConfig adds the settings that can be changed while running to the api, secrets are not among them
+func useConfig(api *gin.RouterGroup) {
+	api.GET("/config", func(c *gin.Context) {
+		rv, err := dist.GetRuntimeConfig()
+		if err != nil {
+			reportError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, rv)
+	})
+
+	// a null value goes back to the setting in config.json
+	api.PATCH("/config", func(c *gin.Context) {
+		values := dist.ConfigMap{}
+		if err := c.BindJSON(&values); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		auditTarget(c, "config")
+		actor, _ := auditActor(c)
+		rv, err := dist.PatchConfig(values, actor)
+		if err != nil {
+			reportError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, rv)
+	})
+}
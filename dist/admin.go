@@ -0,0 +1,446 @@
+package dist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AdminConfig configures admin accounts and login lockout
+type AdminConfig struct {
+	// MinPasswordLength defaults to 10
+	MinPasswordLength int
+	// MaxFailedLogins is the number of failed logins in a row that locks an account, defaults to 5
+	MaxFailedLogins int
+	// LockoutDuration is how long a locked account stays locked, e.g. "15m"
+	LockoutDuration string
+}
+
+const (
+	defaultMinPasswordLength = 10
+	defaultMaxFailedLogins   = 5
+	defaultLockoutDuration   = 15 * time.Minute
+)
+
+var minPasswordLength = defaultMinPasswordLength
+var maxFailedLogins = defaultMaxFailedLogins
+var lockoutDuration = defaultLockoutDuration
+var adminBcryptCost = bcrypt.DefaultCost
+
+func configureAdmin(c AdminConfig) error {
+	minPasswordLength = defaultMinPasswordLength
+	if c.MinPasswordLength > 0 {
+		minPasswordLength = c.MinPasswordLength
+	}
+	maxFailedLogins = defaultMaxFailedLogins
+	if c.MaxFailedLogins > 0 {
+		maxFailedLogins = c.MaxFailedLogins
+	}
+	lockoutDuration = defaultLockoutDuration
+	if c.LockoutDuration != "" {
+		d, err := time.ParseDuration(c.LockoutDuration)
+		if err != nil {
+			return fmt.Errorf("LockoutDuration: %s", err.Error())
+		}
+		lockoutDuration = d
+	}
+	return nil
+}
+
+// Admin is an account that can sign in to the admin api
+type Admin struct {
+	Username string
+	// Roles grant permissions, accounts saved before roles existed have none and are admins
+	Roles []string
+	// Provider is the identity provider of accounts that sign in there, they have no password
+	Provider     string `json:",omitempty"`
+	Date         time.Time
+	LastLogin    *time.Time `json:",omitempty"`
+	FailedLogins int
+	LockedUntil  *time.Time `json:",omitempty"`
+}
+
+// Locked reports whether the account is locked out at t
+func (a Admin) Locked(t time.Time) bool {
+	return a.LockedUntil != nil && t.Before(*a.LockedUntil)
+}
+
+// adminRecord is an Admin as stored, keyed by username
+type adminRecord struct {
+	Admin
+	PasswordHash string
+}
+
+// AdminsByName is slice of Admin sorted by username
+type AdminsByName []Admin
+
+func (l AdminsByName) Len() int           { return len(l) }
+func (l AdminsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l AdminsByName) Less(i, j int) bool { return l[i].Username < l[j].Username }
+
+// Admin account errors
+var (
+	ErrAdminNotFound      = errors.New("admin was not found")
+	ErrAdminExists        = errors.New("admin already exists")
+	ErrLastAdmin          = errors.New("the last account with the admin role can not be deleted or lose it")
+	ErrInvalidCredentials = errors.New("incorrect username or password")
+	ErrAccountLocked      = errors.New("account is locked after too many failed logins, try again later")
+	ErrAdminProvider      = errors.New("admin signs in with a different method")
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._@+-]{0,127}$`)
+
+func normalizeUsername(username string) (string, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(username) {
+		return "", validationError(http.StatusBadRequest, "username", "username must be 1 to 128 letters, digits or . _ @ + -")
+	}
+	return username, nil
+}
+
+func hashPassword(password string) (string, error) {
+	return hashPasswordMin(password, minPasswordLength)
+}
+
+// hashPasswordMin is hashPassword with a minimum length of min characters
+func hashPasswordMin(password string, min int) (string, error) {
+	if len(password) < min {
+		return "", validationError(http.StatusBadRequest, "password", "password must be at least %d characters", min)
+	}
+	// bcrypt ignores everything after 72 bytes
+	if len(password) > 72 {
+		return "", validationError(http.StatusBadRequest, "password", "password must be at most 72 bytes")
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(password), adminBcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// dummyPasswordHash is compared against for unknown usernames so they take as long as known ones
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("sc2a-dummy-password"), bcrypt.DefaultCost)
+
+func getAdminRecord(tx *bolt.Tx, username string) (*adminRecord, error) {
+	v := tx.Bucket([]byte("admin")).Get([]byte(username))
+	if v == nil {
+		return nil, ErrAdminNotFound
+	}
+	return decodeAdminRecord(username, v)
+}
+
+func decodeAdminRecord(username string, v []byte) (*adminRecord, error) {
+	rec := adminRecord{}
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return nil, fmt.Errorf("unmarshal admin %s: %s", username, err.Error())
+	}
+	if len(rec.Roles) == 0 {
+		rec.Roles = []string{RoleAdmin}
+	}
+	return &rec, nil
+}
+
+// countRole returns the number of accounts with a role
+func countRole(tx *bolt.Tx, role string) (n int, err error) {
+	err = tx.Bucket([]byte("admin")).ForEach(func(k, v []byte) error {
+		rec, err := decodeAdminRecord(string(k), v)
+		if err != nil {
+			return err
+		}
+		if hasRole(rec.Roles, role) {
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+func putAdminRecord(tx *bolt.Tx, rec *adminRecord) error {
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("admin")).Put([]byte(rec.Username), j)
+}
+
+// ListAdmins returns all admin accounts
+func ListAdmins() (AdminsByName, error) {
+	list := AdminsByName{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("admin")).ForEach(func(k, v []byte) error {
+			rec, err := decodeAdminRecord(string(k), v)
+			if err != nil {
+				return err
+			}
+			list = append(list, rec.Admin)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(list)
+	return list, nil
+}
+
+// CountAdmins returns the number of admin accounts
+func CountAdmins() (n int, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte("admin")).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+// GetAdmin returns an admin account by username
+func GetAdmin(username string) (rv *Admin, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		rec, err := getAdminRecord(tx, strings.ToLower(strings.TrimSpace(username)))
+		if err != nil {
+			return err
+		}
+		rv = &rec.Admin
+		return nil
+	})
+	return
+}
+
+// CreateAdmin adds an admin account
+func CreateAdmin(username, password string, roles []string) (rv *Admin, err error) {
+	return createAdmin(username, password, roles, minPasswordLength)
+}
+
+func createAdmin(username, password string, roles []string, minPassword int) (rv *Admin, err error) {
+	if username, err = normalizeUsername(username); err != nil {
+		return
+	}
+	if roles, err = normalizeRoles(roles); err != nil {
+		return
+	}
+	hash, err := hashPasswordMin(password, minPassword)
+	if err != nil {
+		return
+	}
+	rec := &adminRecord{
+		Admin:        Admin{Username: username, Roles: roles, Date: time.Now()},
+		PasswordHash: hash,
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("admin")).Get([]byte(username)) != nil {
+			return ErrAdminExists
+		}
+		return putAdminRecord(tx, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec.Admin, nil
+}
+
+// BootstrapAdmin creates the first account with the admin role, it does nothing once any account exists.
+// The password comes from the config of earlier versions, so shorter passwords are accepted with a warning.
+func BootstrapAdmin(username, password string) (rv *Admin, err error) {
+	n, err := CountAdmins()
+	if err != nil || n > 0 {
+		return
+	}
+	if rv, err = createAdmin(username, password, []string{RoleAdmin}, 1); err != nil {
+		return
+	}
+	if len(password) < minPasswordLength {
+		log.Printf("admin %s has a password shorter than %d characters, change it", rv.Username, minPasswordLength)
+	}
+	return
+}
+
+// DeleteAdmin removes an admin account, the last one with the admin role is kept
+func DeleteAdmin(username string) error {
+	username = strings.ToLower(strings.TrimSpace(username))
+	return db.Update(func(tx *bolt.Tx) error {
+		rec, err := getAdminRecord(tx, username)
+		if err != nil {
+			return err
+		}
+		if hasRole(rec.Roles, RoleAdmin) {
+			n, err := countRole(tx, RoleAdmin)
+			if err != nil {
+				return err
+			}
+			if n <= 1 {
+				return ErrLastAdmin
+			}
+		}
+		return tx.Bucket([]byte("admin")).Delete([]byte(username))
+	})
+}
+
+// SetAdminRoles replaces the roles of an admin account, the last one with the admin role keeps it
+func SetAdminRoles(username string, roles []string) (rv *Admin, err error) {
+	if roles, err = normalizeRoles(roles); err != nil {
+		return
+	}
+	username = strings.ToLower(strings.TrimSpace(username))
+	err = db.Update(func(tx *bolt.Tx) error {
+		rec, err := getAdminRecord(tx, username)
+		if err != nil {
+			return err
+		}
+		if hasRole(rec.Roles, RoleAdmin) && !hasRole(roles, RoleAdmin) {
+			n, err := countRole(tx, RoleAdmin)
+			if err != nil {
+				return err
+			}
+			if n <= 1 {
+				return ErrLastAdmin
+			}
+		}
+		rec.Roles = roles
+		rv = &rec.Admin
+		return putAdminRecord(tx, rec)
+	})
+	return
+}
+
+// SetAdminPassword replaces the password of an admin account and unlocks it
+func SetAdminPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	username = strings.ToLower(strings.TrimSpace(username))
+	return db.Update(func(tx *bolt.Tx) error {
+		rec, err := getAdminRecord(tx, username)
+		if err != nil {
+			return err
+		}
+		if rec.Provider != "" {
+			return ErrAdminProvider
+		}
+		rec.PasswordHash = hash
+		rec.FailedLogins = 0
+		rec.LockedUntil = nil
+		return putAdminRecord(tx, rec)
+	})
+}
+
+// ChangeAdminPassword replaces the password of an admin account after checking the current one
+func ChangeAdminPassword(username, current, password string) error {
+	if _, err := Authenticate(username, current); err != nil {
+		return err
+	}
+	return SetAdminPassword(username, password)
+}
+
+// UnlockAdmin clears failed logins of an admin account
+func UnlockAdmin(username string) (rv *Admin, err error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	err = db.Update(func(tx *bolt.Tx) error {
+		rec, err := getAdminRecord(tx, username)
+		if err != nil {
+			return err
+		}
+		rec.FailedLogins = 0
+		rec.LockedUntil = nil
+		rv = &rec.Admin
+		return putAdminRecord(tx, rec)
+	})
+	return
+}
+
+// Authenticate checks a username and password.
+// After MaxFailedLogins failures in a row the account is locked for LockoutDuration,
+// and ErrAccountLocked is returned even for the right password until then.
+func Authenticate(username, password string) (rv *Admin, err error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	var rec *adminRecord
+	err = db.View(func(tx *bolt.Tx) error {
+		rec, err = getAdminRecord(tx, username)
+		return err
+	})
+	if err == ErrAdminNotFound || (err == nil && rec.Provider != "") {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if rec.Locked(now) {
+		return nil, ErrAccountLocked
+	}
+	ok := bcrypt.CompareHashAndPassword([]byte(rec.PasswordHash), []byte(password)) == nil
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		// reload, the account may have changed while the password was compared
+		rec, err := getAdminRecord(tx, username)
+		if err != nil {
+			return err
+		}
+		if rec.Locked(now) {
+			return ErrAccountLocked
+		}
+		if ok {
+			rec.FailedLogins = 0
+			rec.LockedUntil = nil
+			rec.LastLogin = &now
+		} else {
+			rec.FailedLogins++
+			if rec.FailedLogins >= maxFailedLogins {
+				until := now.Add(lockoutDuration)
+				rec.FailedLogins = 0
+				rec.LockedUntil = &until
+			}
+		}
+		rv = &rec.Admin
+		return putAdminRecord(tx, rec)
+	})
+	if err == ErrAdminNotFound {
+		err = ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return
+}
+
+// ProvisionAdmin creates or updates the account of someone who signed in with an identity provider.
+// The provider decides their roles on every sign in, accounts with a password are never taken over.
+func ProvisionAdmin(username, provider string, roles []string) (rv *Admin, err error) {
+	if username, err = normalizeUsername(username); err != nil {
+		return
+	}
+	if roles, err = normalizeRoles(roles); err != nil {
+		return
+	}
+	now := time.Now()
+	err = db.Update(func(tx *bolt.Tx) error {
+		rec, err := getAdminRecord(tx, username)
+		switch err {
+		case nil:
+			if rec.Provider != provider {
+				return ErrAdminProvider
+			}
+		case ErrAdminNotFound:
+			rec = &adminRecord{Admin: Admin{Username: username, Provider: provider, Date: now}}
+		default:
+			return err
+		}
+		rec.Roles = roles
+		rec.LastLogin = &now
+		rv = &rec.Admin
+		return putAdminRecord(tx, rec)
+	})
+	return
+}
@@ -0,0 +1,219 @@
+package dist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCreateAdmin(t *testing.T) {
+	adminBcryptCost = bcrypt.MinCost
+	defer func() { adminBcryptCost = bcrypt.DefaultCost }()
+
+	admin, err := CreateAdmin(" Alice ", "correct horse battery", []string{RoleAdmin})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", admin.Username)
+
+	_, err = CreateAdmin("alice", "another password", []string{RoleAdmin})
+	assert.Equal(t, ErrAdminExists, err)
+
+	_, err = CreateAdmin("bob", "short", []string{RoleAdmin})
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, "password", err.(*ValidationError).Code)
+	}
+	_, err = CreateAdmin("bob smith", "correct horse battery", []string{RoleAdmin})
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, "username", err.(*ValidationError).Code)
+	}
+
+	// the stored record holds a hash, never the password
+	err = db.View(func(tx *bolt.Tx) error {
+		rec, err := getAdminRecord(tx, "alice")
+		if err != nil {
+			return err
+		}
+		assert.NotContains(t, rec.PasswordHash, "correct horse battery")
+		assert.Nil(t, bcrypt.CompareHashAndPassword([]byte(rec.PasswordHash), []byte("correct horse battery")))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	admin, err = BootstrapAdmin("carol", "correct horse battery")
+	assert.Nil(t, err)
+	assert.Nil(t, admin)
+
+	// the first admin from an old config keeps its short password
+	admin, err = createAdmin("legacy", "short", []string{RoleAdmin}, 1)
+	assert.Nil(t, err)
+	defer DeleteAdmin("legacy")
+	_, err = Authenticate("legacy", "short")
+	assert.Nil(t, err)
+}
+
+func TestAuthenticateLockout(t *testing.T) {
+	adminBcryptCost = bcrypt.MinCost
+	defer func() { adminBcryptCost = bcrypt.DefaultCost }()
+
+	_, err := CreateAdmin("dave", "correct horse battery", []string{RoleViewer})
+	assert.Nil(t, err)
+	defer DeleteAdmin("dave")
+
+	admin, err := Authenticate("Dave", "correct horse battery")
+	assert.Nil(t, err)
+	if assert.NotNil(t, admin) {
+		assert.NotNil(t, admin.LastLogin)
+	}
+
+	_, err = Authenticate("nobody", "correct horse battery")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	for i := 0; i < maxFailedLogins-1; i++ {
+		_, err = Authenticate("dave", "wrong password")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+	// a successful login resets the count
+	_, err = Authenticate("dave", "correct horse battery")
+	assert.Nil(t, err)
+
+	for i := 0; i < maxFailedLogins; i++ {
+		_, err = Authenticate("dave", "wrong password")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+	_, err = Authenticate("dave", "correct horse battery")
+	assert.Equal(t, ErrAccountLocked, err)
+	admin, err = GetAdmin("dave")
+	assert.Nil(t, err)
+	assert.True(t, admin.Locked(time.Now()))
+	assert.False(t, admin.Locked(time.Now().Add(lockoutDuration)))
+
+	_, err = UnlockAdmin("dave")
+	assert.Nil(t, err)
+	_, err = Authenticate("dave", "correct horse battery")
+	assert.Nil(t, err)
+}
+
+func TestChangeAdminPassword(t *testing.T) {
+	adminBcryptCost = bcrypt.MinCost
+	defer func() { adminBcryptCost = bcrypt.DefaultCost }()
+
+	_, err := CreateAdmin("erin", "correct horse battery", []string{RolePublisher})
+	assert.Nil(t, err)
+	defer DeleteAdmin("erin")
+
+	assert.Equal(t, ErrInvalidCredentials, ChangeAdminPassword("erin", "wrong password", "tr0ub4dor and 3"))
+	assert.Nil(t, ChangeAdminPassword("erin", "correct horse battery", "tr0ub4dor and 3"))
+
+	_, err = Authenticate("erin", "correct horse battery")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = Authenticate("erin", "tr0ub4dor and 3")
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrAdminNotFound, SetAdminPassword("nobody", "tr0ub4dor and 3"))
+}
+
+func TestDeleteLastAdmin(t *testing.T) {
+	adminBcryptCost = bcrypt.MinCost
+	defer func() { adminBcryptCost = bcrypt.DefaultCost }()
+
+	list, err := ListAdmins()
+	assert.Nil(t, err)
+	for _, a := range list[1:] {
+		assert.Nil(t, DeleteAdmin(a.Username))
+	}
+	if len(list) == 0 {
+		_, err = CreateAdmin("frank", "correct horse battery", []string{RoleAdmin})
+		assert.Nil(t, err)
+	}
+	list, err = ListAdmins()
+	assert.Nil(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, ErrLastAdmin, DeleteAdmin(list[0].Username))
+	}
+	assert.Equal(t, ErrAdminNotFound, DeleteAdmin("nobody"))
+}
+
+func TestAdminRoles(t *testing.T) {
+	adminBcryptCost = bcrypt.MinCost
+	defer func() { adminBcryptCost = bcrypt.DefaultCost }()
+
+	_, err := CreateAdmin("grace", "correct horse battery", nil)
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, "role", err.(*ValidationError).Code)
+	}
+	_, err = CreateAdmin("grace", "correct horse battery", []string{"owner"})
+	assert.IsType(t, &ValidationError{}, err)
+
+	admin, err := CreateAdmin("grace", "correct horse battery", []string{" Publisher", RoleViewer, RolePublisher})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RolePublisher, RoleViewer}, admin.Roles)
+	assert.True(t, RolesAllow(admin.Roles, PermReleaseWrite))
+	assert.True(t, RolesAllow(admin.Roles, PermSubRead))
+	assert.False(t, RolesAllow(admin.Roles, PermSubWrite))
+	assert.False(t, RolesAllow(admin.Roles, PermAdmin))
+
+	admin, err = SetAdminRoles("grace", []string{RoleAdmin})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RoleAdmin}, admin.Roles)
+	assert.True(t, RolesAllow(admin.Roles, PermAdmin))
+
+	// with two admins either can be demoted, but not both
+	list, err := ListAdmins()
+	assert.Nil(t, err)
+	for _, a := range list {
+		if a.Username == "grace" || !hasRole(a.Roles, RoleAdmin) {
+			continue
+		}
+		_, err = SetAdminRoles(a.Username, []string{RoleViewer})
+		assert.Nil(t, err)
+		defer SetAdminRoles(a.Username, []string{RoleAdmin})
+	}
+	_, err = SetAdminRoles("grace", []string{RoleViewer})
+	assert.Equal(t, ErrLastAdmin, err)
+	assert.Equal(t, ErrLastAdmin, DeleteAdmin("grace"))
+
+	_, err = SetAdminRoles("nobody", []string{RoleViewer})
+	assert.Equal(t, ErrAdminNotFound, err)
+}
+
+func TestLegacyAdminIsAdmin(t *testing.T) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("admin")).Put([]byte("henry"), []byte(`{"Username":"henry","PasswordHash":"x"}`))
+	})
+	assert.Nil(t, err)
+	admin, err := GetAdmin("henry")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RoleAdmin}, admin.Roles)
+	assert.Nil(t, DeleteAdmin("henry"))
+}
+
+func TestProvisionAdmin(t *testing.T) {
+	admin, err := ProvisionAdmin("Ivy+ops@example.com", "https://id.example.com", []string{RoleViewer})
+	assert.Nil(t, err)
+	assert.Equal(t, "ivy+ops@example.com", admin.Username)
+	assert.NotNil(t, admin.LastLogin)
+
+	// the provider's roles replace earlier ones
+	admin, err = ProvisionAdmin("ivy+ops@example.com", "https://id.example.com", []string{RolePublisher})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RolePublisher}, admin.Roles)
+
+	_, err = ProvisionAdmin("ivy+ops@example.com", "https://other.example.com", []string{RolePublisher})
+	assert.Equal(t, ErrAdminProvider, err)
+	_, err = Authenticate("ivy+ops@example.com", "")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	assert.Equal(t, ErrAdminProvider, SetAdminPassword("ivy+ops@example.com", "correct horse battery"))
+
+	// accounts with a password are not taken over
+	adminBcryptCost = bcrypt.MinCost
+	defer func() { adminBcryptCost = bcrypt.DefaultCost }()
+	_, err = CreateAdmin("jack@example.com", "correct horse battery", []string{RoleViewer})
+	assert.Nil(t, err)
+	_, err = ProvisionAdmin("jack@example.com", "https://id.example.com", []string{RoleAdmin})
+	assert.Equal(t, ErrAdminProvider, err)
+
+	assert.Nil(t, DeleteAdmin("ivy+ops@example.com"))
+	assert.Nil(t, DeleteAdmin("jack@example.com"))
+}
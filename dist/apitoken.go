@@ -0,0 +1,231 @@
+package dist
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+)
+
+// APITokenPrefix starts every api token, so they can be told apart from session JWTs
+const APITokenPrefix = "sc2a_"
+
+// lastUsedInterval limits how often using a token is written to db
+const lastUsedInterval = time.Minute
+
+// APIToken is a long lived token for scripts, granting a set of permissions as scopes.
+// CreatedBy is the admin or api token that created it, CreatedByType tells which.
+type APIToken struct {
+	ID            string
+	Name          string
+	Scopes        []string
+	CreatedBy     string
+	CreatedByType string
+	Date          time.Time
+	LastUsed      *time.Time `json:",omitempty"`
+	Expires       *time.Time `json:",omitempty"`
+}
+
+// Expired reports whether the token has expired at t
+func (t APIToken) Expired(at time.Time) bool {
+	return t.Expires != nil && !at.Before(*t.Expires)
+}
+
+// Allows reports whether the token has a scope
+func (t APIToken) Allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenRecord is an APIToken as stored, keyed by id
+type apiTokenRecord struct {
+	APIToken
+	Hash string
+}
+
+// APITokensByDate is slice of APIToken sorted by date
+type APITokensByDate []APIToken
+
+func (l APITokensByDate) Len() int           { return len(l) }
+func (l APITokensByDate) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l APITokensByDate) Less(i, j int) bool { return l[i].Date.Before(l[j].Date) }
+
+// API token errors
+var (
+	ErrAPITokenNotFound = errors.New("api token was not found")
+	ErrAPITokenInvalid  = errors.New("api token is invalid, revoked or has expired")
+)
+
+func normalizeScopes(scopes []string) ([]string, error) {
+	rv := []string{}
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !isPermission(scope) {
+			return nil, validationError(http.StatusBadRequest, "scope", "unknown scope %q, must be one of %s", scope, strings.Join(Permissions(), ", "))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			rv = append(rv, scope)
+		}
+	}
+	if len(rv) == 0 {
+		return nil, validationError(http.StatusBadRequest, "scope", "at least one scope is required")
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
+// splitAPIToken returns the id and secret of a token
+func splitAPIToken(token string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, APITokenPrefix), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// CreateAPIToken adds a token, the returned token string is shown once and only its hash is kept.
+// createdByType is ActorAdmin or ActorAPIToken, like the actor of an audit entry.
+func CreateAPIToken(name string, scopes []string, expires *time.Time, createdBy, createdByType string) (token string, rv *APIToken, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, validationError(http.StatusBadRequest, "name", "name is required")
+	}
+	if scopes, err = normalizeScopes(scopes); err != nil {
+		return
+	}
+	now := time.Now()
+	if expires != nil && !expires.After(now) {
+		return "", nil, validationError(http.StatusBadRequest, "expires", "expiry must be in the future")
+	}
+	secret, err := newToken()
+	if err != nil {
+		return
+	}
+	rec := apiTokenRecord{
+		APIToken: APIToken{
+			ID:            strings.Replace(uuid.NewV4().String(), "-", "", -1),
+			Name:          name,
+			Scopes:        scopes,
+			CreatedBy:     createdBy,
+			CreatedByType: createdByType,
+			Date:          now,
+			Expires:       expires,
+		},
+		Hash: string(hashToken(secret)),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return putAPITokenRecord(tx, &rec)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return APITokenPrefix + rec.ID + "." + secret, &rec.APIToken, nil
+}
+
+func putAPITokenRecord(tx *bolt.Tx, rec *apiTokenRecord) error {
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("api_token")).Put([]byte(rec.ID), j)
+}
+
+func getAPITokenRecord(tx *bolt.Tx, id string) (*apiTokenRecord, error) {
+	v := tx.Bucket([]byte("api_token")).Get([]byte(id))
+	if v == nil {
+		return nil, ErrAPITokenNotFound
+	}
+	rec := apiTokenRecord{}
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return nil, fmt.Errorf("unmarshal api token %s: %s", id, err.Error())
+	}
+	return &rec, nil
+}
+
+// ListAPITokens returns all tokens, without their hashes
+func ListAPITokens() (APITokensByDate, error) {
+	list := APITokensByDate{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("api_token")).ForEach(func(k, v []byte) error {
+			rec := apiTokenRecord{}
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("unmarshal api token %s: %s", string(k), err.Error())
+			}
+			list = append(list, rec.APIToken)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(list)
+	return list, nil
+}
+
+// RevokeAPIToken deletes a token
+func RevokeAPIToken(id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("api_token"))
+		if b.Get([]byte(id)) == nil {
+			return ErrAPITokenNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// AuthenticateAPIToken checks a token and records when it was last used
+func AuthenticateAPIToken(token string) (rv *APIToken, err error) {
+	id, secret, ok := splitAPIToken(token)
+	if !ok {
+		return nil, ErrAPITokenInvalid
+	}
+	now := time.Now()
+	var rec *apiTokenRecord
+	err = db.View(func(tx *bolt.Tx) error {
+		rec, err = getAPITokenRecord(tx, id)
+		return err
+	})
+	if err == ErrAPITokenNotFound {
+		return nil, ErrAPITokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(hashToken(secret), []byte(rec.Hash)) != 1 || rec.Expired(now) {
+		return nil, ErrAPITokenInvalid
+	}
+	if rec.LastUsed == nil || now.Sub(*rec.LastUsed) >= lastUsedInterval {
+		err = db.Update(func(tx *bolt.Tx) error {
+			rec, err := getAPITokenRecord(tx, id)
+			if err != nil {
+				return err
+			}
+			rec.LastUsed = &now
+			return putAPITokenRecord(tx, rec)
+		})
+		if err == ErrAPITokenNotFound {
+			return nil, ErrAPITokenInvalid
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.LastUsed = &now
+	}
+	return &rec.APIToken, nil
+}
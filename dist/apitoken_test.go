@@ -0,0 +1,97 @@
+package dist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIToken(t *testing.T) {
+	token, created, err := CreateAPIToken("ci", []string{" Release:Write", PermReleaseWrite}, nil, "alice", ActorAdmin)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{PermReleaseWrite}, created.Scopes)
+	assert.Equal(t, "alice", created.CreatedBy)
+	assert.Equal(t, ActorAdmin, created.CreatedByType)
+	assert.True(t, created.Allows(PermReleaseWrite))
+	assert.False(t, created.Allows(PermReleaseRead))
+
+	// only a hash of the token is stored
+	err = db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte("api_token")).Get([]byte(created.ID))
+		_, secret, _ := splitAPIToken(token)
+		assert.NotContains(t, string(v), secret)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	used, err := AuthenticateAPIToken(token)
+	assert.Nil(t, err)
+	if assert.NotNil(t, used) && assert.NotNil(t, used.LastUsed) {
+		assert.Equal(t, created.ID, used.ID)
+	}
+
+	_, err = AuthenticateAPIToken(token + "x")
+	assert.Equal(t, ErrAPITokenInvalid, err)
+	_, err = AuthenticateAPIToken("sc2a_" + created.ID)
+	assert.Equal(t, ErrAPITokenInvalid, err)
+	_, err = AuthenticateAPIToken("not a token")
+	assert.Equal(t, ErrAPITokenInvalid, err)
+
+	list, err := ListAPITokens()
+	assert.Nil(t, err)
+	found := false
+	for _, l := range list {
+		if l.ID == created.ID {
+			found = true
+			assert.NotNil(t, l.LastUsed)
+		}
+	}
+	assert.True(t, found)
+
+	assert.Nil(t, RevokeAPIToken(created.ID))
+	_, err = AuthenticateAPIToken(token)
+	assert.Equal(t, ErrAPITokenInvalid, err)
+	assert.Equal(t, ErrAPITokenNotFound, RevokeAPIToken(created.ID))
+}
+
+func TestAPITokenExpiry(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	_, _, err := CreateAPIToken("ci", []string{PermReleaseWrite}, &past, "alice", ActorAdmin)
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, "expires", err.(*ValidationError).Code)
+	}
+
+	future := time.Now().Add(time.Hour)
+	token, created, err := CreateAPIToken("ci", []string{PermReleaseWrite}, &future, "alice", ActorAdmin)
+	assert.Nil(t, err)
+	_, err = AuthenticateAPIToken(token)
+	assert.Nil(t, err)
+	assert.False(t, created.Expired(time.Now()))
+	assert.True(t, created.Expired(future))
+
+	// expire it in place
+	err = db.Update(func(tx *bolt.Tx) error {
+		rec, err := getAPITokenRecord(tx, created.ID)
+		if err != nil {
+			return err
+		}
+		rec.Expires = &past
+		return putAPITokenRecord(tx, rec)
+	})
+	assert.Nil(t, err)
+	_, err = AuthenticateAPIToken(token)
+	assert.Equal(t, ErrAPITokenInvalid, err)
+}
+
+func TestAPITokenValidation(t *testing.T) {
+	_, _, err := CreateAPIToken("", []string{PermReleaseWrite}, nil, "alice", ActorAdmin)
+	assert.IsType(t, &ValidationError{}, err)
+	_, _, err = CreateAPIToken("ci", nil, nil, "alice", ActorAdmin)
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, "scope", err.(*ValidationError).Code)
+	}
+	_, _, err = CreateAPIToken("ci", []string{"release:delete"}, nil, "alice", ActorAdmin)
+	assert.IsType(t, &ValidationError{}, err)
+}
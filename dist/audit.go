@@ -0,0 +1,175 @@
+package dist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Kinds of audit actors
+const (
+	ActorAdmin    = "admin"
+	ActorAPIToken = "api_token"
+)
+
+// AuditEntry records one mutating admin api call
+type AuditEntry struct {
+	ID        uint64
+	Date      time.Time
+	Actor     string
+	ActorType string
+	// Action is the method and route, e.g. "DELETE /api/release/:id"
+	Action   string
+	TargetID string `json:",omitempty"`
+	Status   int
+	IP       string
+	Before   json.RawMessage `json:",omitempty"`
+	After    json.RawMessage `json:",omitempty"`
+}
+
+// AuditFilter selects audit entries, empty fields match everything
+type AuditFilter struct {
+	Actor    string
+	TargetID string
+	// Action matches entries whose action starts with it, e.g. "DELETE" or "PUT /api/sub"
+	Action string
+	Since  *time.Time
+	Until  *time.Time
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.TargetID == "" || e.TargetID == f.TargetID) &&
+		strings.HasPrefix(e.Action, f.Action) &&
+		(f.Since == nil || !e.Date.Before(*f.Since)) &&
+		(f.Until == nil || e.Date.Before(*f.Until))
+}
+
+func auditKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// RecordAudit appends an entry to the audit log, which is never pruned.
+// Entries are only rewritten to drop the snapshots of an erased subscriber.
+func RecordAudit(e AuditEntry) error {
+	if e.Date.IsZero() {
+		e.Date = time.Now()
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("audit"))
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(auditKey(id), j)
+	})
+}
+
+// scrubAuditSnapshots drops the Before and After snapshots of entries about a subscriber
+func scrubAuditSnapshots(tx *bolt.Tx, subID string) error {
+	b := tx.Bucket([]byte("audit"))
+	updated := map[string][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		e := AuditEntry{}
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		if e.TargetID != subID || !strings.Contains(e.Action, "/api/sub") || (e.Before == nil && e.After == nil) {
+			return nil
+		}
+		e.Before, e.After = nil, nil
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		updated[string(k)] = j
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, v := range updated {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListAudit returns a page of matching audit entries, newest first
+func ListAudit(filter AuditFilter, opts PageOptions) (list []AuditEntry, info *PageInfo, err error) {
+	if opts.Limit < 0 || opts.Limit > maxPageLimit {
+		return nil, nil, validationError(http.StatusBadRequest, "limit", "limit must be between 0 and %d", maxPageLimit)
+	}
+	var cursor []byte
+	if opts.Cursor != "" {
+		if cursor, err = base64.RawURLEncoding.DecodeString(opts.Cursor); err != nil || len(cursor) != 8 {
+			return nil, nil, validationError(http.StatusBadRequest, "cursor", "cursor is invalid")
+		}
+	}
+
+	list = []AuditEntry{}
+	info = &PageInfo{}
+	err = db.View(func(tx *bolt.Tx) error {
+		var last []byte
+		c := tx.Bucket([]byte("audit")).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			e := AuditEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("unmarshal audit entry %d: %s", binary.BigEndian.Uint64(k), err.Error())
+			}
+			if !filter.match(e) {
+				continue
+			}
+			info.Total++
+			if cursor != nil && bytes.Compare(k, cursor) >= 0 {
+				continue
+			}
+			if opts.Limit > 0 && len(list) >= opts.Limit {
+				if info.Next == "" {
+					info.Next = base64.RawURLEncoding.EncodeToString(last)
+				}
+				continue
+			}
+			list = append(list, e)
+			last = append(last[:0], k...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// ExportAudit writes matching audit entries as json lines, oldest first
+func ExportAudit(w io.Writer, filter AuditFilter) error {
+	enc := json.NewEncoder(w)
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("audit")).ForEach(func(k, v []byte) error {
+			e := AuditEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("unmarshal audit entry %d: %s", binary.BigEndian.Uint64(k), err.Error())
+			}
+			if !filter.match(e) {
+				return nil
+			}
+			return enc.Encode(e)
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package dist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudit(t *testing.T) {
+	start := time.Now()
+	entries := []AuditEntry{
+		{Actor: "audit-alice", ActorType: ActorAdmin, Action: "DELETE /api/release/:id", TargetID: "r1", Status: 204, IP: "10.0.0.1", Before: json.RawMessage(`{"ID":"r1"}`)},
+		{Actor: "audit-token", ActorType: ActorAPIToken, Action: "POST /api/release", TargetID: "r2", Status: 200, IP: "10.0.0.2", After: json.RawMessage(`{"ID":"r2"}`)},
+		{Actor: "audit-alice", ActorType: ActorAdmin, Action: "PUT /api/sub", TargetID: "s1", Status: 200, IP: "10.0.0.1"},
+	}
+	for _, e := range entries {
+		assert.Nil(t, RecordAudit(e))
+	}
+
+	list, info, err := ListAudit(AuditFilter{Actor: "audit-alice"}, PageOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, info.Total)
+	if assert.Len(t, list, 2) {
+		// newest first
+		assert.Equal(t, "s1", list[0].TargetID)
+		assert.Equal(t, "r1", list[1].TargetID)
+		assert.True(t, list[0].ID > list[1].ID)
+		assert.JSONEq(t, `{"ID":"r1"}`, string(list[1].Before))
+		assert.False(t, list[1].Date.Before(start.Add(-time.Second)))
+	}
+
+	list, _, err = ListAudit(AuditFilter{Action: "POST /api/release"}, PageOptions{})
+	assert.Nil(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, ActorAPIToken, list[0].ActorType)
+	}
+	list, _, err = ListAudit(AuditFilter{TargetID: "r1"}, PageOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+	future := time.Now().Add(time.Hour)
+	list, _, err = ListAudit(AuditFilter{Actor: "audit-alice", Since: &future}, PageOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, list, 0)
+	list, _, err = ListAudit(AuditFilter{Actor: "audit-alice", Until: &future}, PageOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+
+	// paging
+	filter := AuditFilter{Since: &start}
+	first, info, err := ListAudit(filter, PageOptions{Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, info.Total)
+	assert.Len(t, first, 2)
+	if assert.NotEmpty(t, info.Next) {
+		rest, info, err := ListAudit(filter, PageOptions{Limit: 2, Cursor: info.Next})
+		assert.Nil(t, err)
+		assert.Empty(t, info.Next)
+		if assert.Len(t, rest, 1) {
+			assert.Equal(t, "r1", rest[0].TargetID)
+		}
+	}
+	_, _, err = ListAudit(filter, PageOptions{Cursor: "x"})
+	assert.IsType(t, &ValidationError{}, err)
+
+	// export is oldest first
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, ExportAudit(buf, filter))
+	s := bufio.NewScanner(buf)
+	targets := []string{}
+	for s.Scan() {
+		e := AuditEntry{}
+		assert.Nil(t, json.Unmarshal(s.Bytes(), &e))
+		targets = append(targets, e.TargetID)
+	}
+	assert.Equal(t, []string{"r1", "r2", "s1"}, targets)
+}
@@ -0,0 +1,115 @@
+package dist
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats of stored release files, named after their http content codings
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var compression string
+
+func checkCompression(c string) error {
+	switch c {
+	case "", CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unsupported compression: %s", c)
+}
+
+func newCompressWriter(w io.Writer, c string) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	}
+	return nil, checkCompression(c)
+}
+
+func newDecompressReader(r io.Reader, c string) (io.ReadCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, checkCompression(c)
+}
+
+// compressStaged writes a compressed copy of a staged upload next to it.
+// It returns nil when compression does not make the file smaller.
+func compressStaged(staged *os.File, size int64, c string) (rv *os.File, err error) {
+	if _, err = staged.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	f, err := ioutil.TempFile(stagingDir(), "compress")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil || rv == nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	w, err := newCompressWriter(f, c)
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(w, staged); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if info.Size() >= size {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// AcceptsEncoding reports whether an Accept-Encoding header allows a content coding
+func AcceptsEncoding(header, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch name {
+		case coding:
+			return q > 0
+		case "*":
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
@@ -0,0 +1,90 @@
+package dist
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	assert.True(t, AcceptsEncoding("gzip, deflate, br", "gzip"))
+	assert.True(t, AcceptsEncoding("GZIP;q=0.5", "gzip"))
+	assert.True(t, AcceptsEncoding("*", "zstd"))
+	assert.False(t, AcceptsEncoding("gzip;q=0, *", "gzip"))
+	assert.False(t, AcceptsEncoding("gzip, deflate", "zstd"))
+	assert.False(t, AcceptsEncoding("", "gzip"))
+}
+
+func TestPublishCompressed(t *testing.T) {
+	defer func(c string) { compression = c }(compression)
+	data := strings.Repeat("SC2Advanced data pack ", 1000)
+	sum := sha256.Sum256([]byte(data))
+
+	for _, c := range []string{CompressionGzip, CompressionZstd} {
+		compression = c
+		r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString(data))
+		assert.NoError(t, err)
+		assert.Equal(t, c, r.Compression)
+		assert.Equal(t, hex.EncodeToString(sum[:]), r.SHA256)
+		assert.Equal(t, int64(len(data)), r.Size)
+
+		out := bytes.NewBuffer(nil)
+		assert.NoError(t, Stream(r.ID, out))
+		assert.Equal(t, data, out.String())
+
+		out.Reset()
+		assert.NoError(t, StreamRaw(r.ID, out))
+		assert.True(t, out.Len() < len(data))
+		dr, err := newDecompressReader(out, c)
+		assert.NoError(t, err)
+		decoded, err := ioutil.ReadAll(dr)
+		assert.NoError(t, err)
+		assert.Equal(t, data, string(decoded))
+
+		assert.NoError(t, Unpublish(r.ID))
+	}
+}
+
+func TestPublishCompressedSkipped(t *testing.T) {
+	defer func(c string) { compression = c }(compression)
+	compression = CompressionZstd
+
+	random := make([]byte, 4096)
+	_, err := rand.Read(random)
+	assert.NoError(t, err)
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewReader(random))
+	assert.NoError(t, err)
+	assert.Equal(t, "", r.Compression)
+	assert.NoError(t, Unpublish(r.ID))
+
+	r, err = Publish(Release{Version: "0.0.1"}, bytes.NewReader(testZip(t)))
+	assert.NoError(t, err)
+	assert.Equal(t, "", r.Compression)
+	assert.NoError(t, Unpublish(r.ID))
+}
+
+func TestPublishCompressedEncrypted(t *testing.T) {
+	defer func(c string, kr *keyring) { compression, encryptionKeys = c, kr }(compression, encryptionKeys)
+	compression = CompressionGzip
+	encryptionKeys = testKeyring(t, EncryptionConfig{Key: testEncryptionKey(t)})
+
+	data := strings.Repeat("SC2Advanced data pack ", 1000)
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString(data))
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionGzip, r.Compression)
+
+	raw, err := ioutil.ReadFile(dataFilePath(r.ID + ".dat"))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(raw, []byte(encMagic)))
+
+	out := bytes.NewBuffer(nil)
+	assert.NoError(t, Stream(r.ID, out))
+	assert.Equal(t, data, out.String())
+	assert.NoError(t, Unpublish(r.ID))
+}
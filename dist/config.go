@@ -2,6 +2,9 @@ package dist
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
+	"time"
 
 	"html/template"
 
@@ -20,21 +23,102 @@ type Config struct {
 	FilenameTemplate           string
 	NotifyEmailSubjectTemplate string
 	NotifyEmailContentTemplate string
-	Mailgun                    struct {
+	// NotifyTemplates override the notification templates by locale, e.g. "zh" or "zh-tw"
+	NotifyTemplates map[string]NotifyTemplateConfig
+	Mailgun         struct {
 		Domain string
-		APIKey string
+		APIKey string `secret:"true"`
+		// WebhookSigningKey verifies webhook events, APIKey is used if empty
+		WebhookSigningKey string `secret:"true"`
 	}
+	Upload     UploadConfig
+	Scan       ScanConfig
+	Encryption EncryptionConfig
+	// Compression is the content coding used to store releases: "gzip", "zstd" or empty
+	Compression  string
+	Subscription SubscriptionConfig
+	Admin        AdminConfig
+	// LinkSecret signs public links such as unsubscribe links, generated and stored in db if empty
+	LinkSecret string `secret:"true"`
+	// DataFile is the database file, defaults to ./data/sc2a.db
+	DataFile string
+	// DataDir stores release files, defaults to ./data/release
+	DataDir string
 }
 
+// Default data paths, relative to the working directory
+const (
+	DefaultDataFile = "./data/sc2a.db"
+	DefaultDataDir  = "./data/release"
+)
+
+// CheckConfig reports the first invalid setting of c without applying it,
+// settings that need files or services such as Encryption and Scan are checked by Configure
+func CheckConfig(c Config) error {
+	if err := checkCompression(c.Compression); err != nil {
+		return fmt.Errorf("Compression: %s", err.Error())
+	}
+	if _, err := normalizeTags(c.Subscription.Channels); err != nil {
+		return fmt.Errorf("Subscription.Channels: %s", err.Error())
+	}
+	if c.Admin.LockoutDuration != "" {
+		if _, err := time.ParseDuration(c.Admin.LockoutDuration); err != nil {
+			return fmt.Errorf("Admin.LockoutDuration: %s", err.Error())
+		}
+	}
+	if _, err := parseSettings(c); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fileConfig is the Config given to Configure or ReloadConfig, settings stored in the config bucket override it
+var fileConfig Config
+
 // Configure initializes this package
 func Configure(baseURI string, c Config) {
-	mg = mailgun.NewMailgun(c.Mailgun.Domain, c.Mailgun.APIKey, "")
+	var err error
+	scanner, err = newScanner(c.Scan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encryptionKeys, err = loadKeyring(c.Encryption)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = checkCompression(c.Compression); err != nil {
+		log.Fatal(err)
+	}
+	compression = c.Compression
+	if err = configureSubscription(baseURI, c.Subscription); err != nil {
+		log.Fatal(err)
+	}
+	if err = configureAdmin(c.Admin); err != nil {
+		log.Fatal(err)
+	}
 	makeLink = func(id string) string {
 		return baseURI + "/download/" + id
 	}
-	nameTemplate = template.Must(template.New("nameTemplate").Parse(c.FilenameTemplate))
-	notifyEmailSubjectTemplate = template.Must(template.New("notifyEmailSubjectTemplate").Parse(c.NotifyEmailSubjectTemplate))
-	notifyEmailContentTemplate = template.Must(template.New("notifyEmailContentTemplate").Parse(c.NotifyEmailContentTemplate))
+	makeUnsubscribeLink = func(token string) string {
+		return baseURI + "/unsubscribe?token=" + token
+	}
+	if c.LinkSecret != "" {
+		linkSecret = []byte(c.LinkSecret)
+	}
+	uploadConfig = c.Upload
+	dataFile, dataDir = DefaultDataFile, DefaultDataDir
+	if c.DataFile != "" {
+		dataFile = c.DataFile
+	}
+	if c.DataDir != "" {
+		dataDir = c.DataDir
+	}
+	fileConfig = c
+	s, err := parseSettings(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.apply()
 }
 
 // ConfigMap is a alias of map[string]interface{}
@@ -60,11 +144,17 @@ func GetAllConfig() (ConfigMap, error) {
 	return rv, nil
 }
 
-// UpdateConfig merges submit conifg values with db
+// UpdateConfig merges submit conifg values with db, a nil value removes its key
 func UpdateConfig(values ConfigMap) error {
 	return db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("config"))
 		for k, v := range values {
+			if v == nil {
+				if err := b.Delete([]byte(k)); err != nil {
+					return err
+				}
+				continue
+			}
 			j, err := json.Marshal(v)
 			if err != nil {
 				return err
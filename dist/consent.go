@@ -0,0 +1,74 @@
+package dist
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Consent events recorded for a subscriber
+const (
+	ConsentAdded        = "added"
+	ConsentImported     = "imported"
+	ConsentRequested    = "requested"
+	ConsentConfirmed    = "confirmed"
+	ConsentPreferences  = "preferences-updated"
+	ConsentEmailChanged = "email-changed"
+	ConsentStatus       = "status-changed"
+	ConsentUnsubscribed = "unsubscribed"
+)
+
+// ConsentEvent records a change of what a subscriber agreed to receive
+type ConsentEvent struct {
+	Event  string
+	Detail string `json:",omitempty"`
+	Date   time.Time
+}
+
+// recordConsent appends an event to the subscriber's bucket in the consent bucket
+func recordConsent(tx *bolt.Tx, subID, event, detail string) error {
+	b, err := tx.Bucket([]byte("consent")).CreateBucketIfNotExists([]byte(subID))
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(ConsentEvent{Event: event, Detail: detail, Date: time.Now()})
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return b.Put(key, j)
+}
+
+func listConsent(tx *bolt.Tx, subID string) ([]ConsentEvent, error) {
+	rv := []ConsentEvent{}
+	b := tx.Bucket([]byte("consent")).Bucket([]byte(subID))
+	if b == nil {
+		return rv, nil
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		e := ConsentEvent{}
+		if err := json.Unmarshal(v, &e); err != nil {
+			return fmt.Errorf("unmarshal consent event: %s", err.Error())
+		}
+		rv = append(rv, e)
+		return nil
+	})
+	return rv, err
+}
+
+// deleteConsent removes all consent events of a subscriber
+func deleteConsent(tx *bolt.Tx, subID string) error {
+	b := tx.Bucket([]byte("consent"))
+	if b.Bucket([]byte(subID)) == nil {
+		return nil
+	}
+	return b.DeleteBucket([]byte(subID))
+}
@@ -0,0 +1,568 @@
+package dist
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EncryptionConfig enables encryption at rest of release files.
+// Keys are 32 bytes, base64 encoded in config or raw/base64 in a key file.
+// Previous keys are only used to read files written before a key rotation.
+type EncryptionConfig struct {
+	Key              string `secret:"true"`
+	KeyFile          string
+	PreviousKeys     []string `secret:"true"`
+	PreviousKeyFiles []string
+}
+
+// Encrypted files are laid out as
+//
+//	magic | master key id | chunk size | wrapped data key | chunk 0 | chunk 1 | ...
+//
+// Every chunk is sealed with AES-GCM under a random per-file data key, the data
+// key itself is sealed with the master key so rotation only rewrites the header.
+const (
+	encMagic      = "SC2AENC\x01"
+	encKeyIDLen   = 8
+	encChunkSize  = 64 * 1024
+	encWrappedLen = 12 + 32 + 16
+	encHeaderLen  = len(encMagic) + encKeyIDLen + 4 + encWrappedLen
+	encOverhead   = 16
+)
+
+// ErrCorruptEncryptedFile is returned when an encrypted file fails authentication
+var ErrCorruptEncryptedFile = errors.New("encrypted file is corrupt")
+
+type masterKey struct {
+	ID   string
+	aead cipher.AEAD
+}
+
+type keyring struct {
+	current *masterKey
+	keys    map[string]*masterKey
+}
+
+var encryptionKeys = &keyring{keys: map[string]*masterKey{}}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newMasterKey(key []byte) (*masterKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &masterKey{
+		ID:   hex.EncodeToString(sum[:encKeyIDLen/2]),
+		aead: aead,
+	}, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	return decodeKey(string(data))
+}
+
+func loadKeyring(c EncryptionConfig) (*keyring, error) {
+	kr := &keyring{keys: map[string]*masterKey{}}
+	add := func(key []byte, err error) (*masterKey, error) {
+		if err != nil {
+			return nil, fmt.Errorf("encryption key: %s", err.Error())
+		}
+		mk, err := newMasterKey(key)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[mk.ID] = mk
+		return mk, nil
+	}
+
+	var err error
+	switch {
+	case c.Key != "":
+		kr.current, err = add(decodeKey(c.Key))
+	case c.KeyFile != "":
+		kr.current, err = add(readKeyFile(c.KeyFile))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range c.PreviousKeys {
+		if _, err := add(decodeKey(k)); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range c.PreviousKeyFiles {
+		if _, err := add(readKeyFile(p)); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+func chunkNonce(i uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], i)
+	return nonce
+}
+
+func chunkAD(i uint64, final bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, i)
+	if final {
+		ad[8] = 1
+	}
+	return ad
+}
+
+func wrapDataKey(mk *masterKey, dek []byte) ([]byte, error) {
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return mk.aead.Seal(nonce, nonce, dek, []byte(encMagic)), nil
+}
+
+func encodeHeader(mk *masterKey, wrapped []byte) []byte {
+	header := bytes.NewBuffer(make([]byte, 0, encHeaderLen))
+	header.WriteString(encMagic)
+	header.WriteString(mk.ID)
+	binary.Write(header, binary.BigEndian, uint32(encChunkSize))
+	header.Write(wrapped)
+	return header.Bytes()
+}
+
+type encHeader struct {
+	KeyID     string
+	ChunkSize int64
+	Wrapped   []byte
+}
+
+func readHeader(r io.ReaderAt) (*encHeader, error) {
+	buf := make([]byte, encHeaderLen)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	if string(buf[:len(encMagic)]) != encMagic {
+		return nil, nil
+	}
+	buf = buf[len(encMagic):]
+	return &encHeader{
+		KeyID:     string(buf[:encKeyIDLen]),
+		ChunkSize: int64(binary.BigEndian.Uint32(buf[encKeyIDLen:])),
+		Wrapped:   buf[encKeyIDLen+4:],
+	}, nil
+}
+
+func (kr *keyring) unwrap(h *encHeader) ([]byte, error) {
+	mk := kr.keys[h.KeyID]
+	if mk == nil {
+		return nil, fmt.Errorf("encryption key %s is not configured", h.KeyID)
+	}
+	dek, err := mk.aead.Open(nil, h.Wrapped[:12], h.Wrapped[12:], []byte(encMagic))
+	if err != nil {
+		return nil, ErrCorruptEncryptedFile
+	}
+	return dek, nil
+}
+
+// encryptWriter seals written data chunk by chunk, Close must be called to write the final chunk
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	chunk uint64
+}
+
+func newEncryptWriter(w io.Writer, mk *masterKey) (*encryptWriter, error) {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := wrapDataKey(mk, dek)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(encodeHeader(mk, wrapped)); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, encChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) flush(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.chunk), e.buf, chunkAD(e.chunk, final))
+	e.chunk++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// a full chunk is only written once more data arrives, the last chunk is sealed as final
+		if len(e.buf) == encChunkSize {
+			if err = e.flush(false); err != nil {
+				return
+			}
+		}
+		c := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return
+}
+
+func (e *encryptWriter) Close() error {
+	return e.flush(true)
+}
+
+// dataReader is a release file opened for reading, decrypted if necessary
+type dataReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Size() int64
+}
+
+type plainReader struct {
+	*os.File
+	size int64
+}
+
+func (r *plainReader) Size() int64 { return r.size }
+
+// decryptReader provides random access to an encrypted file by decrypting whole chunks
+type decryptReader struct {
+	f         *os.File
+	aead      cipher.AEAD
+	chunkSize int64
+	fileSize  int64
+	chunks    int64
+	size      int64
+	offset    int64
+
+	cached      int64
+	cachedPlain []byte
+}
+
+func newDecryptReader(f *os.File, kr *keyring, h *encHeader, fileSize int64) (*decryptReader, error) {
+	dek, err := kr.unwrap(h)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	body := fileSize - int64(encHeaderLen)
+	sealedChunk := h.ChunkSize + encOverhead
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	if h.ChunkSize <= 0 || chunks == 0 || body-(chunks-1)*sealedChunk < encOverhead {
+		return nil, ErrCorruptEncryptedFile
+	}
+	return &decryptReader{
+		f:         f,
+		aead:      aead,
+		chunkSize: h.ChunkSize,
+		fileSize:  fileSize,
+		chunks:    chunks,
+		size:      body - chunks*encOverhead,
+		cached:    -1,
+	}, nil
+}
+
+func (r *decryptReader) Size() int64 { return r.size }
+
+func (r *decryptReader) chunk(i int64) ([]byte, error) {
+	if i == r.cached {
+		return r.cachedPlain, nil
+	}
+	off := int64(encHeaderLen) + i*(r.chunkSize+encOverhead)
+	n := r.chunkSize + encOverhead
+	if off+n > r.fileSize {
+		n = r.fileSize - off
+	}
+	sealed := make([]byte, n)
+	if _, err := r.f.ReadAt(sealed, off); err != nil {
+		return nil, err
+	}
+	plain, err := r.aead.Open(sealed[:0], chunkNonce(uint64(i)), sealed, chunkAD(uint64(i), i == r.chunks-1))
+	if err != nil {
+		return nil, ErrCorruptEncryptedFile
+	}
+	r.cached, r.cachedPlain = i, plain
+	return plain, nil
+}
+
+func (r *decryptReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("decrypt: negative offset")
+	}
+	for len(p) > 0 {
+		if off >= r.size {
+			return n, io.EOF
+		}
+		plain, err := r.chunk(off / r.chunkSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p, plain[off%r.chunkSize:])
+		p = p[c:]
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+func (r *decryptReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+func (r *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("decrypt: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("decrypt: negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.f.Close()
+}
+
+// openDataFile opens a stored file, transparently decrypting it
+func openDataFile(p string) (dataReader, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var h *encHeader
+	if info.Size() >= int64(encHeaderLen) {
+		h, err = readHeader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if h == nil {
+		return &plainReader{File: f, size: info.Size()}, nil
+	}
+
+	r, err := newDecryptReader(f, encryptionKeys, h, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// stagingDir returns where uploads are written before they are validated,
+// plaintext is kept off the data disk when encryption is enabled
+func stagingDir() string {
+	if encryptionKeys.current != nil {
+		return os.TempDir()
+	}
+	return dataDir
+}
+
+// storeFile moves a staged upload to its final path, encrypting it if enabled
+func storeFile(staged *os.File, p string) (err error) {
+	if encryptionKeys.current == nil {
+		staged.Close()
+		return os.Rename(staged.Name(), p)
+	}
+
+	if _, err = staged.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(p)
+		}
+	}()
+
+	w, err := newEncryptWriter(f, encryptionKeys.current)
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(w, staged); err != nil {
+		return
+	}
+	return w.Close()
+}
+
+// ReencryptResult counts files touched by Reencrypt
+type ReencryptResult struct {
+	Rewrapped int
+	Encrypted int
+	Decrypted int
+}
+
+// Reencrypt brings all stored files in line with the current encryption key.
+// Files under an old key get their data key rewrapped, plaintext files are
+// encrypted, and if encryption was disabled encrypted files are decrypted.
+func Reencrypt() (rv ReencryptResult, err error) {
+	for _, dir := range []string{dataDir, quarantineDir()} {
+		var paths []string
+		paths, err = filepath.Glob(filepath.Join(dir, "*.dat"))
+		if err != nil {
+			return
+		}
+		for _, p := range paths {
+			if err = reencryptFile(p, &rv); err != nil {
+				err = fmt.Errorf("reencrypt %s: %s", p, err.Error())
+				return
+			}
+		}
+	}
+	return
+}
+
+func reencryptFile(p string, rv *ReencryptResult) error {
+	f, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var h *encHeader
+	if info, err := f.Stat(); err != nil {
+		return err
+	} else if info.Size() >= int64(encHeaderLen) {
+		if h, err = readHeader(f); err != nil {
+			return err
+		}
+	}
+
+	current := encryptionKeys.current
+	switch {
+	case h == nil && current == nil:
+		return nil
+	case h != nil && current != nil && h.KeyID == current.ID:
+		return nil
+	case h != nil && current != nil:
+		dek, err := encryptionKeys.unwrap(h)
+		if err != nil {
+			return err
+		}
+		wrapped, err := wrapDataKey(current, dek)
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(encodeHeader(current, wrapped), 0); err != nil {
+			return err
+		}
+		rv.Rewrapped++
+		return f.Sync()
+	}
+
+	r, err := openDataFile(p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".reencrypt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	var w io.Writer = tmp
+	var ew *encryptWriter
+	if current != nil {
+		if ew, err = newEncryptWriter(tmp, current); err != nil {
+			tmp.Close()
+			return err
+		}
+		w = ew
+	}
+	if _, err = io.Copy(w, r); err == nil && ew != nil {
+		err = ew.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+	if current != nil {
+		rv.Encrypted++
+	} else {
+		rv.Decrypted++
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package dist
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEncryptionKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func testKeyring(t *testing.T, c EncryptionConfig) *keyring {
+	kr, err := loadKeyring(c)
+	assert.NoError(t, err)
+	return kr
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	defer func(kr *keyring) { encryptionKeys = kr }(encryptionKeys)
+	encryptionKeys = testKeyring(t, EncryptionConfig{Key: testEncryptionKey(t)})
+
+	data := make([]byte, encChunkSize*3+123)
+	_, err := rand.Read(data)
+	assert.NoError(t, err)
+
+	for _, size := range []int{0, 1, encChunkSize, encChunkSize + 1, len(data)} {
+		f, err := ioutil.TempFile("", "crypt")
+		assert.NoError(t, err)
+		defer os.Remove(f.Name())
+		w, err := newEncryptWriter(f, encryptionKeys.current)
+		assert.NoError(t, err)
+		_, err = w.Write(data[:size])
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		assert.NoError(t, f.Close())
+
+		raw, err := ioutil.ReadFile(f.Name())
+		assert.NoError(t, err)
+		assert.False(t, size > 16 && bytes.Contains(raw, data[:16]))
+
+		r, err := openDataFile(f.Name())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), r.Size())
+		plain, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data[:size], plain)
+
+		if size > encChunkSize+50 {
+			buf := make([]byte, 100)
+			off := int64(encChunkSize - 50)
+			_, err = r.ReadAt(buf, off)
+			assert.NoError(t, err)
+			assert.Equal(t, data[off:off+100], buf)
+
+			_, err = r.Seek(-10, io.SeekEnd)
+			assert.NoError(t, err)
+			tail, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, data[size-10:size], tail)
+		}
+		r.Close()
+	}
+}
+
+func TestEncryptTamper(t *testing.T) {
+	defer func(kr *keyring) { encryptionKeys = kr }(encryptionKeys)
+	encryptionKeys = testKeyring(t, EncryptionConfig{Key: testEncryptionKey(t)})
+
+	buf := bytes.NewBuffer(nil)
+	w, err := newEncryptWriter(buf, encryptionKeys.current)
+	assert.NoError(t, err)
+	_, err = w.Write(make([]byte, encChunkSize*2))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	for _, tampered := range [][]byte{
+		// truncated at a chunk boundary
+		buf.Bytes()[:encHeaderLen+encChunkSize+encOverhead],
+		// flipped bit in the first chunk
+		func() []byte {
+			b := append([]byte{}, buf.Bytes()...)
+			b[encHeaderLen+10] ^= 1
+			return b
+		}(),
+	} {
+		f, err := ioutil.TempFile("", "crypt")
+		assert.NoError(t, err)
+		defer os.Remove(f.Name())
+		_, err = f.Write(tampered)
+		assert.NoError(t, err)
+		f.Close()
+
+		r, err := openDataFile(f.Name())
+		assert.NoError(t, err)
+		_, err = ioutil.ReadAll(r)
+		assert.Equal(t, ErrCorruptEncryptedFile, err)
+		r.Close()
+	}
+}
+
+func TestPublishEncrypted(t *testing.T) {
+	defer func(kr *keyring) { encryptionKeys = kr }(encryptionKeys)
+	encryptionKeys = &keyring{keys: map[string]*masterKey{}}
+	plainRelease, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("PLAIN"))
+	assert.NoError(t, err)
+	defer Unpublish(plainRelease.ID)
+
+	oldKey := testEncryptionKey(t)
+	encryptionKeys = testKeyring(t, EncryptionConfig{Key: oldKey})
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("RELEASE"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+
+	raw, err := ioutil.ReadFile(dataFilePath(r.ID + ".dat"))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(raw, []byte(encMagic)))
+
+	out := bytes.NewBuffer(nil)
+	assert.NoError(t, Stream(r.ID, out))
+	assert.Equal(t, "RELEASE", out.String())
+
+	// rotate: new key is current, old key can still decrypt until files are rewrapped
+	encryptionKeys = testKeyring(t, EncryptionConfig{Key: testEncryptionKey(t), PreviousKeys: []string{oldKey}})
+	out.Reset()
+	assert.NoError(t, Stream(r.ID, out))
+	assert.Equal(t, "RELEASE", out.String())
+
+	rv, err := Reencrypt()
+	assert.NoError(t, err)
+	assert.True(t, rv.Rewrapped >= 1)
+	assert.True(t, rv.Encrypted >= 1)
+
+	encryptionKeys.keys = map[string]*masterKey{encryptionKeys.current.ID: encryptionKeys.current}
+	for _, id := range []string{r.ID, plainRelease.ID} {
+		out.Reset()
+		assert.NoError(t, Stream(id, out))
+		raw, err := ioutil.ReadFile(dataFilePath(id + ".dat"))
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(raw, []byte(encMagic)))
+	}
+	assert.Equal(t, "PLAIN", out.String())
+
+	// disable encryption: files are decrypted back to plaintext
+	encryptionKeys = &keyring{keys: encryptionKeys.keys}
+	rv, err = Reencrypt()
+	assert.NoError(t, err)
+	assert.True(t, rv.Decrypted >= 2)
+	raw, err = ioutil.ReadFile(dataFilePath(r.ID + ".dat"))
+	assert.NoError(t, err)
+	assert.Equal(t, "RELEASE", string(raw))
+}
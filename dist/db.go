@@ -4,13 +4,17 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
 
-var dataFile = "./data/sc2a.db"
+var dataFile = DefaultDataFile
 var db *bolt.DB
 
+// dbOpenTimeout bounds the wait for the lock of the database file, held by a running server
+const dbOpenTimeout = 5 * time.Second
+
 func mustWriteDefaultConfig(b *bolt.Bucket, name, defaultValue string) {
 	v := b.Get([]byte(name))
 	if v == nil {
@@ -24,17 +28,23 @@ func mustWriteDefaultConfig(b *bolt.Bucket, name, defaultValue string) {
 //OpenDB opens database
 func OpenDB() {
 	var err error
-	if err = os.MkdirAll(filepath.Dir(dataFile), 0600); err != nil {
+	if err = os.MkdirAll(filepath.Dir(dataFile), 0700); err != nil {
+		log.Fatal(err)
+	}
+	if err = os.MkdirAll(quarantineDir(), 0700); err != nil {
 		log.Fatal(err)
 	}
 
-	db, err = bolt.Open(dataFile, 0600, nil)
+	db, err = bolt.Open(dataFile, 0600, &bolt.Options{Timeout: dbOpenTimeout})
+	if err == bolt.ErrTimeout {
+		log.Fatalf("%s: database in use, stop the server first", dataFile)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	buckets := []string{"release", "sub", "link", "sub_download", "config"}
-	db.Update(func(tx *bolt.Tx) error {
+	buckets := []string{"release", "sub", "link", "sub_download", "config", "quarantine", "signing_key", "sub_confirm", "secret", "unsubscribe", "sub_email", "segment", "sub_date", "release_date", "email_change", "consent", "erasure", "admin", "api_token", "audit", "template_version"}
+	err = db.Update(func(tx *bolt.Tx) error {
 		for _, b := range buckets {
 			_, err := tx.CreateBucketIfNotExists([]byte(b))
 			if err != nil {
@@ -42,8 +52,14 @@ func OpenDB() {
 			}
 		}
 
-		return nil
+		return buildIndexes(tx)
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = loadStoredConfig(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 //CloseDB closes database
@@ -3,11 +3,36 @@ package dist
 import "testing"
 import "os"
 import "log"
+import "sync"
+import "gopkg.in/mailgun/mailgun-go.v1"
+
+// testMailgun records sent messages instead of calling the mailgun api
+type testMailgun struct {
+	mailgun.Mailgun
+	mu   sync.Mutex
+	sent []*mailgun.Message
+}
+
+func (m *testMailgun) Send(msg *mailgun.Message) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return "", "", nil
+}
+
+func (m *testMailgun) Sent() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sent)
+}
+
+var testMg = &testMailgun{}
 
 func TestMain(m *testing.M) {
 	if err := os.RemoveAll(dataFile); err != nil {
 		log.Fatal(err)
 	}
+	mg = testMg
 	OpenDB()
 	rv := m.Run()
 	CloseDB()
@@ -0,0 +1,128 @@
+package dist
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/mail"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrInvalidEmail is returned when an email address can not be parsed
+var ErrInvalidEmail = errors.New("email address is invalid")
+
+// ErrEmailExists is returned when another subscriber already uses an email address
+var ErrEmailExists = errors.New("email address is already subscribed")
+
+// NormalizeEmail checks that s is a bare RFC 5322 address and returns it trimmed and lower cased
+func NormalizeEmail(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Name != "" || addr.Address != s {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// subIDByEmail looks up a normalized email address in the sub_email index
+func subIDByEmail(tx *bolt.Tx, email string) string {
+	return string(tx.Bucket([]byte("sub_email")).Get([]byte(email)))
+}
+
+// indexSubEmail claims email for the subscriber id
+func indexSubEmail(tx *bolt.Tx, email, id string) error {
+	if other := subIDByEmail(tx, email); other != "" && other != id {
+		return ErrEmailExists
+	}
+	return tx.Bucket([]byte("sub_email")).Put([]byte(email), []byte(id))
+}
+
+// unindexSubEmail releases email if it belongs to the subscriber id
+func unindexSubEmail(tx *bolt.Tx, email, id string) error {
+	if subIDByEmail(tx, email) != id {
+		return nil
+	}
+	return tx.Bucket([]byte("sub_email")).Delete([]byte(email))
+}
+
+// deleteSub removes a subscriber and its index entry, returning nil if it does not exist
+func deleteSub(tx *bolt.Tx, id string) (*Sub, error) {
+	b := tx.Bucket([]byte("sub"))
+	v := b.Get([]byte(id))
+	if v == nil {
+		return nil, nil
+	}
+	sub := Sub{}
+	if err := json.Unmarshal(v, &sub); err != nil {
+		return nil, err
+	}
+	if err := unindexSubEmail(tx, sub.Email, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Bucket([]byte("sub_date")).Delete(dateIndexKey(sub.Date, id)); err != nil {
+		return nil, err
+	}
+	return &sub, b.Delete([]byte(id))
+}
+
+// FindSubByEmail returns the subscriber with an email address
+func FindSubByEmail(email string) (rv *Sub, err error) {
+	email, err = NormalizeEmail(email)
+	if err != nil {
+		return
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte("sub")).Get([]byte(subIDByEmail(tx, email)))
+		if v == nil {
+			return ErrSubNotFound
+		}
+		rv = &Sub{}
+		return json.Unmarshal(v, rv)
+	})
+	return
+}
+
+// buildEmailIndex indexes subscribers saved before the sub_email bucket existed.
+// Addresses are normalized where possible; duplicates keep the first subscriber seen.
+func buildEmailIndex(tx *bolt.Tx) error {
+	if k, _ := tx.Bucket([]byte("sub_email")).Cursor().First(); k != nil {
+		return nil
+	}
+	b := tx.Bucket([]byte("sub"))
+	updated := map[string][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		sub := Sub{}
+		if err := json.Unmarshal(v, &sub); err != nil {
+			return err
+		}
+		email, err := NormalizeEmail(sub.Email)
+		if err != nil {
+			log.Printf("subscriber %s has an invalid email address: %q", sub.ID, sub.Email)
+			email = strings.ToLower(strings.TrimSpace(sub.Email))
+		}
+		if err := indexSubEmail(tx, email, sub.ID); err != nil {
+			log.Printf("subscriber %s duplicates email address %s", sub.ID, email)
+			return nil
+		}
+		if email != sub.Email {
+			sub.Email = email
+			j, err := json.Marshal(sub)
+			if err != nil {
+				return err
+			}
+			updated[string(k)] = j
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, j := range updated {
+		if err := b.Put([]byte(k), j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
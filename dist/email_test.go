@@ -0,0 +1,98 @@
+package dist
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	for in, expected := range map[string]string{
+		"user@example.com":           "user@example.com",
+		"  User.Name@Example.COM \n": "user.name@example.com",
+		"user+tag@sub.example.org":   "user+tag@sub.example.org",
+	} {
+		email, err := NormalizeEmail(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, expected, email)
+	}
+	for _, in := range []string{"", "Email", "user@", "@example.com", "a b@example.com", "Name <user@example.com>", "user@example.com, other@example.com"} {
+		_, err := NormalizeEmail(in)
+		assert.Equal(t, ErrInvalidEmail, err, in)
+	}
+}
+
+func TestSubscribeEmailUnique(t *testing.T) {
+	_, err := Subscribe(Sub{Name: "Name", Email: "Email"})
+	assert.Equal(t, ErrInvalidEmail, err)
+
+	sub, err := Subscribe(Sub{Name: "Name", Email: " Unique@Example.com "})
+	assert.NoError(t, err)
+	assert.Equal(t, "unique@example.com", sub.Email)
+
+	_, err = Subscribe(Sub{Name: "Other", Email: "UNIQUE@example.com"})
+	assert.Equal(t, ErrEmailExists, err)
+
+	found, err := FindSubByEmail("unique@EXAMPLE.com")
+	assert.NoError(t, err)
+	assert.Equal(t, sub.ID, found.ID)
+
+	other, err := Subscribe(Sub{Name: "Other", Email: "other@example.com"})
+	assert.NoError(t, err)
+	other.Email = "unique@example.com"
+	_, err = UpdateSubscriber(*other)
+	assert.Equal(t, ErrEmailExists, err)
+
+	// the old address is released when it changes
+	sub.Email = "renamed@example.com"
+	_, err = UpdateSubscriber(*sub)
+	assert.NoError(t, err)
+	_, err = FindSubByEmail("unique@example.com")
+	assert.Equal(t, ErrSubNotFound, err)
+	other.Email = "unique@example.com"
+	_, err = UpdateSubscriber(*other)
+	assert.NoError(t, err)
+
+	assert.NoError(t, Unsubscribe(other.ID))
+	_, err = FindSubByEmail("unique@example.com")
+	assert.Equal(t, ErrSubNotFound, err)
+	assert.NoError(t, Unsubscribe(sub.ID))
+}
+
+func TestBuildEmailIndex(t *testing.T) {
+	legacy := []Sub{
+		{ID: "LEGACY_1", Email: " Legacy@Example.com"},
+		{ID: "LEGACY_2", Email: "legacy@example.com"},
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, s := range legacy {
+			j, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte("sub")).Put([]byte(s.ID), j); err != nil {
+				return err
+			}
+		}
+		if err := tx.DeleteBucket([]byte("sub_email")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket([]byte("sub_email")); err != nil {
+			return err
+		}
+		return buildEmailIndex(tx)
+	})
+	assert.NoError(t, err)
+
+	sub, err := FindSubByEmail("legacy@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "LEGACY_1", sub.ID)
+	assert.Equal(t, "legacy@example.com", sub.Email)
+
+	assert.NoError(t, Unsubscribe("LEGACY_2"))
+	assert.NoError(t, Unsubscribe("LEGACY_1"))
+	_, err = FindSubByEmail("legacy@example.com")
+	assert.Equal(t, ErrSubNotFound, err)
+}
@@ -0,0 +1,240 @@
+package dist
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/fluxxu/util"
+	"github.com/satori/go.uuid"
+)
+
+// Notification is a release notification sent to a subscriber, one per download link
+type Notification struct {
+	ReleaseID string
+	// Version is empty if the release was unpublished
+	Version string
+	LinkID  string
+	Date    util.JSONTime
+}
+
+// SubData is everything stored about a subscriber
+type SubData struct {
+	// Sub is nil once the subscriber has unsubscribed
+	Sub           *Sub
+	Links         []Link
+	Downloads     map[string]uint64
+	Notifications []Notification
+	Consent       []ConsentEvent
+}
+
+// Tombstone records that the data of a subscriber was erased, without identifying it
+type Tombstone struct {
+	ID            string
+	Date          time.Time
+	Links         int
+	Downloads     uint64
+	ConsentEvents int
+}
+
+// GetSubData returns all data held on a subscriber, including one that unsubscribed
+func GetSubData(id string) (rv *SubData, err error) {
+	rv = &SubData{
+		Links:         []Link{},
+		Downloads:     map[string]uint64{},
+		Notifications: []Notification{},
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("sub")).Get([]byte(id)); v != nil {
+			rv.Sub = &Sub{}
+			if err := json.Unmarshal(v, rv.Sub); err != nil {
+				return err
+			}
+		}
+
+		releases := tx.Bucket([]byte("release"))
+		err := tx.Bucket([]byte("link")).ForEach(func(k, v []byte) error {
+			link := Link{}
+			if err := json.Unmarshal(v, &link); err != nil {
+				return fmt.Errorf("link unmarshal: %s", err.Error())
+			}
+			if link.SubID != id {
+				return nil
+			}
+			rv.Links = append(rv.Links, link)
+			n := Notification{ReleaseID: link.ReleaseID, LinkID: link.ID, Date: link.Date}
+			if rj := releases.Get([]byte(link.ReleaseID)); rj != nil {
+				r := Release{}
+				if err := json.Unmarshal(rj, &r); err != nil {
+					return err
+				}
+				n.Version = r.Version
+			}
+			rv.Notifications = append(rv.Notifications, n)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if b := tx.Bucket([]byte("sub_download")).Bucket([]byte(id)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				n, _ := strconv.ParseUint(string(v), 16, 64)
+				rv.Downloads[string(k)] = n
+				return nil
+			})
+		}
+
+		rv.Consent, err = listConsent(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rv.Sub == nil && len(rv.Links) == 0 && len(rv.Downloads) == 0 && len(rv.Consent) == 0 {
+		return nil, ErrSubNotFound
+	}
+	return
+}
+
+// EraseSub deletes a subscriber with its links, download counts, consent events and pending tokens.
+// Unsubscribe events are kept for reporting without the subscriber id, audit entries without
+// snapshots of the subscriber. Only a Tombstone is left.
+func EraseSub(id string) (rv *Tombstone, err error) {
+	rv = &Tombstone{
+		ID:   uuid.NewV4().String(),
+		Date: time.Now(),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		sub, err := deleteSub(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if rv.Links, err = removeLinksIf(tx, func(link *Link) bool { return link.SubID == id }); err != nil {
+			return err
+		}
+
+		found := sub != nil || rv.Links > 0
+		downloads := tx.Bucket([]byte("sub_download"))
+		if b := downloads.Bucket([]byte(id)); b != nil {
+			found = true
+			b.ForEach(func(k, v []byte) error {
+				n, _ := strconv.ParseUint(string(v), 16, 64)
+				rv.Downloads += n
+				return nil
+			})
+			if err := downloads.DeleteBucket([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		consent, err := listConsent(tx, id)
+		if err != nil {
+			return err
+		}
+		rv.ConsentEvents = len(consent)
+		if err := deleteConsent(tx, id); err != nil {
+			return err
+		}
+
+		if !found && rv.ConsentEvents == 0 {
+			return ErrSubNotFound
+		}
+
+		for _, bucket := range []string{"sub_confirm", "email_change"} {
+			if err := deleteTokensOf(tx, bucket, id); err != nil {
+				return err
+			}
+		}
+		if err := anonymizeUnsubscribeEvents(tx, id); err != nil {
+			return err
+		}
+		if err := scrubAuditSnapshots(tx, id); err != nil {
+			return err
+		}
+
+		j, err := json.Marshal(rv)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("erasure")).Put([]byte(rv.ID), j)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// deleteTokensOf deletes sub_confirm or email_change records of a subscriber
+func deleteTokensOf(tx *bolt.Tx, bucket, subID string) error {
+	b := tx.Bucket([]byte(bucket))
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		r := struct{ SubID string }{}
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		if r.SubID == subID {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func anonymizeUnsubscribeEvents(tx *bolt.Tx, subID string) error {
+	b := tx.Bucket([]byte("unsubscribe"))
+	updated := map[string][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		e := UnsubscribeEvent{}
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		if e.SubID != subID {
+			return nil
+		}
+		e.SubID = ""
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		updated[string(k)] = j
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, j := range updated {
+		if err := b.Put([]byte(k), j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListTombstones returns the erasure records
+func ListTombstones() ([]Tombstone, error) {
+	rv := []Tombstone{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("erasure")).ForEach(func(k, v []byte) error {
+			t := Tombstone{}
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			rv = append(rv, t)
+			return nil
+		})
+	})
+	return rv, err
+}
@@ -0,0 +1,100 @@
+package dist
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubDataAndErase(t *testing.T) {
+	tokens, restore := captureConfirmLinks()
+	defer restore()
+
+	assert.NoError(t, RequestSubscription(Sub{Name: "Erased", Email: "erase@example.com"}))
+	sub, err := ConfirmSubscription((*tokens)[0])
+	assert.NoError(t, err)
+
+	r, err := Publish(Release{Version: "1.2.3"}, bytes.NewBufferString("erase"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+	links, err := createLinks([]string{sub.ID}, r.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, StreamLink(links[0].ID, &bytes.Buffer{}))
+	assert.NoError(t, StreamLink(links[0].ID, &bytes.Buffer{}))
+
+	data, err := GetSubData(sub.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "erase@example.com", data.Sub.Email)
+	assert.Equal(t, 1, len(data.Links))
+	assert.Equal(t, uint64(2), data.Downloads[r.ID])
+	assert.Equal(t, "1.2.3", data.Notifications[0].Version)
+	assert.Equal(t, links[0].ID, data.Notifications[0].LinkID)
+	assert.Equal(t, ConsentRequested, data.Consent[0].Event)
+	assert.Equal(t, ConsentConfirmed, data.Consent[1].Event)
+
+	// data outlives unsubscribing until it is erased
+	token, err := signLinkToken("unsubscribe", sub.ID)
+	assert.NoError(t, err)
+	_, err = UnsubscribeByToken(token, UnsubscribePage, "Other")
+	assert.NoError(t, err)
+	data, err = GetSubData(sub.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, data.Sub)
+	assert.Equal(t, ConsentUnsubscribed, data.Consent[2].Event)
+	assert.Equal(t, UnsubscribePage, data.Consent[2].Detail)
+
+	// snapshots recorded before the subscriber was erased
+	before := []byte(`{"ID":"` + sub.ID + `","Name":"Erased","Email":"erase@example.com"}`)
+	assert.NoError(t, RecordAudit(AuditEntry{Actor: "erase-admin", Action: "PUT /api/sub/:id", TargetID: sub.ID, Status: 200, Before: before, After: before}))
+
+	tombstone, err := EraseSub(sub.ID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, sub.ID, tombstone.ID)
+	assert.Equal(t, 1, tombstone.Links)
+	assert.Equal(t, uint64(2), tombstone.Downloads)
+	assert.Equal(t, 3, tombstone.ConsentEvents)
+
+	_, err = GetSubData(sub.ID)
+	assert.Equal(t, ErrSubNotFound, err)
+	_, err = GetLink(links[0].ID)
+	assert.Equal(t, ErrLinkNotFound, err)
+	_, err = EraseSub(sub.ID)
+	assert.Equal(t, ErrSubNotFound, err)
+
+	// the audit entry stays, without the erased email
+	buf := &bytes.Buffer{}
+	assert.NoError(t, ExportAudit(buf, AuditFilter{TargetID: sub.ID}))
+	assert.Contains(t, buf.String(), "erase-admin")
+	assert.NotContains(t, buf.String(), "erase@example.com")
+
+	report, err := GetUnsubscribeReport()
+	assert.NoError(t, err)
+	for _, e := range report.Events {
+		assert.NotEqual(t, sub.ID, e.SubID)
+	}
+
+	list, err := ListTombstones()
+	assert.NoError(t, err)
+	found := false
+	for _, ts := range list {
+		found = found || ts.ID == tombstone.ID
+	}
+	assert.True(t, found)
+}
+
+func TestEraseActiveSub(t *testing.T) {
+	sub, err := Subscribe(Sub{Name: "Active", Email: "erase.active@example.com"})
+	assert.NoError(t, err)
+
+	tombstone, err := EraseSub(sub.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, tombstone.ConsentEvents)
+	_, err = FindSubByEmail("erase.active@example.com")
+	assert.Equal(t, ErrSubNotFound, err)
+
+	// the address can subscribe again
+	sub, err = Subscribe(Sub{Name: "Active", Email: "erase.active@example.com"})
+	assert.NoError(t, err)
+	assert.NoError(t, Unsubscribe(sub.ID))
+}
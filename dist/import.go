@@ -0,0 +1,332 @@
+package dist
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+)
+
+// Subscriber import and export formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// How ImportSubs treats rows whose email address is already subscribed
+const (
+	ImportSkip   = "skip"
+	ImportUpdate = "update"
+)
+
+// Results of an imported row
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportInvalid = "invalid"
+)
+
+// ImportOptions controls ImportSubs
+type ImportOptions struct {
+	// DryRun validates every row without saving anything
+	DryRun bool
+	// Duplicates is ImportSkip (default) or ImportUpdate
+	Duplicates string
+}
+
+// ImportRow is the result of one imported row, rows are numbered from 1 not counting a csv header
+type ImportRow struct {
+	Row    int
+	Email  string
+	ID     string `json:",omitempty"`
+	Result string
+	Error  string `json:",omitempty"`
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	DryRun  bool
+	Created int
+	Updated int
+	Skipped int
+	Invalid int
+	Rows    []ImportRow
+}
+
+var errImportDryRun = errors.New("dry run")
+
+func unknownFormatError(format string) error {
+	return validationError(http.StatusBadRequest, "format", "unknown format %q, must be %s or %s", format, FormatCSV, FormatJSON)
+}
+
+type importRecord struct {
+	Row   int
+	Sub   Sub
+	Error string
+}
+
+// ImportSubs adds subscribers from csv with name, email, tags and locale columns, or from json lines of subscribers.
+// All rows are saved in one transaction; rows that fail validation are reported and left out.
+func ImportSubs(r io.Reader, format string, opts ImportOptions) (rv *ImportResult, err error) {
+	switch opts.Duplicates {
+	case "":
+		opts.Duplicates = ImportSkip
+	case ImportSkip, ImportUpdate:
+	default:
+		return nil, validationError(http.StatusBadRequest, "duplicates", "duplicates must be %s or %s", ImportSkip, ImportUpdate)
+	}
+
+	var records []importRecord
+	switch format {
+	case FormatCSV:
+		records, err = readImportCSV(r)
+	case FormatJSON:
+		records, err = readImportJSON(r)
+	default:
+		err = unknownFormatError(format)
+	}
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	rv = &ImportResult{DryRun: opts.DryRun, Rows: make([]ImportRow, 0, len(records))}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sub"))
+		for _, rec := range records {
+			row := ImportRow{Row: rec.Row, Email: rec.Sub.Email, Error: rec.Error}
+			if row.Error == "" {
+				if err := importSub(tx, b, rec.Sub, opts, now, &row); err != nil {
+					return err
+				}
+			}
+			if row.Error != "" {
+				row.Result = ImportInvalid
+			}
+			switch row.Result {
+			case ImportCreated:
+				rv.Created++
+			case ImportUpdated:
+				rv.Updated++
+			case ImportSkipped:
+				rv.Skipped++
+			case ImportInvalid:
+				rv.Invalid++
+			}
+			rv.Rows = append(rv.Rows, row)
+		}
+		if opts.DryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if err == errImportDryRun {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func importSub(tx *bolt.Tx, b *bolt.Bucket, sub Sub, opts ImportOptions, now time.Time, row *ImportRow) error {
+	email, err := NormalizeEmail(sub.Email)
+	if err != nil {
+		row.Error = err.Error()
+		return nil
+	}
+	row.Email = email
+	tags, err := normalizeTags(sub.Tags)
+	if err != nil {
+		row.Error = err.Error()
+		return nil
+	}
+	locale, err := normalizeLocale(sub.Locale)
+	if err != nil {
+		row.Error = err.Error()
+		return nil
+	}
+
+	if id := subIDByEmail(tx, email); id != "" {
+		row.ID = id
+		if opts.Duplicates == ImportSkip {
+			row.Result = ImportSkipped
+			return nil
+		}
+		existing := Sub{}
+		if err := json.Unmarshal(b.Get([]byte(id)), &existing); err != nil {
+			return fmt.Errorf("unmarshal subscriber %s: %s", id, err.Error())
+		}
+		existing.Name = sub.Name
+		if tags != nil {
+			existing.Tags = tags
+		}
+		if locale != "" {
+			existing.Locale = locale
+		}
+		j, err := json.Marshal(existing)
+		if err != nil {
+			return err
+		}
+		row.Result = ImportUpdated
+		return b.Put([]byte(id), j)
+	}
+
+	sub = Sub{
+		ID:     uuid.NewV4().String(),
+		Name:   sub.Name,
+		Email:  email,
+		Date:   now,
+		Status: SubActive,
+		Tags:   tags,
+		Locale: locale,
+	}
+	row.ID = sub.ID
+	row.Result = ImportCreated
+	return putNewSub(tx, sub, ConsentImported)
+}
+
+func readImportCSV(r io.Reader) (rv []importRecord, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, validationError(http.StatusBadRequest, "empty", "import is empty")
+	}
+	if err != nil {
+		return nil, validationError(http.StatusBadRequest, "csv_header", "csv header: %s", err.Error())
+	}
+	nameCol, emailCol, tagsCol, localeCol := -1, -1, -1, -1
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))) {
+		case "name":
+			nameCol = i
+		case "email":
+			emailCol = i
+		case "tags":
+			tagsCol = i
+		case "locale":
+			localeCol = i
+		}
+	}
+	if emailCol < 0 {
+		return nil, validationError(http.StatusBadRequest, "csv_header", "csv header has no email column")
+	}
+
+	for n := 1; ; n++ {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return rv, nil
+		}
+		rec := importRecord{Row: n}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return nil, err
+			}
+			rec.Error = err.Error()
+		} else if emailCol >= len(fields) {
+			rec.Error = "row has no email field"
+		} else {
+			rec.Sub.Email = fields[emailCol]
+			if nameCol >= 0 && nameCol < len(fields) {
+				rec.Sub.Name = strings.TrimSpace(fields[nameCol])
+			}
+			if tagsCol >= 0 && tagsCol < len(fields) {
+				rec.Sub.Tags = splitTags(fields[tagsCol])
+			}
+			if localeCol >= 0 && localeCol < len(fields) {
+				rec.Sub.Locale = fields[localeCol]
+			}
+		}
+		rv = append(rv, rec)
+	}
+}
+
+func readImportJSON(r io.Reader) (rv []importRecord, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 0; s.Scan(); {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		n++
+		rec := importRecord{Row: n}
+		if err := json.Unmarshal([]byte(line), &rec.Sub); err != nil {
+			rec.Error = err.Error()
+		}
+		rec.Sub.Name = strings.TrimSpace(rec.Sub.Name)
+		rv = append(rv, rec)
+	}
+	return rv, s.Err()
+}
+
+// SubExport is an exported subscriber with download counts per release
+type SubExport struct {
+	Sub
+	Downloads map[string]uint64
+}
+
+// ExportSubs writes all subscribers as csv or json lines
+func ExportSubs(w io.Writer, format string) error {
+	if format != FormatCSV && format != FormatJSON {
+		return unknownFormatError(format)
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		var cw *csv.Writer
+		enc := json.NewEncoder(w)
+		if format == FormatCSV {
+			cw = csv.NewWriter(w)
+			if err := cw.Write([]string{"id", "name", "email", "date", "status", "tags", "locale", "downloads"}); err != nil {
+				return err
+			}
+		}
+
+		downloads := tx.Bucket([]byte("sub_download"))
+		err := tx.Bucket([]byte("sub")).ForEach(func(k, v []byte) error {
+			e := SubExport{Downloads: map[string]uint64{}}
+			if err := json.Unmarshal(v, &e.Sub); err != nil {
+				return fmt.Errorf("unmarshal subscriber %s: %s", string(k), err.Error())
+			}
+			var total uint64
+			if b := downloads.Bucket(k); b != nil {
+				b.ForEach(func(k, v []byte) error {
+					n, _ := strconv.ParseUint(string(v), 16, 64)
+					e.Downloads[string(k)] = n
+					total += n
+					return nil
+				})
+			}
+
+			if cw == nil {
+				return enc.Encode(e)
+			}
+			return cw.Write([]string{
+				e.ID,
+				e.Name,
+				e.Email,
+				e.Date.Format(time.RFC3339),
+				e.Status,
+				strings.Join(e.Tags, " "),
+				e.Locale,
+				strconv.FormatUint(total, 10),
+			})
+		})
+		if err != nil || cw == nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
@@ -0,0 +1,117 @@
+package dist
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportSubsCSV(t *testing.T) {
+	existing, err := Subscribe(Sub{Name: "Existing", Email: "import.existing@example.com"})
+	assert.NoError(t, err)
+	defer Unsubscribe(existing.ID)
+
+	data := "\ufeffEmail,Name\n" +
+		"import.one@example.com, One\n" +
+		"not an email,Bad\n" +
+		"IMPORT.EXISTING@example.com,Renamed\n" +
+		"import.one@example.com,Again\n"
+
+	result, err := ImportSubs(strings.NewReader(data), FormatCSV, ImportOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, 2, result.Skipped)
+	assert.Equal(t, 1, result.Invalid)
+	assert.Equal(t, ImportInvalid, result.Rows[1].Result)
+	assert.Equal(t, 2, result.Rows[1].Row)
+	assert.NotEmpty(t, result.Rows[1].Error)
+	_, err = FindSubByEmail("import.one@example.com")
+	assert.Equal(t, ErrSubNotFound, err)
+
+	result, err = ImportSubs(strings.NewReader(data), FormatCSV, ImportOptions{Duplicates: ImportUpdate})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, 2, result.Updated)
+	assert.Equal(t, 1, result.Invalid)
+	assert.Equal(t, existing.ID, result.Rows[2].ID)
+
+	one, err := FindSubByEmail("import.one@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "Again", one.Name)
+	assert.Equal(t, SubActive, one.Status)
+	assert.NoError(t, Unsubscribe(one.ID))
+	updated, err := FindSubByEmail("import.existing@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", updated.Name)
+
+	_, err = ImportSubs(strings.NewReader("name,address\nA,a@example.com\n"), FormatCSV, ImportOptions{})
+	assert.Equal(t, "csv_header", err.(*ValidationError).Code)
+	_, err = ImportSubs(strings.NewReader(data), "xml", ImportOptions{})
+	assert.Equal(t, "format", err.(*ValidationError).Code)
+	_, err = ImportSubs(strings.NewReader(data), FormatCSV, ImportOptions{Duplicates: "merge"})
+	assert.Equal(t, "duplicates", err.(*ValidationError).Code)
+}
+
+func TestImportSubsJSON(t *testing.T) {
+	data := `{"Name": "Json", "Email": "import.json@example.com"}
+
+{"Name": "Broken"
+{"Email": 1}
+`
+	result, err := ImportSubs(strings.NewReader(data), FormatJSON, ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, 2, result.Invalid)
+	assert.Equal(t, 3, len(result.Rows))
+	assert.Equal(t, 3, result.Rows[2].Row)
+	assert.NoError(t, Unsubscribe(result.Rows[0].ID))
+}
+
+func TestExportSubs(t *testing.T) {
+	sub, err := Subscribe(Sub{Name: "Exported, Sub", Email: "export@example.com"})
+	assert.NoError(t, err)
+	defer Unsubscribe(sub.ID)
+
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("export"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+	links, err := createLinks([]string{sub.ID}, r.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, StreamLink(links[0].ID, &bytes.Buffer{}))
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, ExportSubs(buf, FormatJSON))
+	var found *SubExport
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		e := SubExport{}
+		assert.NoError(t, dec.Decode(&e))
+		if e.ID == sub.ID {
+			found = &e
+		}
+	}
+	assert.NotNil(t, found)
+	assert.Equal(t, "export@example.com", found.Email)
+	assert.Equal(t, uint64(1), found.Downloads[r.ID])
+
+	buf.Reset()
+	assert.NoError(t, ExportSubs(buf, FormatCSV))
+	records, err := csv.NewReader(buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "name", "email", "date", "status", "tags", "locale", "downloads"}, records[0])
+	var row []string
+	for _, rec := range records[1:] {
+		if rec[0] == sub.ID {
+			row = rec
+		}
+	}
+	assert.Equal(t, "Exported, Sub", row[1])
+	assert.Equal(t, "1", row[7])
+
+	assert.Error(t, ExportSubs(buf, "xml"))
+}
@@ -0,0 +1,84 @@
+package dist
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// dateIndexKey orders records by date, then id
+func dateIndexKey(t time.Time, id string) []byte {
+	k := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return append(k, id...)
+}
+
+// putNewSub saves a new subscriber, adds it to the sub_email and sub_date indexes and records how it consented
+func putNewSub(tx *bolt.Tx, sub Sub, consent string) error {
+	j, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	if err := indexSubEmail(tx, sub.Email, sub.ID); err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte("sub_date")).Put(dateIndexKey(sub.Date, sub.ID), []byte(sub.ID)); err != nil {
+		return err
+	}
+	if err := recordConsent(tx, sub.ID, consent, ""); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("sub")).Put([]byte(sub.ID), j)
+}
+
+// putNewRelease saves a new release and adds it to the release_date index
+func putNewRelease(tx *bolt.Tx, r Release) error {
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	// index the date as it reads back, JSONTime may not keep full precision
+	saved := Release{}
+	if err := json.Unmarshal(j, &saved); err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte("release_date")).Put(dateIndexKey(saved.Date.Time(), r.ID), []byte(r.ID)); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("release")).Put([]byte(r.ID), j)
+}
+
+// buildIndexes fills index buckets that are empty, e.g. after an upgrade
+func buildIndexes(tx *bolt.Tx) error {
+	if err := buildEmailIndex(tx); err != nil {
+		return err
+	}
+	if err := buildDateIndex(tx, "sub", "sub_date", func(v []byte) (time.Time, error) {
+		s := Sub{}
+		err := json.Unmarshal(v, &s)
+		return s.Date, err
+	}); err != nil {
+		return err
+	}
+	return buildDateIndex(tx, "release", "release_date", func(v []byte) (time.Time, error) {
+		r := Release{}
+		err := json.Unmarshal(v, &r)
+		return r.Date.Time(), err
+	})
+}
+
+func buildDateIndex(tx *bolt.Tx, bucket, index string, date func(v []byte) (time.Time, error)) error {
+	ib := tx.Bucket([]byte(index))
+	if k, _ := ib.Cursor().First(); k != nil {
+		return nil
+	}
+	return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+		t, err := date(v)
+		if err != nil {
+			return err
+		}
+		return ib.Put(dateIndexKey(t, string(k)), k)
+	})
+}
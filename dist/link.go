@@ -51,21 +51,34 @@ func createLinks(subs []string, releaseID string) (rv []Link, err error) {
 
 func removeLinkIf(fn func(link *Link) bool) error {
 	return db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("link"))
-		b.ForEach(func(k, v []byte) error {
-			link := Link{}
-			if err := json.Unmarshal(v, &link); err != nil {
-				return fmt.Errorf("link unmarshal: %s", err.Error())
-			}
-			if fn(&link) {
-				if err := b.Delete(k); err != nil {
-					return err
-				}
-			}
-			return nil
-		})
+		_, err := removeLinksIf(tx, fn)
+		return err
+	})
+}
+
+// removeLinksIf deletes matching links, collecting them first as bolt does not allow deleting while iterating
+func removeLinksIf(tx *bolt.Tx, fn func(link *Link) bool) (n int, err error) {
+	b := tx.Bucket([]byte("link"))
+	var keys [][]byte
+	err = b.ForEach(func(k, v []byte) error {
+		link := Link{}
+		if err := json.Unmarshal(v, &link); err != nil {
+			return fmt.Errorf("link unmarshal: %s", err.Error())
+		}
+		if fn(&link) {
+			keys = append(keys, k)
+		}
 		return nil
 	})
+	if err != nil {
+		return
+	}
+	for _, k := range keys {
+		if err = b.Delete(k); err != nil {
+			return
+		}
+	}
+	return len(keys), nil
 }
 
 func removeSubLinks(subID string) error {
@@ -109,7 +122,16 @@ func GetLink(id string) (rv *Link, err error) {
 var downloadLock sync.RWMutex
 
 // StreamLink streams release data to a writer and increases download count
-func StreamLink(id string, w io.Writer) (err error) {
+func StreamLink(id string, w io.Writer) error {
+	return streamLink(id, w, false)
+}
+
+// StreamLinkRaw is StreamLink without decompressing the stored file
+func StreamLinkRaw(id string, w io.Writer) error {
+	return streamLink(id, w, true)
+}
+
+func streamLink(id string, w io.Writer, raw bool) (err error) {
 	downloadLock.Lock()
 	defer downloadLock.Unlock()
 	link, err := GetLink(id)
@@ -135,7 +157,7 @@ func StreamLink(id string, w io.Writer) (err error) {
 		return
 	})
 
-	err = Stream(link.ReleaseID, w)
+	err = stream(link.ReleaseID, w, raw)
 	return
 }
 
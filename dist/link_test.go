@@ -30,7 +30,7 @@ func TestGetLink(t *testing.T) {
 
 func TestStreamLink(t *testing.T) {
 	buf := bytes.NewBuffer([]byte("OK"))
-	r, err := Publish(Release{}, buf)
+	r, err := Publish(Release{Version: "0.0.1"}, buf)
 	assert.NoError(t, err)
 	links, err := createLinks([]string{"a"}, r.ID)
 	assert.NoError(t, err)
@@ -48,7 +48,7 @@ func TestStreamLink(t *testing.T) {
 
 func TestGetSubDowloadStats(t *testing.T) {
 	buf := bytes.NewBuffer([]byte("OK"))
-	r, err := Publish(Release{}, buf)
+	r, err := Publish(Release{Version: "0.0.1"}, buf)
 	assert.NoError(t, err)
 	links, err := createLinks([]string{"b"}, r.ID)
 	assert.NoError(t, err)
@@ -0,0 +1,139 @@
+package dist
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// NotifyTemplateConfig is the notification email of a locale, empty fields use the default templates
+type NotifyTemplateConfig struct {
+	Subject string
+	Content string
+}
+
+type notifyTemplateSet struct {
+	subject *template.Template
+	content *template.Template
+}
+
+// notifyTemplates are the configured notification templates by locale
+var notifyTemplates = map[string]notifyTemplateSet{}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// normalizeLocale lower cases a language tag such as "zh_CN" to "zh-cn"
+func normalizeLocale(s string) (string, error) {
+	s = strings.ToLower(strings.Replace(strings.TrimSpace(s), "_", "-", -1))
+	if s != "" && !localePattern.MatchString(s) {
+		return "", validationError(http.StatusBadRequest, "locale", "invalid locale: %q", s)
+	}
+	return s, nil
+}
+
+// fallbackLocales returns a locale followed by its parents, e.g. "zh-tw", "zh"
+func fallbackLocales(locale string) (rv []string) {
+	for locale != "" {
+		rv = append(rv, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return
+}
+
+func parseNotifyTemplates(c map[string]NotifyTemplateConfig) (map[string]notifyTemplateSet, error) {
+	templates := map[string]notifyTemplateSet{}
+	for locale, tc := range c {
+		l, err := normalizeLocale(locale)
+		if err != nil || l == "" {
+			return nil, fmt.Errorf("NotifyTemplates: invalid locale: %q", locale)
+		}
+		set := notifyTemplateSet{}
+		if tc.Subject != "" {
+			if set.subject, err = template.New("notifyEmailSubjectTemplate." + l).Parse(tc.Subject); err != nil {
+				return nil, fmt.Errorf("NotifyTemplates: %s", err.Error())
+			}
+		}
+		if tc.Content != "" {
+			if set.content, err = template.New("notifyEmailContentTemplate." + l).Parse(tc.Content); err != nil {
+				return nil, fmt.Errorf("NotifyTemplates: %s", err.Error())
+			}
+		}
+		templates[l] = set
+	}
+	return templates, nil
+}
+
+// localeNotifyTemplates returns the templates of the closest configured locale, or the defaults
+func localeNotifyTemplates(locale string) (subject, content *template.Template) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	for _, l := range fallbackLocales(locale) {
+		set := notifyTemplates[l]
+		if subject == nil {
+			subject = set.subject
+		}
+		if content == nil {
+			content = set.content
+		}
+	}
+	if subject == nil {
+		subject = notifyEmailSubjectTemplate
+	}
+	if content == nil {
+		content = notifyEmailContentTemplate
+	}
+	return
+}
+
+// LocalizedDescription returns the description of the closest locale, or the default description
+func (r Release) LocalizedDescription(locale string) string {
+	for _, l := range fallbackLocales(locale) {
+		if d, ok := r.Descriptions[l]; ok {
+			return d
+		}
+	}
+	return r.Description
+}
+
+func isLocale(locale string) bool {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	_, ok := notifyTemplates[locale]
+	return ok
+}
+
+// Locales returns the locales with notification templates
+func Locales() []string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	rv := []string{}
+	for l := range notifyTemplates {
+		rv = append(rv, l)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func normalizeDescriptions(d map[string]string) (map[string]string, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	rv := map[string]string{}
+	for locale, text := range d {
+		l, err := normalizeLocale(locale)
+		if err != nil {
+			return nil, err
+		}
+		if l != "" && text != "" {
+			rv[l] = text
+		}
+	}
+	return rv, nil
+}
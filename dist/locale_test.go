@@ -0,0 +1,87 @@
+package dist
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	for in, expected := range map[string]string{"": "", "en": "en", " zh_CN ": "zh-cn", "zh-Hant-TW": "zh-hant-tw"} {
+		l, err := normalizeLocale(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, expected, l)
+	}
+	for _, bad := range []string{"english", "z", "zh--cn", "zh cn"} {
+		_, err := normalizeLocale(bad)
+		assert.Equal(t, "locale", err.(*ValidationError).Code, bad)
+	}
+	assert.Equal(t, []string{"zh-hant-tw", "zh-hant", "zh"}, fallbackLocales("zh-hant-tw"))
+	assert.Nil(t, fallbackLocales(""))
+}
+
+func TestLocaleNotifyTemplates(t *testing.T) {
+	defer useTestNotifyConfig()()
+	defer func(t map[string]notifyTemplateSet) { notifyTemplates = t }(notifyTemplates)
+	templates, err := parseNotifyTemplates(map[string]NotifyTemplateConfig{
+		"zh":    {Subject: "新版本 {{.Release.Version}}", Content: "{{.Release.Description}}"},
+		"zh_TW": {Subject: "新版本 (TW) {{.Release.Version}}"},
+	})
+	assert.NoError(t, err)
+	notifyTemplates = templates
+	assert.Equal(t, []string{"zh", "zh-tw"}, Locales())
+
+	subject, content := localeNotifyTemplates("zh-tw")
+	assert.Equal(t, "notifyEmailSubjectTemplate.zh-tw", subject.Name())
+	assert.Equal(t, "notifyEmailContentTemplate.zh", content.Name())
+	subject, content = localeNotifyTemplates("zh-cn")
+	assert.Equal(t, "notifyEmailSubjectTemplate.zh", subject.Name())
+	subject, content = localeNotifyTemplates("ko")
+	assert.Equal(t, notifyEmailSubjectTemplate, subject)
+	assert.Equal(t, notifyEmailContentTemplate, content)
+
+	_, err = parseNotifyTemplates(map[string]NotifyTemplateConfig{"": {Subject: "x"}})
+	assert.Error(t, err)
+	_, err = parseNotifyTemplates(map[string]NotifyTemplateConfig{"zh": {Subject: "{{"}})
+	assert.Error(t, err)
+}
+
+func TestLocalizedDescription(t *testing.T) {
+	r := Release{Description: "New maps", Descriptions: map[string]string{"zh": "新地图", "zh-tw": "新地圖"}}
+	assert.Equal(t, "新地圖", r.LocalizedDescription("zh-tw"))
+	assert.Equal(t, "新地图", r.LocalizedDescription("zh-cn"))
+	assert.Equal(t, "New maps", r.LocalizedDescription("ko"))
+	assert.Equal(t, "New maps", r.LocalizedDescription(""))
+}
+
+func TestNotifyByLocale(t *testing.T) {
+	defer useTestNotifyConfig()()
+
+	subs := SubsByDate{}
+	for _, s := range []Sub{
+		{Name: "En", Email: "locale.en@example.com"},
+		{Name: "Zh", Email: "locale.zh@example.com", Locale: "zh"},
+		{Name: "ZhCN", Email: "locale.zhcn@example.com", Locale: "zh_CN"},
+		{Name: "Zh2", Email: "locale.zh2@example.com", Locale: "ZH"},
+	} {
+		sub, err := Subscribe(s)
+		assert.NoError(t, err)
+		defer Unsubscribe(sub.ID)
+		subs = append(subs, *sub)
+	}
+	assert.Equal(t, "zh-cn", subs[2].Locale)
+
+	r, err := Publish(Release{Version: "0.0.1", Descriptions: map[string]string{"ZH": "新地图", "en": ""}}, bytes.NewBufferString("locale"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+	assert.Equal(t, map[string]string{"zh": "新地图"}, r.Descriptions)
+
+	_, err = Publish(Release{Version: "0.0.1", Descriptions: map[string]string{"chinese": "x"}}, bytes.NewBufferString("locale"))
+	assert.Equal(t, "locale", err.(*ValidationError).Code)
+
+	sent := testMg.Sent()
+	assert.NoError(t, notify(*r, subs))
+	// one message each for no locale, zh and zh-cn
+	assert.Equal(t, sent+3, testMg.Sent())
+}
@@ -3,10 +3,13 @@ package dist
 import (
 	"bytes"
 	"fmt"
+	"sort"
 
 	"gopkg.in/mailgun/mailgun-go.v1"
 )
 
+const mailFrom = "DreamHacks <notify@dreamdota.com>"
+
 type notifyEmailContext struct {
 	Release Release
 	Date    string
@@ -14,61 +17,122 @@ type notifyEmailContext struct {
 
 // NotifyAll sends email notification to all subscribers
 func NotifyAll(release Release) error {
-	ctx := notifyEmailContext{
-		Release: release,
-		Date:    release.Date.Time().Format("20060102150405"),
-	}
-
-	subIds := []string{}
-	subIDMap := map[string]*Sub{}
 	subs, err := ListSubs()
 	if err != nil {
 		return fmt.Errorf("notify: list subs: %s", err.Error())
 	}
+	return notify(release, subs)
+}
+
+// NotifySegment sends email notification to the subscribers of a segment
+func NotifySegment(release Release, segmentID string) error {
+	segment, err := GetSegment(segmentID)
+	if err != nil {
+		return err
+	}
+	subs, err := SegmentSubs(*segment)
+	if err != nil {
+		return fmt.Errorf("notify: segment subs: %s", err.Error())
+	}
+	return notify(release, subs)
+}
+
+// notify sends one message per subscriber locale, with the templates and description of that locale
+func notify(release Release, subs SubsByDate) error {
+	subIds := []string{}
+	subIDMap := map[string]*Sub{}
 	for i := range subs {
 		s := &subs[i]
+		if !s.Active() {
+			continue
+		}
 		subIds = append(subIds, s.ID)
 		subIDMap[s.ID] = s
 	}
 
+	if len(subIds) == 0 {
+		return nil
+	}
+
 	links, err := createLinks(subIds, release.ID)
 	if err != nil {
 		return fmt.Errorf("notify: create links: %s", err.Error())
 	}
 
-	m, err := getNotifyMessage(ctx)
-	if err != nil {
-		return fmt.Errorf("notify: create message: %s", err.Error())
-	}
+	byLocale := map[string][]Link{}
+	locales := []string{}
 	for _, link := range links {
-		m.AddRecipientAndVariables(subIDMap[link.SubID].Email, map[string]interface{}{
-			"Link": makeLink(link.ID),
-		})
+		l := subIDMap[link.SubID].Locale
+		if _, ok := byLocale[l]; !ok {
+			locales = append(locales, l)
+		}
+		byLocale[l] = append(byLocale[l], link)
 	}
+	sort.Strings(locales)
 
-	_, _, err = mg.Send(m)
-	if err != nil {
-		return fmt.Errorf("notify: send: %s", err.Error())
+	for _, l := range locales {
+		localized := release
+		localized.Description = release.LocalizedDescription(l)
+		ctx := notifyEmailContext{
+			Release: localized,
+			Date:    release.Date.Time().Format("20060102150405"),
+		}
+		m, err := getNotifyMessage(ctx, l)
+		if err != nil {
+			return fmt.Errorf("notify: create message: %s", err.Error())
+		}
+		for _, link := range byLocale[l] {
+			vars, err := notifyRecipientVariables(link)
+			if err != nil {
+				return fmt.Errorf("notify: recipient variables: %s", err.Error())
+			}
+			m.AddRecipientAndVariables(subIDMap[link.SubID].Email, vars)
+		}
+		m.AddHeader("List-Unsubscribe", "<%recipient.Unsubscribe%>")
+		m.AddHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+
+		_, _, err = mailer().Send(m)
+		if err != nil {
+			return fmt.Errorf("notify: send: %s", err.Error())
+		}
 	}
 
 	return nil
 }
 
-func getNotifyMessage(ctx notifyEmailContext) (*mailgun.Message, error) {
+// notifyRecipientVariables returns the %recipient.*% values of a subscriber's notification
+func notifyRecipientVariables(link Link) (map[string]interface{}, error) {
+	unsubscribe, err := unsubscribeLink(link.SubID)
+	if err != nil {
+		return nil, err
+	}
+	preferences, err := preferencesLink(link.SubID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"Link":        makeLink(link.ID),
+		"Unsubscribe": unsubscribe,
+		"Preferences": preferences,
+	}, nil
+}
+
+func getNotifyMessage(ctx notifyEmailContext, locale string) (*mailgun.Message, error) {
+	subjectTemplate, contentTemplate := localeNotifyTemplates(locale)
 	buf := bytes.NewBuffer(nil)
 	var subject, content string
-	err := notifyEmailSubjectTemplate.Execute(buf, ctx)
+	err := subjectTemplate.Execute(buf, ctx)
 	if err != nil {
 		return nil, err
 	}
 	subject = string(buf.Bytes())
 	buf.Reset()
-	err = notifyEmailContentTemplate.Execute(buf, ctx)
+	err = contentTemplate.Execute(buf, ctx)
 	if err != nil {
 		return nil, err
 	}
 	content = string(buf.Bytes())
-	return mailgun.NewMessage("DreamHacks <notify@dreamdota.com>", subject, content), nil
+	return mailgun.NewMessage(mailFrom, subject, content), nil
 }
 
 // NotifySubscriber sends email to a subscriber
@@ -0,0 +1,330 @@
+package dist
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+	"gopkg.in/mailgun/mailgun-go.v1"
+)
+
+// SubscriptionConfig configures the public double opt-in subscription flow
+type SubscriptionConfig struct {
+	// ConfirmTTL is how long a confirmation link stays valid, e.g. "48h"
+	ConfirmTTL                  string
+	ConfirmEmailSubjectTemplate string
+	ConfirmEmailContentTemplate string
+	// Channels are tags subscribers may pick themselves in the preference center
+	Channels                   []string
+	ChangeEmailSubjectTemplate string
+	ChangeEmailContentTemplate string
+}
+
+const (
+	defaultConfirmTTL                  = 48 * time.Hour
+	defaultConfirmEmailSubjectTemplate = "Please confirm your subscription"
+	defaultConfirmEmailContentTemplate = "Hi {{.Sub.Name}},\n\nPlease confirm your subscription to SC2Advanced release notifications:\n\n{{.Link}}\n\nIf you did not subscribe, you can ignore this email."
+	confirmResendInterval              = 10 * time.Minute
+)
+
+var confirmTTL = defaultConfirmTTL
+var confirmEmailSubjectTemplate = template.Must(template.New("confirmEmailSubjectTemplate").Parse(defaultConfirmEmailSubjectTemplate))
+var confirmEmailContentTemplate = template.Must(template.New("confirmEmailContentTemplate").Parse(defaultConfirmEmailContentTemplate))
+
+// getConfirmTTL returns how long confirmation links stay valid
+func getConfirmTTL() time.Duration {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return confirmTTL
+}
+
+var makeConfirmLink = func(token string) string {
+	return "/subscribe/confirm?token=" + token
+}
+
+func configureSubscription(baseURI string, c SubscriptionConfig) error {
+	makeConfirmLink = func(token string) string {
+		return baseURI + "/subscribe/confirm?token=" + token
+	}
+
+	cs, err := normalizeTags(c.Channels)
+	if err != nil {
+		return fmt.Errorf("Channels: %s", err.Error())
+	}
+	channels = cs
+	makePreferencesLink = func(token string) string {
+		return baseURI + "/preferences?token=" + token
+	}
+	makeChangeEmailLink = func(token string) string {
+		return baseURI + "/preferences/confirm-email?token=" + token
+	}
+	return nil
+}
+
+// subConfirm is a pending confirmation, keyed by the hash of its token
+type subConfirm struct {
+	SubID   string
+	Date    time.Time
+	Expires time.Time
+}
+
+// ErrConfirmTokenInvalid is returned when a confirmation token is unknown or expired
+var ErrConfirmTokenInvalid = errors.New("confirmation link is invalid or has expired")
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RequestSubscription creates a pending subscriber and emails a confirmation link.
+// Requests for an address that is already subscribed are silently ignored.
+func RequestSubscription(sub Sub) error {
+	email, err := NormalizeEmail(sub.Email)
+	if err != nil {
+		return err
+	}
+	sub.Email = email
+	if sub.Locale, err = normalizeLocale(sub.Locale); err != nil {
+		return err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	send := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sub"))
+		cb := tx.Bucket([]byte("sub_confirm"))
+
+		var existing *Sub
+		if v := b.Get([]byte(subIDByEmail(tx, sub.Email))); v != nil {
+			existing = &Sub{}
+			if err := json.Unmarshal(v, existing); err != nil {
+				return err
+			}
+		}
+
+		if existing != nil {
+			if existing.Status != SubPending {
+				return nil
+			}
+			// drop earlier tokens, unless one was sent very recently
+			var stale [][]byte
+			recent := false
+			err := cb.ForEach(func(k, v []byte) error {
+				c := subConfirm{}
+				if err := json.Unmarshal(v, &c); err != nil {
+					return err
+				}
+				if c.SubID == existing.ID {
+					stale = append(stale, k)
+					recent = recent || now.Sub(c.Date) < confirmResendInterval
+				}
+				return nil
+			})
+			if err != nil || recent {
+				return err
+			}
+			for _, k := range stale {
+				if err := cb.Delete(k); err != nil {
+					return err
+				}
+			}
+			sub = *existing
+		} else {
+			sub.ID = uuid.NewV4().String()
+			sub.Date = now
+			sub.Status = SubPending
+			if err := putNewSub(tx, sub, ConsentRequested); err != nil {
+				return err
+			}
+		}
+
+		j, err := json.Marshal(subConfirm{
+			SubID:   sub.ID,
+			Date:    now,
+			Expires: now.Add(getConfirmTTL()),
+		})
+		if err != nil {
+			return err
+		}
+		send = true
+		return cb.Put(hashToken(token), j)
+	})
+	if err != nil || !send {
+		return err
+	}
+
+	m, err := getConfirmMessage(sub, makeConfirmLink(token))
+	if err != nil {
+		return fmt.Errorf("confirm: create message: %s", err.Error())
+	}
+	if _, _, err = mailer().Send(m); err != nil {
+		return fmt.Errorf("confirm: send: %s", err.Error())
+	}
+	return nil
+}
+
+type confirmEmailContext struct {
+	Sub  Sub
+	Link string
+}
+
+func getConfirmMessage(sub Sub, link string) (*mailgun.Message, error) {
+	ctx := confirmEmailContext{Sub: sub, Link: link}
+	configMu.RLock()
+	subjectTemplate, contentTemplate := confirmEmailSubjectTemplate, confirmEmailContentTemplate
+	configMu.RUnlock()
+	buf := bytes.NewBuffer(nil)
+	if err := subjectTemplate.Execute(buf, ctx); err != nil {
+		return nil, err
+	}
+	subject := buf.String()
+	buf.Reset()
+	if err := contentTemplate.Execute(buf, ctx); err != nil {
+		return nil, err
+	}
+	return mailgun.NewMessage(mailFrom, subject, buf.String(), sub.Email), nil
+}
+
+// ConfirmSubscription activates the pending subscriber a token was issued for
+func ConfirmSubscription(token string) (rv *Sub, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		cb := tx.Bucket([]byte("sub_confirm"))
+		key := hashToken(token)
+		v := cb.Get(key)
+		if v == nil {
+			return ErrConfirmTokenInvalid
+		}
+		c := subConfirm{}
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+		if time.Now().After(c.Expires) {
+			return ErrConfirmTokenInvalid
+		}
+		if err := cb.Delete(key); err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte("sub"))
+		v = b.Get([]byte(c.SubID))
+		if v == nil {
+			return ErrConfirmTokenInvalid
+		}
+		sub := Sub{}
+		if err := json.Unmarshal(v, &sub); err != nil {
+			return err
+		}
+		if sub.Status == SubPending {
+			sub.Status = SubActive
+			j, err := json.Marshal(sub)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(sub.ID), j); err != nil {
+				return err
+			}
+			if err := recordConsent(tx, sub.ID, ConsentConfirmed, ""); err != nil {
+				return err
+			}
+		}
+		rv = &sub
+		return nil
+	})
+	return
+}
+
+// PurgeUnconfirmed removes expired confirmation and email change tokens, and subscribers that never confirmed
+func PurgeUnconfirmed() (n int, err error) {
+	now := time.Now()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sub"))
+		cb := tx.Bucket([]byte("sub_confirm"))
+
+		var expired [][]byte
+		var subIDs []string
+		err := cb.ForEach(func(k, v []byte) error {
+			c := subConfirm{}
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			if now.After(c.Expires) {
+				expired = append(expired, k)
+				subIDs = append(subIDs, c.SubID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := cb.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		eb := tx.Bucket([]byte("email_change"))
+		expired = expired[:0]
+		err = eb.ForEach(func(k, v []byte) error {
+			c := emailChange{}
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			if now.After(c.Expires) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := eb.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range subIDs {
+			v := b.Get([]byte(id))
+			if v == nil {
+				continue
+			}
+			sub := Sub{}
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return err
+			}
+			if sub.Status != SubPending {
+				continue
+			}
+			if _, err := deleteSub(tx, id); err != nil {
+				return err
+			}
+			if err := deleteConsent(tx, id); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return
+}
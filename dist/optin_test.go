@@ -0,0 +1,100 @@
+package dist
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func captureConfirmLinks() (tokens *[]string, restore func()) {
+	tokens = &[]string{}
+	old := makeConfirmLink
+	makeConfirmLink = func(token string) string {
+		*tokens = append(*tokens, token)
+		return old(token)
+	}
+	return tokens, func() { makeConfirmLink = old }
+}
+
+func findSubByEmail(t *testing.T, email string) *Sub {
+	list, err := ListSubs()
+	assert.NoError(t, err)
+	for i := range list {
+		if list[i].Email == email {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+func TestRequestSubscription(t *testing.T) {
+	tokens, restore := captureConfirmLinks()
+	defer restore()
+
+	assert.Equal(t, ErrInvalidEmail, RequestSubscription(Sub{Name: "Name", Email: "not an email"}))
+
+	sent := testMg.Sent()
+	assert.NoError(t, RequestSubscription(Sub{Name: "Name", Email: " optin@example.com "}))
+	assert.Equal(t, sent+1, testMg.Sent())
+	assert.Len(t, *tokens, 1)
+
+	sub := findSubByEmail(t, "optin@example.com")
+	assert.NotNil(t, sub)
+	assert.Equal(t, SubPending, sub.Status)
+	assert.False(t, sub.Active())
+
+	// a second request right away does not send another email
+	assert.NoError(t, RequestSubscription(Sub{Name: "Name", Email: "optin@example.com"}))
+	assert.Equal(t, sent+1, testMg.Sent())
+
+	_, err := ConfirmSubscription("invalid")
+	assert.Equal(t, ErrConfirmTokenInvalid, err)
+
+	confirmed, err := ConfirmSubscription((*tokens)[0])
+	assert.NoError(t, err)
+	assert.Equal(t, sub.ID, confirmed.ID)
+	assert.True(t, confirmed.Active())
+
+	// tokens are single use
+	_, err = ConfirmSubscription((*tokens)[0])
+	assert.Equal(t, ErrConfirmTokenInvalid, err)
+
+	// already subscribed addresses are ignored
+	assert.NoError(t, RequestSubscription(Sub{Name: "Name", Email: "OPTIN@example.com"}))
+	assert.Equal(t, sent+1, testMg.Sent())
+
+	assert.NoError(t, Unsubscribe(sub.ID))
+}
+
+func TestPurgeUnconfirmed(t *testing.T) {
+	tokens, restore := captureConfirmLinks()
+	defer restore()
+
+	assert.NoError(t, RequestSubscription(Sub{Name: "Name", Email: "expired@example.com"}))
+	sub := findSubByEmail(t, "expired@example.com")
+	assert.NotNil(t, sub)
+
+	// expire the confirmation
+	assert.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sub_confirm"))
+		key := hashToken((*tokens)[0])
+		c := subConfirm{}
+		if err := json.Unmarshal(b.Get(key), &c); err != nil {
+			return err
+		}
+		c.Expires = time.Now().Add(-time.Second)
+		j, _ := json.Marshal(c)
+		return b.Put(key, j)
+	}))
+
+	_, err := ConfirmSubscription((*tokens)[0])
+	assert.Equal(t, ErrConfirmTokenInvalid, err)
+
+	n, err := PurgeUnconfirmed()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Nil(t, findSubByEmail(t, "expired@example.com"))
+}
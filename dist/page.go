@@ -0,0 +1,224 @@
+package dist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// Sort orders of paged listings, a leading "-" sorts descending
+const (
+	SortDate  = "date"
+	SortEmail = "email"
+)
+
+const maxPageLimit = 1000
+
+// PageOptions selects a page of a listing
+type PageOptions struct {
+	// Cursor is the Next value of the previous page, empty for the first page
+	Cursor string
+	// Limit is the page size, 0 returns everything after the cursor
+	Limit int
+	// Query filters by a case insensitive substring
+	Query string
+	// Sort is an order such as "date" or "-date"
+	Sort string
+}
+
+// PageInfo describes a returned page
+type PageInfo struct {
+	// Total is the number of items matching the query over all pages
+	Total int
+	// Next is the cursor of the following page, empty on the last page
+	Next string
+}
+
+type pageIndex struct {
+	bucket string
+	desc   bool
+}
+
+func pageSortIndex(order string, indexes map[string]string) (rv pageIndex, err error) {
+	rv.bucket = indexes[strings.TrimPrefix(order, "-")]
+	rv.desc = strings.HasPrefix(order, "-")
+	if rv.bucket == "" {
+		keys := []string{}
+		for k := range indexes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		err = validationError(http.StatusBadRequest, "sort", "sort must be one of %s, optionally prefixed with -", strings.Join(keys, ", "))
+	}
+	return
+}
+
+// page walks an index bucket in order and calls visit with the records its entries point to.
+// visit reports whether a record matches the query, and keeps it when collect is set.
+// Without a query the walk starts at the cursor and the total is the size of data,
+// with one every entry is visited to count the matches.
+func page(tx *bolt.Tx, idx pageIndex, opts PageOptions, data *bolt.Bucket, visit func(record []byte, collect bool) (bool, error)) (*PageInfo, error) {
+	if opts.Limit < 0 || opts.Limit > maxPageLimit {
+		return nil, validationError(http.StatusBadRequest, "limit", "limit must be between 0 and %d", maxPageLimit)
+	}
+	var cursor []byte
+	if opts.Cursor != "" {
+		var err error
+		if cursor, err = base64.RawURLEncoding.DecodeString(opts.Cursor); err != nil || len(cursor) == 0 {
+			return nil, validationError(http.StatusBadRequest, "cursor", "cursor is invalid")
+		}
+	}
+
+	c := tx.Bucket([]byte(idx.bucket)).Cursor()
+	first, next := c.First, c.Next
+	if idx.desc {
+		first, next = c.Last, c.Prev
+	}
+	before := func(k []byte) bool {
+		if cursor == nil {
+			return false
+		}
+		if idx.desc {
+			return bytes.Compare(k, cursor) >= 0
+		}
+		return bytes.Compare(k, cursor) <= 0
+	}
+
+	rv := &PageInfo{}
+	k, id := first()
+	if opts.Query == "" {
+		rv.Total = data.Stats().KeyN
+		if cursor != nil {
+			k, id = c.Seek(cursor)
+			switch {
+			case idx.desc && k == nil:
+				k, id = c.Last()
+			case idx.desc:
+				k, id = c.Prev()
+			case bytes.Equal(k, cursor):
+				k, id = c.Next()
+			}
+		}
+	}
+
+	n := 0
+	var last []byte
+	for ; k != nil; k, id = next() {
+		full := opts.Limit > 0 && n >= opts.Limit
+		if full && opts.Query == "" {
+			rv.Next = base64.RawURLEncoding.EncodeToString(last)
+			break
+		}
+		record := data.Get(id)
+		if record == nil {
+			continue
+		}
+		skip := before(k)
+		ok, err := visit(record, !full && !skip)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if opts.Query != "" {
+			rv.Total++
+		}
+		if skip {
+			continue
+		}
+		if full {
+			if rv.Next == "" {
+				rv.Next = base64.RawURLEncoding.EncodeToString(last)
+			}
+			continue
+		}
+		n++
+		last = append(last[:0], k...)
+	}
+	return rv, nil
+}
+
+func matchesQuery(query string, fields ...string) bool {
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSubsPage returns a page of subscribers, sorted by "date" (default) or "email".
+// The query searches names and email addresses.
+func ListSubsPage(opts PageOptions) (list SubsByDate, info *PageInfo, err error) {
+	if opts.Sort == "" {
+		opts.Sort = SortDate
+	}
+	idx, err := pageSortIndex(opts.Sort, map[string]string{SortDate: "sub_date", SortEmail: "sub_email"})
+	if err != nil {
+		return
+	}
+	query := strings.ToLower(strings.TrimSpace(opts.Query))
+	opts.Query = query
+	list = SubsByDate{}
+	err = db.View(func(tx *bolt.Tx) (err error) {
+		info, err = page(tx, idx, opts, tx.Bucket([]byte("sub")), func(record []byte, collect bool) (bool, error) {
+			s := Sub{}
+			if err := json.Unmarshal(record, &s); err != nil {
+				return false, err
+			}
+			if query != "" && !matchesQuery(query, s.Name, s.Email) {
+				return false, nil
+			}
+			if collect {
+				list = append(list, s)
+			}
+			return true, nil
+		})
+		return
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// ListPage returns a page of releases, sorted by "-date" (default) or "date".
+// The query searches versions and descriptions.
+func ListPage(opts PageOptions) (list ReleasesByDateDesc, info *PageInfo, err error) {
+	if opts.Sort == "" {
+		opts.Sort = "-" + SortDate
+	}
+	idx, err := pageSortIndex(opts.Sort, map[string]string{SortDate: "release_date"})
+	if err != nil {
+		return
+	}
+	query := strings.ToLower(strings.TrimSpace(opts.Query))
+	opts.Query = query
+	list = ReleasesByDateDesc{}
+	err = db.View(func(tx *bolt.Tx) (err error) {
+		info, err = page(tx, idx, opts, tx.Bucket([]byte("release")), func(record []byte, collect bool) (bool, error) {
+			r := Release{}
+			if err := json.Unmarshal(record, &r); err != nil {
+				return false, err
+			}
+			if query != "" && !matchesQuery(query, r.Version, r.Description) {
+				return false, nil
+			}
+			if collect {
+				list = append(list, r)
+			}
+			return true, nil
+		})
+		return
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return
+}
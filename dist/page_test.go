@@ -0,0 +1,119 @@
+package dist
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListSubsPage(t *testing.T) {
+	ids := []string{}
+	for i := 0; i < 5; i++ {
+		sub, err := Subscribe(Sub{Name: fmt.Sprintf("Pager %d", i), Email: fmt.Sprintf("pager%d@example.com", 4-i)})
+		assert.NoError(t, err)
+		defer Unsubscribe(sub.ID)
+		ids = append(ids, sub.ID)
+	}
+	pageIDs := func(list SubsByDate) []string {
+		rv := []string{}
+		for _, s := range list {
+			rv = append(rv, s.ID)
+		}
+		return rv
+	}
+
+	list, info, err := ListSubsPage(PageOptions{Query: "PAGER", Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, ids[:2], pageIDs(list))
+	assert.Equal(t, 5, info.Total)
+	assert.NotEmpty(t, info.Next)
+
+	list, info, err = ListSubsPage(PageOptions{Query: "pager", Limit: 2, Cursor: info.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, ids[2:4], pageIDs(list))
+	assert.Equal(t, 5, info.Total)
+
+	list, info, err = ListSubsPage(PageOptions{Query: "pager", Limit: 2, Cursor: info.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, ids[4:], pageIDs(list))
+	assert.Equal(t, "", info.Next)
+
+	list, _, err = ListSubsPage(PageOptions{Query: "pager", Sort: "-date", Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, ids[4:], pageIDs(list))
+
+	list, _, err = ListSubsPage(PageOptions{Query: "pager", Sort: "email"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ids[4], ids[3], ids[2], ids[1], ids[0]}, pageIDs(list))
+
+	list, info, err = ListSubsPage(PageOptions{Query: "pager3@"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ids[1]}, pageIDs(list))
+	assert.Equal(t, 1, info.Total)
+
+	// without a query, pages walk the index from the cursor
+	all, err := ListSubs()
+	assert.NoError(t, err)
+	seen := []string{}
+	cursor := ""
+	for {
+		list, info, err = ListSubsPage(PageOptions{Limit: 3, Cursor: cursor})
+		assert.NoError(t, err)
+		assert.Equal(t, len(all), info.Total)
+		seen = append(seen, pageIDs(list)...)
+		if info.Next == "" {
+			break
+		}
+		cursor = info.Next
+	}
+	assert.Equal(t, pageIDs(all), seen)
+
+	// a deleted cursor entry still continues after its position
+	list, info, err = ListSubsPage(PageOptions{Query: "pager", Sort: "-date", Limit: 2})
+	assert.NoError(t, err)
+	assert.NoError(t, Unsubscribe(ids[3]))
+	list, _, err = ListSubsPage(PageOptions{Query: "pager", Sort: "-date", Limit: 2, Cursor: info.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ids[2], ids[1]}, pageIDs(list))
+
+	_, _, err = ListSubsPage(PageOptions{Cursor: "!"})
+	assert.Equal(t, "cursor", err.(*ValidationError).Code)
+	_, _, err = ListSubsPage(PageOptions{Sort: "name"})
+	assert.Equal(t, "sort", err.(*ValidationError).Code)
+	_, _, err = ListSubsPage(PageOptions{Limit: maxPageLimit + 1})
+	assert.Equal(t, "limit", err.(*ValidationError).Code)
+}
+
+func TestListPage(t *testing.T) {
+	a, err := Publish(Release{Version: "9.0.0", Description: "Paged release"}, bytes.NewBufferString("a"))
+	assert.NoError(t, err)
+	defer Unpublish(a.ID)
+	b, err := Publish(Release{Version: "9.0.1", Description: "Another paged release"}, bytes.NewBufferString("b"))
+	assert.NoError(t, err)
+	defer Unpublish(b.ID)
+
+	list, info, err := ListPage(PageOptions{Query: "paged", Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, info.Total)
+	assert.Equal(t, b.ID, list[0].ID)
+
+	list, info, err = ListPage(PageOptions{Query: "paged", Limit: 1, Cursor: info.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, a.ID, list[0].ID)
+	assert.Equal(t, "", info.Next)
+
+	list, _, err = ListPage(PageOptions{Query: "9.0.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	list, _, err = ListPage(PageOptions{Query: "paged", Sort: "date"})
+	assert.NoError(t, err)
+	assert.Equal(t, a.ID, list[0].ID)
+
+	assert.NoError(t, Unpublish(b.ID))
+	_, info, err = ListPage(PageOptions{Query: "paged"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, info.Total)
+}
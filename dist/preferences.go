@@ -0,0 +1,310 @@
+package dist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"gopkg.in/mailgun/mailgun-go.v1"
+)
+
+const (
+	defaultChangeEmailSubjectTemplate = "Please confirm your new email address"
+	defaultChangeEmailContentTemplate = "Hi {{.Sub.Name}},\n\nPlease confirm {{.Email}} as the address for SC2Advanced release notifications:\n\n{{.Link}}\n\nIf you did not ask for this, you can ignore this email."
+)
+
+var channels []string
+var changeEmailSubjectTemplate = template.Must(template.New("changeEmailSubjectTemplate").Parse(defaultChangeEmailSubjectTemplate))
+var changeEmailContentTemplate = template.Must(template.New("changeEmailContentTemplate").Parse(defaultChangeEmailContentTemplate))
+var makePreferencesLink = func(token string) string {
+	return "/preferences?token=" + token
+}
+var makeChangeEmailLink = func(token string) string {
+	return "/preferences/confirm-email?token=" + token
+}
+
+// ErrChangeEmailTokenInvalid is returned when an email change token is unknown or expired
+var ErrChangeEmailTokenInvalid = errors.New("email change link is invalid or has expired")
+
+// Preferences are the subscriber settings editable from the preference center
+type Preferences struct {
+	Name  string
+	Email string
+	// PendingEmail is a new address waiting to be confirmed
+	PendingEmail string `json:",omitempty"`
+	// Channels are the selected tags of AvailableChannels
+	Channels []string
+	// AvailableChannels are the tags subscribers may pick themselves
+	AvailableChannels []string
+	// Locale is one of AvailableLocales, or empty for the default language
+	Locale           string
+	AvailableLocales []string
+}
+
+// emailChange is a pending email change, keyed by the hash of its token
+type emailChange struct {
+	SubID   string
+	Email   string
+	Date    time.Time
+	Expires time.Time
+}
+
+func preferencesLink(subID string) (string, error) {
+	token, err := signLinkToken("preferences", subID)
+	if err != nil {
+		return "", err
+	}
+	return makePreferencesLink(token), nil
+}
+
+func isChannel(tag string) bool {
+	for _, c := range channels {
+		if c == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPreferences returns the preferences of the subscriber a preferences token was issued for
+func GetPreferences(token string) (*Preferences, error) {
+	id, err := verifyLinkToken("preferences", token)
+	if err != nil {
+		return nil, err
+	}
+	return getPreferences(id)
+}
+
+func getPreferences(id string) (rv *Preferences, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte("sub")).Get([]byte(id))
+		if v == nil {
+			return ErrSubNotFound
+		}
+		sub := Sub{}
+		if err := json.Unmarshal(v, &sub); err != nil {
+			return err
+		}
+		rv = &Preferences{
+			Name:              sub.Name,
+			Email:             sub.Email,
+			Channels:          []string{},
+			AvailableChannels: channels,
+			Locale:            sub.Locale,
+			AvailableLocales:  Locales(),
+		}
+		for _, t := range sub.Tags {
+			if isChannel(t) {
+				rv.Channels = append(rv.Channels, t)
+			}
+		}
+		now := time.Now()
+		return tx.Bucket([]byte("email_change")).ForEach(func(k, v []byte) error {
+			c := emailChange{}
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			if c.SubID == id && now.Before(c.Expires) {
+				rv.PendingEmail = c.Email
+			}
+			return nil
+		})
+	})
+	return
+}
+
+// UpdatePreferences saves the name and channels of the subscriber a preferences token was issued for.
+// A different email address is only used after it is confirmed from a link sent to it.
+func UpdatePreferences(token string, p Preferences) (*Preferences, error) {
+	id, err := verifyLinkToken("preferences", token)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := getSub(id)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, err := normalizeTags(p.Channels)
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{}
+	for _, t := range sub.Tags {
+		if !isChannel(t) {
+			tags = append(tags, t)
+		}
+	}
+	for _, t := range selected {
+		if !isChannel(t) {
+			return nil, validationError(http.StatusBadRequest, "channel", "unknown channel: %s", t)
+		}
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+
+	email, err := NormalizeEmail(p.Email)
+	if err != nil {
+		return nil, err
+	}
+	changed := email != sub.Email
+
+	locale, err := normalizeLocale(p.Locale)
+	if err != nil {
+		return nil, err
+	}
+	if locale != "" && !isLocale(locale) {
+		return nil, validationError(http.StatusBadRequest, "locale", "unsupported locale: %s", locale)
+	}
+
+	sub.Name = strings.TrimSpace(p.Name)
+	sub.Tags = tags
+	sub.Locale = locale
+	if _, err := UpdateSubscriber(*sub); err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return recordConsent(tx, id, ConsentPreferences, strings.Join(tags, " "))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := requestEmailChange(*sub, email); err != nil {
+			return nil, err
+		}
+	}
+	return getPreferences(id)
+}
+
+func getSub(id string) (rv *Sub, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte("sub")).Get([]byte(id))
+		if v == nil {
+			return ErrSubNotFound
+		}
+		rv = &Sub{}
+		return json.Unmarshal(v, rv)
+	})
+	return
+}
+
+// requestEmailChange replaces pending changes of a subscriber and emails a confirmation link to the new address
+func requestEmailChange(sub Sub, email string) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = db.Update(func(tx *bolt.Tx) error {
+		if id := subIDByEmail(tx, email); id != "" && id != sub.ID {
+			return ErrEmailExists
+		}
+		b := tx.Bucket([]byte("email_change"))
+		var stale [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			c := emailChange{}
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			if c.SubID == sub.ID || now.After(c.Expires) {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		j, err := json.Marshal(emailChange{
+			SubID:   sub.ID,
+			Email:   email,
+			Date:    now,
+			Expires: now.Add(getConfirmTTL()),
+		})
+		if err != nil {
+			return err
+		}
+		return b.Put(hashToken(token), j)
+	})
+	if err != nil {
+		return err
+	}
+
+	m, err := getChangeEmailMessage(sub, email, makeChangeEmailLink(token))
+	if err != nil {
+		return fmt.Errorf("change email: create message: %s", err.Error())
+	}
+	if _, _, err = mailer().Send(m); err != nil {
+		return fmt.Errorf("change email: send: %s", err.Error())
+	}
+	return nil
+}
+
+type changeEmailContext struct {
+	Sub   Sub
+	Email string
+	Link  string
+}
+
+func getChangeEmailMessage(sub Sub, email, link string) (*mailgun.Message, error) {
+	ctx := changeEmailContext{Sub: sub, Email: email, Link: link}
+	configMu.RLock()
+	subjectTemplate, contentTemplate := changeEmailSubjectTemplate, changeEmailContentTemplate
+	configMu.RUnlock()
+	buf := bytes.NewBuffer(nil)
+	if err := subjectTemplate.Execute(buf, ctx); err != nil {
+		return nil, err
+	}
+	subject := buf.String()
+	buf.Reset()
+	if err := contentTemplate.Execute(buf, ctx); err != nil {
+		return nil, err
+	}
+	return mailgun.NewMessage(mailFrom, subject, buf.String(), email), nil
+}
+
+// ConfirmEmailChange switches a subscriber to the address an email change token was sent to
+func ConfirmEmailChange(token string) (sub *Sub, err error) {
+	key := hashToken(token)
+	// the token is only used up if the address is still free
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("email_change"))
+		v := b.Get(key)
+		if v == nil {
+			return ErrChangeEmailTokenInvalid
+		}
+		c := emailChange{}
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+		if time.Now().After(c.Expires) {
+			return ErrChangeEmailTokenInvalid
+		}
+		sub, err = modifySub(tx, c.SubID, func(s *Sub) {
+			s.Email = c.Email
+		})
+		if err != nil {
+			return err
+		}
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+		return recordConsent(tx, sub.ID, ConsentEmailChanged, sub.Email)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
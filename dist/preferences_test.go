@@ -0,0 +1,124 @@
+package dist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func captureChangeEmailLinks() (tokens *[]string, restore func()) {
+	tokens = &[]string{}
+	old := makeChangeEmailLink
+	makeChangeEmailLink = func(token string) string {
+		*tokens = append(*tokens, token)
+		return old(token)
+	}
+	return tokens, func() { makeChangeEmailLink = old }
+}
+
+func TestPreferences(t *testing.T) {
+	defer func(c []string) { channels = c }(channels)
+	channels = []string{"beta", "stable"}
+	tokens, restore := captureChangeEmailLinks()
+	defer restore()
+
+	sub, err := Subscribe(Sub{Name: "Prefs", Email: "prefs@example.com", Tags: []string{"organizer", "stable"}})
+	assert.NoError(t, err)
+	defer Unsubscribe(sub.ID)
+	token, err := signLinkToken("preferences", sub.ID)
+	assert.NoError(t, err)
+
+	_, err = GetPreferences(token + "0")
+	assert.Equal(t, ErrLinkTokenInvalid, err)
+	unsubscribeToken, err := signLinkToken("unsubscribe", sub.ID)
+	assert.NoError(t, err)
+	_, err = GetPreferences(unsubscribeToken)
+	assert.Equal(t, ErrLinkTokenInvalid, err)
+
+	prefs, err := GetPreferences(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "prefs@example.com", prefs.Email)
+	assert.Equal(t, []string{"stable"}, prefs.Channels)
+	assert.Equal(t, []string{"beta", "stable"}, prefs.AvailableChannels)
+
+	// channels replace channel tags, other tags are kept
+	prefs, err = UpdatePreferences(token, Preferences{Name: "Renamed", Email: "prefs@example.com", Channels: []string{"Beta"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", prefs.Name)
+	assert.Equal(t, []string{"beta"}, prefs.Channels)
+	saved, err := FindSubByEmail("prefs@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"beta", "organizer"}, saved.Tags)
+
+	defer func(t map[string]notifyTemplateSet) { notifyTemplates = t }(notifyTemplates)
+	notifyTemplates = map[string]notifyTemplateSet{"zh": {}}
+	prefs, err = UpdatePreferences(token, Preferences{Name: "Renamed", Email: "prefs@example.com", Channels: []string{"beta"}, Locale: "ZH"})
+	assert.NoError(t, err)
+	assert.Equal(t, "zh", prefs.Locale)
+	assert.Equal(t, []string{"zh"}, prefs.AvailableLocales)
+	_, err = UpdatePreferences(token, Preferences{Email: "prefs@example.com", Locale: "ko"})
+	assert.Equal(t, "locale", err.(*ValidationError).Code)
+
+	_, err = UpdatePreferences(token, Preferences{Email: "prefs@example.com", Channels: []string{"organizer"}})
+	assert.Equal(t, "channel", err.(*ValidationError).Code)
+	_, err = UpdatePreferences(token, Preferences{Email: "not an email"})
+	assert.Equal(t, ErrInvalidEmail, err)
+
+	other, err := Subscribe(Sub{Name: "Other", Email: "prefs.other@example.com"})
+	assert.NoError(t, err)
+	defer Unsubscribe(other.ID)
+	_, err = UpdatePreferences(token, Preferences{Email: "prefs.other@example.com"})
+	assert.Equal(t, ErrEmailExists, err)
+
+	// email changes wait for confirmation at the new address
+	sent := testMg.Sent()
+	prefs, err = UpdatePreferences(token, Preferences{Name: "Renamed", Email: "Prefs.New@example.com", Channels: []string{"beta"}})
+	assert.NoError(t, err)
+	assert.Equal(t, sent+1, testMg.Sent())
+	assert.Equal(t, "prefs@example.com", prefs.Email)
+	assert.Equal(t, "prefs.new@example.com", prefs.PendingEmail)
+	assert.Equal(t, 1, len(*tokens))
+
+	_, err = ConfirmEmailChange("invalid")
+	assert.Equal(t, ErrChangeEmailTokenInvalid, err)
+	changed, err := ConfirmEmailChange((*tokens)[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "prefs.new@example.com", changed.Email)
+	_, err = ConfirmEmailChange((*tokens)[0])
+	assert.Equal(t, ErrChangeEmailTokenInvalid, err)
+
+	prefs, err = GetPreferences(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "prefs.new@example.com", prefs.Email)
+	assert.Equal(t, "", prefs.PendingEmail)
+	_, err = FindSubByEmail("prefs@example.com")
+	assert.Equal(t, ErrSubNotFound, err)
+}
+
+func TestConfirmEmailChangeTaken(t *testing.T) {
+	tokens, restore := captureChangeEmailLinks()
+	defer restore()
+
+	sub, err := Subscribe(Sub{Name: "Mover", Email: "mover@example.com"})
+	assert.NoError(t, err)
+	defer Unsubscribe(sub.ID)
+	token, err := signLinkToken("preferences", sub.ID)
+	assert.NoError(t, err)
+	_, err = UpdatePreferences(token, Preferences{Name: "Mover", Email: "mover.new@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*tokens))
+
+	// the address is taken before the change is confirmed, the token stays valid
+	other, err := Subscribe(Sub{Name: "Other", Email: "mover.new@example.com"})
+	assert.NoError(t, err)
+	_, err = ConfirmEmailChange((*tokens)[0])
+	assert.Equal(t, ErrEmailExists, err)
+	saved, err := FindSubByEmail("mover@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, sub.ID, saved.ID)
+
+	assert.NoError(t, Unsubscribe(other.ID))
+	changed, err := ConfirmEmailChange((*tokens)[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "mover.new@example.com", changed.Email)
+}
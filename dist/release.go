@@ -2,11 +2,15 @@ package dist
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
-	"log"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"sort"
 	"time"
@@ -21,21 +25,42 @@ type Release struct {
 	ID          string
 	Version     string
 	Description string
-	Date        util.JSONTime
+	// Descriptions are translations of Description by locale
+	Descriptions  map[string]string `json:",omitempty"`
+	Date          util.JSONTime
+	Size          int64
+	Type          string
+	ScanVerdict   string
+	ScanSignature string
+	// SHA256 is the hex encoded checksum of the release file
+	SHA256 string
+	// Signature is the Ed25519 signature of the raw SHA256 digest
+	Signature      []byte
+	SignatureKeyID string
+	// Compression is the content coding of the stored file, empty if stored as is
+	Compression string
 }
 
 // FileName generate file name of this release
 func (r Release) FileName() string {
+	configMu.RLock()
+	t := nameTemplate
+	configMu.RUnlock()
+	name, _ := r.fileName(t)
+	return name
+}
+
+func (r Release) fileName(t *template.Template) (string, error) {
 	type ctx struct {
 		Version string
 		Date    string
 	}
 	buf := bytes.NewBuffer(nil)
-	nameTemplate.Execute(buf, ctx{
+	err := t.Execute(buf, ctx{
 		Version: r.Version,
 		Date:    time.Time(r.Date).Format("20060102150405"),
 	})
-	return string(buf.Bytes())
+	return string(buf.Bytes()), err
 }
 
 // ReleasesByDateDesc is slice of Release sorted by date desc
@@ -47,18 +72,11 @@ func (rl ReleasesByDateDesc) Less(i, j int) bool {
 	return !time.Time(rl[i].Date).Before(time.Time(rl[j].Date))
 }
 
-// DataDir is the path to store files
-const DataDir = "./data/release"
+// dataDir is the path to store files
+var dataDir = DefaultDataDir
 
 func dataFilePath(name string) string {
-	return DataDir + "/" + name
-}
-
-func init() {
-	err := os.MkdirAll(DataDir, 0400)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return dataDir + "/" + name
 }
 
 // List list all releases
@@ -88,41 +106,107 @@ func List() (ReleasesByDateDesc, error) {
 
 // Publish uploads & publishes a new version
 func Publish(release Release, r io.Reader) (rv *Release, err error) {
+	if err = validateRelease(release); err != nil {
+		return
+	}
+	descriptions, err := normalizeDescriptions(release.Descriptions)
+	if err != nil {
+		return
+	}
+
 	id := uuid.NewV4().String()
 	saved := Release{
-		ID:          id,
-		Version:     release.Version,
-		Description: release.Description,
-		Date:        util.JSONTime(time.Now()),
+		ID:           id,
+		Version:      release.Version,
+		Description:  release.Description,
+		Descriptions: descriptions,
+		Date:         util.JSONTime(time.Now()),
 	}
 
 	fpath := dataFilePath(id + ".dat")
-	dataf, err := os.OpenFile(fpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 400)
+	dataf, err := ioutil.TempFile(stagingDir(), id)
 	if err != nil {
 		return
 	}
 	defer func() {
 		dataf.Close()
+		os.RemoveAll(dataf.Name())
 		if err != nil {
 			os.RemoveAll(fpath)
 		}
 	}()
 
-	_, err = io.Copy(dataf, r)
+	if uploadConfig.MaxSize > 0 {
+		r = io.LimitReader(r, uploadConfig.MaxSize+1)
+	}
+	h := sha256.New()
+	saved.Size, err = io.Copy(io.MultiWriter(dataf, h), r)
+	if err != nil {
+		return
+	}
+	if uploadConfig.MaxSize > 0 && saved.Size > uploadConfig.MaxSize {
+		err = validationError(http.StatusRequestEntityTooLarge, "file_too_large",
+			"uploaded file exceeds %d bytes", uploadConfig.MaxSize)
+		return
+	}
+
+	saved.Type, err = validateFile(saved, dataf, saved.Size)
 	if err != nil {
 		return
 	}
 
-	j, err := json.Marshal(saved)
+	if scanner != nil {
+		var result *ScanResult
+		result, err = scanner.Scan(dataf.Name())
+		if err != nil {
+			return
+		}
+		saved.ScanVerdict = ScanClean
+		if result.Infected {
+			saved.ScanVerdict = ScanInfected
+			saved.ScanSignature = result.Signature
+			if err = quarantine(saved, dataf); err != nil {
+				return
+			}
+			err = infectedError(saved)
+			return
+		}
+	}
+
+	digest := h.Sum(nil)
+	saved.SHA256 = hex.EncodeToString(digest)
+	saved.SignatureKeyID, saved.Signature, err = sign(digest)
 	if err != nil {
 		return
 	}
 
+	stored := dataf
+	if compression != "" && saved.Type != FileTypeZip {
+		var compressed *os.File
+		compressed, err = compressStaged(dataf, saved.Size, compression)
+		if err != nil {
+			return
+		}
+		if compressed != nil {
+			defer func() {
+				compressed.Close()
+				os.RemoveAll(compressed.Name())
+			}()
+			stored = compressed
+			saved.Compression = compression
+		}
+	}
+
+	if err = storeFile(stored, fpath); err != nil {
+		return
+	}
+
 	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("release"))
-		err = b.Put([]byte(id), j)
-		return nil
+		return putNewRelease(tx, saved)
 	})
+	if err != nil {
+		return
+	}
 
 	rv = &saved
 	return
@@ -161,17 +245,15 @@ func Unpublish(id string) error {
 	}
 
 	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("release_date")).Delete(dateIndexKey(r.Date.Time(), id)); err != nil {
+			return err
+		}
 		return tx.Bucket([]byte("release")).Delete([]byte(id))
 	})
 }
 
-func getDataReader(name string) (io.ReadCloser, error) {
-	p := dataFilePath(name)
-	f, err := os.Open(p)
-	if err != nil {
-		return nil, err
-	}
-	return f, nil
+func getDataReader(name string) (dataReader, error) {
+	return openDataFile(dataFilePath(name))
 }
 
 // Get returns release metadata by id
@@ -184,6 +266,15 @@ var ErrReleaseDataNotFound = errors.New("release data file was not found")
 
 // Stream streams release file data to a writer
 func Stream(id string, w io.Writer) error {
+	return stream(id, w, false)
+}
+
+// StreamRaw streams release file data as stored, encoded with the release's Compression
+func StreamRaw(id string, w io.Writer) error {
+	return stream(id, w, true)
+}
+
+func stream(id string, w io.Writer, raw bool) error {
 	r, err := getRelease(id)
 	if err != nil {
 		return err
@@ -199,7 +290,17 @@ func Stream(id string, w io.Writer) error {
 	}
 	defer f.Close()
 
-	_, err = io.Copy(w, f)
+	var src io.Reader = f
+	if !raw && r.Compression != "" {
+		dr, err := newDecompressReader(f, r.Compression)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+		src = dr
+	}
+
+	_, err = io.Copy(w, src)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,91 @@
+package dist
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Admin roles
+const (
+	RoleViewer            = "viewer"
+	RolePublisher         = "publisher"
+	RoleSubscriberManager = "subscriber-manager"
+	RoleAdmin             = "admin"
+)
+
+// Permissions needed by admin api routes
+const (
+	PermReleaseRead  = "release:read"
+	PermReleaseWrite = "release:write"
+	PermSubRead      = "sub:read"
+	PermSubWrite     = "sub:write"
+	PermAdmin        = "admin"
+)
+
+var rolePermissions = map[string][]string{
+	RoleViewer:            {PermReleaseRead, PermSubRead},
+	RolePublisher:         {PermReleaseRead, PermReleaseWrite},
+	RoleSubscriberManager: {PermReleaseRead, PermSubRead, PermSubWrite},
+	RoleAdmin:             {PermReleaseRead, PermReleaseWrite, PermSubRead, PermSubWrite, PermAdmin},
+}
+
+// Permissions returns all permission names
+func Permissions() []string {
+	return append([]string(nil), rolePermissions[RoleAdmin]...)
+}
+
+func isPermission(p string) bool {
+	return hasRole(rolePermissions[RoleAdmin], p)
+}
+
+// Roles returns all role names
+func Roles() []string {
+	rv := make([]string, 0, len(rolePermissions))
+	for role := range rolePermissions {
+		rv = append(rv, role)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// RolesAllow reports whether any of roles grants permission
+func RolesAllow(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func normalizeRoles(roles []string) ([]string, error) {
+	rv := []string{}
+	seen := map[string]bool{}
+	for _, role := range roles {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if _, ok := rolePermissions[role]; !ok {
+			return nil, validationError(http.StatusBadRequest, "role", "unknown role %q, must be one of %s", role, strings.Join(Roles(), ", "))
+		}
+		if !seen[role] {
+			seen[role] = true
+			rv = append(rv, role)
+		}
+	}
+	if len(rv) == 0 {
+		return nil, validationError(http.StatusBadRequest, "role", "at least one role is required")
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
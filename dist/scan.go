@@ -0,0 +1,263 @@
+package dist
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Scan verdicts stored on a release
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+)
+
+// ScanResult is the outcome of a malware scan
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner checks a release file for malware before it is published
+type Scanner interface {
+	Scan(path string) (*ScanResult, error)
+}
+
+// ScanConfig selects the scanner used by Publish
+type ScanConfig struct {
+	// Clamd is the clamd address, e.g. "tcp://127.0.0.1:3310" or "unix:///var/run/clamav/clamd.ctl"
+	Clamd string
+	// Command is an external scanner invoked with the file path appended
+	Command []string
+	// Timeout limits a single scan, e.g. "2m", defaults to 5m
+	Timeout string
+}
+
+const defaultScanTimeout = 5 * time.Minute
+
+var scanner Scanner
+
+// SetScanner replaces the scanner used by Publish, nil disables scanning
+func SetScanner(s Scanner) {
+	scanner = s
+}
+
+func newScanner(c ScanConfig) (Scanner, error) {
+	timeout := defaultScanTimeout
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid scan timeout: %s", c.Timeout)
+		}
+		timeout = d
+	}
+	switch {
+	case c.Clamd != "":
+		parts := strings.SplitN(c.Clamd, "://", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid clamd address: %s", c.Clamd)
+		}
+		return &ClamdScanner{Network: parts[0], Address: parts[1], Timeout: timeout}, nil
+	case len(c.Command) > 0:
+		return &ExecScanner{Command: c.Command, Timeout: timeout}, nil
+	}
+	return nil, nil
+}
+
+// ClamdScanner streams files to a clamd daemon using the INSTREAM command
+type ClamdScanner struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+const clamdChunkSize = 64 * 1024
+
+// Scan implements Scanner
+func (s *ClamdScanner) Scan(path string) (*ScanResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultScanTimeout
+	}
+	conn, err := net.DialTimeout(s.Network, s.Address, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: %s", err.Error())
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("clamd: %s", err.Error())
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, fmt.Errorf("clamd: %s", err.Error())
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return nil, fmt.Errorf("clamd: %s", err.Error())
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return nil, fmt.Errorf("clamd: %s", err.Error())
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("clamd: %s", err.Error())
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply parses replies like "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (*ScanResult, error) {
+	reply = strings.TrimRight(reply, "\x00\n")
+	i := strings.Index(reply, ": ")
+	if i < 0 {
+		return nil, fmt.Errorf("clamd: unexpected reply: %s", reply)
+	}
+	status := reply[i+2:]
+	switch {
+	case status == "OK":
+		return &ScanResult{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return &ScanResult{
+			Infected:  true,
+			Signature: strings.TrimSuffix(status, " FOUND"),
+		}, nil
+	}
+	return nil, fmt.Errorf("clamd: %s", status)
+}
+
+// ExecScanner runs an external command such as clamscan.
+// Exit code 0 means clean, 1 means infected, anything else is an error.
+type ExecScanner struct {
+	Command []string
+	// Timeout kills the command after this long, defaults to 5m
+	Timeout time.Duration
+}
+
+// Scan implements Scanner
+func (s *ExecScanner) Scan(path string) (*ScanResult, error) {
+	if len(s.Command) == 0 {
+		return nil, errors.New("scan: no command")
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultScanTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	args := append(append([]string{}, s.Command[1:]...), path)
+	out, err := exec.CommandContext(ctx, s.Command[0], args...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("scan: timed out after %s", timeout)
+	}
+	if err == nil {
+		return &ScanResult{}, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 1 {
+		return nil, fmt.Errorf("scan: %s: %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+
+	rv := &ScanResult{Infected: true}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, " FOUND") {
+			if r, err := parseClamdReply(line); err == nil {
+				rv.Signature = r.Signature
+			}
+			break
+		}
+	}
+	return rv, nil
+}
+
+// quarantineDir is the path to store infected uploads
+func quarantineDir() string {
+	return dataDir + "/quarantine"
+}
+
+func infectedError(r Release) *ValidationError {
+	return validationError(http.StatusUnprocessableEntity, "infected",
+		"upload was quarantined, malware detected: %s", r.ScanSignature)
+}
+
+// quarantine stores an infected upload outside of the release directory
+func quarantine(r Release, staged *os.File) error {
+	if err := storeFile(staged, quarantineDir()+"/"+r.ID+".dat"); err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("quarantine")).Put([]byte(r.ID), j)
+	})
+}
+
+// ListQuarantined returns infected uploads
+func ListQuarantined() (ReleasesByDateDesc, error) {
+	list := ReleasesByDateDesc{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("quarantine")).ForEach(func(k, v []byte) error {
+			r := Release{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("unmarshal quarantined release %s: %s", string(k), err.Error())
+			}
+			list = append(list, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Stable(list)
+
+	return list, nil
+}
+
+// DeleteQuarantined removes an infected upload for good
+func DeleteQuarantined(id string) error {
+	if err := os.RemoveAll(quarantineDir() + "/" + id + ".dat"); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("quarantine")).Delete([]byte(id))
+	})
+}
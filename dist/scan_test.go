@@ -0,0 +1,169 @@
+package dist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClamd accepts INSTREAM sessions and flags streams containing "EICAR"
+func fakeClamd(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil || cmd != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				data := bytes.NewBuffer(nil)
+				for {
+					var size uint32
+					if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(data, r, int64(size)); err != nil {
+						return
+					}
+				}
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+	return l
+}
+
+func testScanFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "scan")
+	assert.NoError(t, err)
+	_, err = f.WriteString(content)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	return f.Name()
+}
+
+func TestClamdScanner(t *testing.T) {
+	l := fakeClamd(t)
+	defer l.Close()
+	s := &ClamdScanner{Network: "tcp", Address: l.Addr().String()}
+
+	clean := testScanFile(t, "RELEASE")
+	defer os.Remove(clean)
+	result, err := s.Scan(clean)
+	assert.NoError(t, err)
+	assert.False(t, result.Infected)
+
+	infected := testScanFile(t, "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*")
+	defer os.Remove(infected)
+	result, err = s.Scan(infected)
+	assert.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", result.Signature)
+}
+
+func TestExecScanner(t *testing.T) {
+	s := &ExecScanner{Command: []string{"sh", "-c", `grep -q EICAR "$0" && echo "$0: Eicar-Test-Signature FOUND" && exit 1; exit 0`}}
+
+	clean := testScanFile(t, "RELEASE")
+	defer os.Remove(clean)
+	result, err := s.Scan(clean)
+	assert.NoError(t, err)
+	assert.False(t, result.Infected)
+
+	infected := testScanFile(t, "EICAR")
+	defer os.Remove(infected)
+	result, err = s.Scan(infected)
+	assert.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", result.Signature)
+
+	_, err = (&ExecScanner{Command: []string{"sh", "-c", "exit 2"}}).Scan(clean)
+	assert.Error(t, err)
+
+	start := time.Now()
+	_, err = (&ExecScanner{Command: []string{"sh", "-c", "exec sleep 10"}, Timeout: 100 * time.Millisecond}).Scan(clean)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestNewScanner(t *testing.T) {
+	s, err := newScanner(ScanConfig{Clamd: "tcp://127.0.0.1:3310", Timeout: "30s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, s.(*ClamdScanner).Timeout)
+	s, err = newScanner(ScanConfig{Command: []string{"clamscan"}})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultScanTimeout, s.(*ExecScanner).Timeout)
+	_, err = newScanner(ScanConfig{Command: []string{"clamscan"}, Timeout: "soon"})
+	assert.Error(t, err)
+}
+
+func TestPublishScan(t *testing.T) {
+	l := fakeClamd(t)
+	defer l.Close()
+	defer SetScanner(nil)
+	SetScanner(&ClamdScanner{Network: "tcp", Address: l.Addr().String()})
+
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("RELEASE"))
+	assert.NoError(t, err)
+	assert.Equal(t, ScanClean, r.ScanVerdict)
+	assert.NoError(t, Unpublish(r.ID))
+
+	r, err = Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("EICAR"))
+	assert.Nil(t, r)
+	verr, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, "infected", verr.Code)
+
+	list, err := ListQuarantined()
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, ScanInfected, list[0].ScanVerdict)
+	assert.Equal(t, "Eicar-Test-Signature", list[0].ScanSignature)
+
+	_, err = os.Stat(dataFilePath(list[0].ID + ".dat"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(quarantineDir() + "/" + list[0].ID + ".dat")
+	assert.NoError(t, err)
+
+	released, err := Get(list[0].ID)
+	assert.NoError(t, err)
+	assert.Nil(t, released)
+
+	assert.NoError(t, DeleteQuarantined(list[0].ID))
+	list, err = ListQuarantined()
+	assert.NoError(t, err)
+	assert.Len(t, list, 0)
+}
+
+func TestQuarantineDirMode(t *testing.T) {
+	// files are moved in by the service user, so the directories must be writable and searchable
+	for _, dir := range []string{dataDir, quarantineDir()} {
+		info, err := os.Stat(dir)
+		if assert.NoError(t, err, dir) {
+			assert.Equal(t, os.FileMode(0700), info.Mode().Perm()&0700, dir)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package dist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+)
+
+// Segment is a saved subscriber filter used to target notifications.
+// Empty criteria match every subscriber.
+type Segment struct {
+	ID   string
+	Name string
+	// Tags is a tag expression, e.g. "organizer and (zh or ko) and not staff"
+	Tags string
+	// SignedUpAfter and SignedUpBefore bound the subscription date
+	SignedUpAfter  *time.Time `json:",omitempty"`
+	SignedUpBefore *time.Time `json:",omitempty"`
+	// MinDownloads and MaxDownloads bound the number of downloads over all releases
+	MinDownloads int
+	MaxDownloads *int `json:",omitempty"`
+	// Downloaded requires a download of the release with this id
+	Downloaded string `json:",omitempty"`
+	Date       time.Time
+}
+
+// SegmentsByName is a slice of Segment sorted by name
+type SegmentsByName []Segment
+
+func (l SegmentsByName) Len() int           { return len(l) }
+func (l SegmentsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l SegmentsByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+// ErrSegmentNotFound is returned when a segment is not found by id
+var ErrSegmentNotFound = errors.New("segment was not found")
+
+func validateSegment(s *Segment) (tagExpr, error) {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return nil, validationError(http.StatusBadRequest, "segment", "Name is required")
+	}
+	if s.SignedUpAfter != nil && s.SignedUpBefore != nil && !s.SignedUpAfter.Before(*s.SignedUpBefore) {
+		return nil, validationError(http.StatusBadRequest, "segment", "SignedUpAfter must be before SignedUpBefore")
+	}
+	if s.MinDownloads < 0 || (s.MaxDownloads != nil && *s.MaxDownloads < s.MinDownloads) {
+		return nil, validationError(http.StatusBadRequest, "segment", "download range is invalid")
+	}
+	s.Tags = strings.TrimSpace(s.Tags)
+	if s.Tags == "" {
+		return nil, nil
+	}
+	e, err := parseTagExpr(s.Tags)
+	if err != nil {
+		return nil, validationError(http.StatusBadRequest, "segment", "%s", err.Error())
+	}
+	return e, nil
+}
+
+// ListSegments returns all saved segments
+func ListSegments() (SegmentsByName, error) {
+	list := SegmentsByName{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("segment")).ForEach(func(k, v []byte) error {
+			s := Segment{}
+			if err := json.Unmarshal(v, &s); err != nil {
+				return fmt.Errorf("unmarshal segment %s: %s", string(k), err.Error())
+			}
+			list = append(list, s)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(list)
+	return list, nil
+}
+
+// GetSegment returns a segment by id
+func GetSegment(id string) (rv *Segment, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte("segment")).Get([]byte(id))
+		if v == nil {
+			return ErrSegmentNotFound
+		}
+		rv = &Segment{}
+		return json.Unmarshal(v, rv)
+	})
+	return
+}
+
+// SaveSegment creates a segment, or replaces it if it has an existing id
+func SaveSegment(s Segment) (rv *Segment, err error) {
+	if _, err = validateSegment(&s); err != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("segment"))
+		if s.ID == "" {
+			s.ID = uuid.NewV4().String()
+			s.Date = time.Now()
+		} else {
+			v := b.Get([]byte(s.ID))
+			if v == nil {
+				return ErrSegmentNotFound
+			}
+			existing := Segment{}
+			if err := json.Unmarshal(v, &existing); err != nil {
+				return err
+			}
+			s.Date = existing.Date
+		}
+		j, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(s.ID), j)
+	})
+	if err != nil {
+		return
+	}
+	return &s, nil
+}
+
+// DeleteSegment removes a segment
+func DeleteSegment(id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("segment")).Delete([]byte(id))
+	})
+}
+
+// SegmentSubs returns the subscribers matching a segment
+func SegmentSubs(s Segment) (SubsByDate, error) {
+	expr, err := validateSegment(&s)
+	if err != nil {
+		return nil, err
+	}
+	list := SubsByDate{}
+	err = db.View(func(tx *bolt.Tx) error {
+		downloads := tx.Bucket([]byte("sub_download"))
+		return tx.Bucket([]byte("sub")).ForEach(func(k, v []byte) error {
+			sub := Sub{}
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return fmt.Errorf("unmarshal subscriber %s: %s", string(k), err.Error())
+			}
+			if s.SignedUpAfter != nil && sub.Date.Before(*s.SignedUpAfter) {
+				return nil
+			}
+			if s.SignedUpBefore != nil && !sub.Date.Before(*s.SignedUpBefore) {
+				return nil
+			}
+			if expr != nil {
+				tags := map[string]bool{}
+				for _, t := range sub.Tags {
+					tags[t] = true
+				}
+				if !expr.match(tags) {
+					return nil
+				}
+			}
+
+			total := 0
+			downloaded := false
+			if b := downloads.Bucket(k); b != nil {
+				b.ForEach(func(k, v []byte) error {
+					n, _ := strconv.ParseUint(string(v), 16, 64)
+					total += int(n)
+					downloaded = downloaded || string(k) == s.Downloaded
+					return nil
+				})
+			}
+			if total < s.MinDownloads || (s.MaxDownloads != nil && total > *s.MaxDownloads) {
+				return nil
+			}
+			if s.Downloaded != "" && !downloaded {
+				return nil
+			}
+
+			list = append(list, sub)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(list)
+	return list, nil
+}
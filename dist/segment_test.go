@@ -0,0 +1,156 @@
+package dist
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func segmentSubIDs(t *testing.T, s Segment) []string {
+	list, err := SegmentSubs(s)
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, sub := range list {
+		ids = append(ids, sub.ID)
+	}
+	return ids
+}
+
+func countReleaseLinks(t *testing.T, releaseID string) (n int) {
+	assert.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("link")).ForEach(func(k, v []byte) error {
+			link := Link{}
+			if err := json.Unmarshal(v, &link); err != nil {
+				return err
+			}
+			if link.ReleaseID == releaseID {
+				n++
+			}
+			return nil
+		})
+	}))
+	return
+}
+
+func TestSegmentSubs(t *testing.T) {
+	organizer, err := Subscribe(Sub{Name: "Organizer", Email: "segment.organizer@example.com", Tags: []string{"Organizer", "zh"}})
+	assert.NoError(t, err)
+	defer Unsubscribe(organizer.ID)
+	assert.Equal(t, []string{"organizer", "zh"}, organizer.Tags)
+	player, err := Subscribe(Sub{Name: "Player", Email: "segment.player@example.com", Tags: []string{"zh"}})
+	assert.NoError(t, err)
+	defer Unsubscribe(player.ID)
+
+	_, err = Subscribe(Sub{Name: "Bad", Email: "segment.bad@example.com", Tags: []string{"not"}})
+	assert.Equal(t, "tag", err.(*ValidationError).Code)
+
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("segment"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+	links, err := createLinks([]string{player.ID}, r.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, StreamLink(links[0].ID, &bytes.Buffer{}))
+
+	assert.Equal(t, []string{organizer.ID}, segmentSubIDs(t, Segment{Name: "S", Tags: "organizer and zh"}))
+	assert.Equal(t, []string{player.ID}, segmentSubIDs(t, Segment{Name: "S", Tags: "zh and not organizer"}))
+	assert.Equal(t, []string{player.ID}, segmentSubIDs(t, Segment{Name: "S", Tags: "zh", Downloaded: r.ID}))
+	assert.Equal(t, []string{player.ID}, segmentSubIDs(t, Segment{Name: "S", Tags: "zh", MinDownloads: 1}))
+	zero := 0
+	assert.Equal(t, []string{organizer.ID}, segmentSubIDs(t, Segment{Name: "S", Tags: "zh", MaxDownloads: &zero}))
+
+	after := player.Date
+	assert.Equal(t, []string{player.ID}, segmentSubIDs(t, Segment{Name: "S", Tags: "zh", SignedUpAfter: &after}))
+	before := player.Date
+	assert.Equal(t, []string{organizer.ID}, segmentSubIDs(t, Segment{Name: "S", Tags: "zh", SignedUpBefore: &before}))
+
+	// moving the tags of a subscriber moves it between segments, nil tags are left alone
+	player.Tags = nil
+	player.Name = "Renamed"
+	updated, err := UpdateSubscriber(*player)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zh"}, updated.Tags)
+	player.Tags = []string{}
+	_, err = UpdateSubscriber(*player)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, segmentSubIDs(t, Segment{Name: "S", Tags: "zh and not organizer"}))
+}
+
+func TestSaveSegment(t *testing.T) {
+	_, err := SaveSegment(Segment{Name: " "})
+	assert.Equal(t, "segment", err.(*ValidationError).Code)
+	_, err = SaveSegment(Segment{Name: "Bad", Tags: "zh and"})
+	assert.Equal(t, "segment", err.(*ValidationError).Code)
+	now := time.Now()
+	_, err = SaveSegment(Segment{Name: "Bad", SignedUpAfter: &now, SignedUpBefore: &now})
+	assert.Equal(t, "segment", err.(*ValidationError).Code)
+	_, err = SaveSegment(Segment{ID: "missing", Name: "Missing"})
+	assert.Equal(t, ErrSegmentNotFound, err)
+
+	s, err := SaveSegment(Segment{Name: "Chinese", Tags: "zh"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, s.ID)
+	s.Tags = "zh or ko"
+	_, err = SaveSegment(*s)
+	assert.NoError(t, err)
+
+	saved, err := GetSegment(s.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "zh or ko", saved.Tags)
+	assert.False(t, saved.Date.IsZero())
+
+	list, err := ListSegments()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	assert.NoError(t, DeleteSegment(s.ID))
+	_, err = GetSegment(s.ID)
+	assert.Equal(t, ErrSegmentNotFound, err)
+}
+
+// useTestNotifyConfig sets the notification config Configure would and returns a func restoring it
+func useTestNotifyConfig() func() {
+	link, subject, content := makeLink, notifyEmailSubjectTemplate, notifyEmailContentTemplate
+	makeLink = func(id string) string { return "/download/" + id }
+	notifyEmailSubjectTemplate = template.Must(template.New("subject").Parse("{{.Release.Version}}"))
+	notifyEmailContentTemplate = template.Must(template.New("content").Parse("%recipient.Link%"))
+	return func() {
+		makeLink, notifyEmailSubjectTemplate, notifyEmailContentTemplate = link, subject, content
+	}
+}
+
+func TestNotifySegment(t *testing.T) {
+	defer useTestNotifyConfig()()
+
+	tagged, err := Subscribe(Sub{Name: "Tagged", Email: "notify.tagged@example.com", Tags: []string{"beta"}})
+	assert.NoError(t, err)
+	defer Unsubscribe(tagged.ID)
+	other, err := Subscribe(Sub{Name: "Other", Email: "notify.other@example.com"})
+	assert.NoError(t, err)
+	defer Unsubscribe(other.ID)
+
+	s, err := SaveSegment(Segment{Name: "Beta", Tags: "beta"})
+	assert.NoError(t, err)
+	defer DeleteSegment(s.ID)
+
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("notify"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+
+	sent := testMg.Sent()
+	assert.NoError(t, NotifySegment(*r, s.ID))
+	assert.Equal(t, sent+1, testMg.Sent())
+	assert.Equal(t, 1, countReleaseLinks(t, r.ID))
+
+	assert.Equal(t, ErrSegmentNotFound, NotifySegment(*r, "missing"))
+
+	empty, err := SaveSegment(Segment{Name: "Empty", Tags: "nobody"})
+	assert.NoError(t, err)
+	defer DeleteSegment(empty.ID)
+	assert.NoError(t, NotifySegment(*r, empty.ID))
+	assert.Equal(t, sent+1, testMg.Sent())
+}
@@ -0,0 +1,309 @@
+package dist
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	mailgun "gopkg.in/mailgun/mailgun-go.v1"
+)
+
+// ConfigRedacted replaces secrets when a config is printed
+const ConfigRedacted = "********"
+
+// configSetting is a Config field that can be changed while the service runs
+type configSetting struct {
+	// field returns a pointer to the setting in a Config
+	field func(c *Config) interface{}
+}
+
+// configSettings are the settings stored in the config bucket, by their path in Config.
+// Secrets are not among them, they only come from the config file, environment or flags
+// and are rotated with ReloadConfig, so they are never written to the database.
+var configSettings = map[string]configSetting{
+	"FilenameTemplate":                         {field: func(c *Config) interface{} { return &c.FilenameTemplate }},
+	"NotifyEmailSubjectTemplate":               {field: func(c *Config) interface{} { return &c.NotifyEmailSubjectTemplate }},
+	"NotifyEmailContentTemplate":               {field: func(c *Config) interface{} { return &c.NotifyEmailContentTemplate }},
+	"NotifyTemplates":                          {field: func(c *Config) interface{} { return &c.NotifyTemplates }},
+	"Subscription.ConfirmTTL":                  {field: func(c *Config) interface{} { return &c.Subscription.ConfirmTTL }},
+	"Subscription.ConfirmEmailSubjectTemplate": {field: func(c *Config) interface{} { return &c.Subscription.ConfirmEmailSubjectTemplate }},
+	"Subscription.ConfirmEmailContentTemplate": {field: func(c *Config) interface{} { return &c.Subscription.ConfirmEmailContentTemplate }},
+	"Subscription.ChangeEmailSubjectTemplate":  {field: func(c *Config) interface{} { return &c.Subscription.ChangeEmailSubjectTemplate }},
+	"Subscription.ChangeEmailContentTemplate":  {field: func(c *Config) interface{} { return &c.Subscription.ChangeEmailContentTemplate }},
+	"Mailgun.Domain":                           {field: func(c *Config) interface{} { return &c.Mailgun.Domain }},
+}
+
+// removedSettings were stored by earlier versions and are deleted from the config bucket
+var removedSettings = []string{"Mailgun.APIKey", "Mailgun.WebhookSigningKey"}
+
+// ConfigSettings returns the names of the settings that can be changed while the service runs
+func ConfigSettings() []string {
+	rv := []string{}
+	for k := range configSettings {
+		rv = append(rv, k)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// configMu guards the settings below while PatchConfig or ReloadConfig switches them
+var configMu sync.RWMutex
+
+// configUpdateMu serializes PatchConfig and ReloadConfig
+var configUpdateMu sync.Mutex
+
+var mailgunDomain, mailgunAPIKey string
+
+// settings are parsed settings, ready to be applied
+type settings struct {
+	name, notifySubject, notifyContent                           *template.Template
+	notifyTemplates                                              map[string]notifyTemplateSet
+	confirmSubject, confirmContent, changeSubject, changeContent *template.Template
+	confirmTTL                                                   time.Duration
+	mailgunDomain, mailgunAPIKey, webhookSigningKey              string
+}
+
+func parseSetting(key, name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, validationError(http.StatusBadRequest, "config", "%s: %s", key, err.Error())
+	}
+	return t, nil
+}
+
+// parseSettings checks the settings of a Config that can be changed while the service runs
+func parseSettings(c Config) (s *settings, err error) {
+	s = &settings{
+		confirmTTL:        defaultConfirmTTL,
+		mailgunDomain:     c.Mailgun.Domain,
+		mailgunAPIKey:     c.Mailgun.APIKey,
+		webhookSigningKey: c.Mailgun.WebhookSigningKey,
+	}
+	if s.webhookSigningKey == "" {
+		s.webhookSigningKey = c.Mailgun.APIKey
+	}
+	if c.Subscription.ConfirmTTL != "" {
+		ttl, err := time.ParseDuration(c.Subscription.ConfirmTTL)
+		if err != nil || ttl <= 0 {
+			return nil, validationError(http.StatusBadRequest, "config", "Subscription.ConfirmTTL: must be a positive duration such as \"48h\"")
+		}
+		s.confirmTTL = ttl
+	}
+	if s.notifyTemplates, err = parseNotifyTemplates(c.NotifyTemplates); err != nil {
+		return nil, validationError(http.StatusBadRequest, "config", "%s", err.Error())
+	}
+	for _, t := range []struct {
+		t                    **template.Template
+		key, name, text, def string
+	}{
+		{&s.name, "FilenameTemplate", "nameTemplate", c.FilenameTemplate, ""},
+		{&s.notifySubject, "NotifyEmailSubjectTemplate", "notifyEmailSubjectTemplate", c.NotifyEmailSubjectTemplate, ""},
+		{&s.notifyContent, "NotifyEmailContentTemplate", "notifyEmailContentTemplate", c.NotifyEmailContentTemplate, ""},
+		{&s.confirmSubject, "Subscription.ConfirmEmailSubjectTemplate", "confirmEmailSubjectTemplate", c.Subscription.ConfirmEmailSubjectTemplate, defaultConfirmEmailSubjectTemplate},
+		{&s.confirmContent, "Subscription.ConfirmEmailContentTemplate", "confirmEmailContentTemplate", c.Subscription.ConfirmEmailContentTemplate, defaultConfirmEmailContentTemplate},
+		{&s.changeSubject, "Subscription.ChangeEmailSubjectTemplate", "changeEmailSubjectTemplate", c.Subscription.ChangeEmailSubjectTemplate, defaultChangeEmailSubjectTemplate},
+		{&s.changeContent, "Subscription.ChangeEmailContentTemplate", "changeEmailContentTemplate", c.Subscription.ChangeEmailContentTemplate, defaultChangeEmailContentTemplate},
+	} {
+		if *t.t, err = parseSetting(t.key, t.name, t.text, t.def); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// apply switches to the settings, mailgun is only reconnected when its domain or key changed
+func (s *settings) apply() {
+	configMu.Lock()
+	defer configMu.Unlock()
+	nameTemplate = s.name
+	notifyEmailSubjectTemplate = s.notifySubject
+	notifyEmailContentTemplate = s.notifyContent
+	notifyTemplates = s.notifyTemplates
+	confirmEmailSubjectTemplate = s.confirmSubject
+	confirmEmailContentTemplate = s.confirmContent
+	changeEmailSubjectTemplate = s.changeSubject
+	changeEmailContentTemplate = s.changeContent
+	confirmTTL = s.confirmTTL
+	webhookSigningKey = s.webhookSigningKey
+	if mg == nil || s.mailgunDomain != mailgunDomain || s.mailgunAPIKey != mailgunAPIKey {
+		mg = mailgun.NewMailgun(s.mailgunDomain, s.mailgunAPIKey, "")
+		mailgunDomain, mailgunAPIKey = s.mailgunDomain, s.mailgunAPIKey
+	}
+}
+
+// mailer returns the current mailgun client
+func mailer() mailgun.Mailgun {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return mg
+}
+
+// getFileConfig returns the Config given to Configure or ReloadConfig
+func getFileConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return fileConfig
+}
+
+// overrideConfig returns c with stored settings applied, unknown keys are ignored
+func overrideConfig(c Config, values ConfigMap) (Config, error) {
+	for k, v := range values {
+		s, ok := configSettings[k]
+		if !ok {
+			continue
+		}
+		j, err := json.Marshal(v)
+		if err != nil {
+			return c, err
+		}
+		p := s.field(&c)
+		// replace rather than merge into maps shared with fileConfig
+		reflect.ValueOf(p).Elem().Set(reflect.Zero(reflect.TypeOf(p).Elem()))
+		dec := json.NewDecoder(bytes.NewReader(j))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(p); err != nil {
+			return c, validationError(http.StatusBadRequest, "config", "%s: %s", k, err.Error())
+		}
+	}
+	return c, nil
+}
+
+// settingValues returns the settings of c
+func settingValues(c Config) ConfigMap {
+	rv := ConfigMap{}
+	for k, s := range configSettings {
+		rv[k] = reflect.ValueOf(s.field(&c)).Elem().Interface()
+	}
+	return rv
+}
+
+// GetRuntimeConfig returns the current settings that can be changed while the service runs
+func GetRuntimeConfig() (ConfigMap, error) {
+	stored, err := GetAllConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := overrideConfig(getFileConfig(), stored)
+	if err != nil {
+		return nil, err
+	}
+	return settingValues(c), nil
+}
+
+// PatchConfig validates and stores settings, and applies them without a restart.
+// A null value removes the stored setting so the one from the config file applies again.
+// Changed templates are added to their history by author.
+func PatchConfig(values ConfigMap, author string) (ConfigMap, error) {
+	configUpdateMu.Lock()
+	defer configUpdateMu.Unlock()
+
+	stored, err := GetAllConfig()
+	if err != nil {
+		return nil, err
+	}
+	prev, err := overrideConfig(getFileConfig(), stored)
+	if err != nil {
+		return nil, err
+	}
+	changes := ConfigMap{}
+	for k, v := range values {
+		if _, ok := configSettings[k]; !ok {
+			return nil, validationError(http.StatusBadRequest, "config", "unknown setting %q", k)
+		}
+		changes[k] = v
+		if v == nil {
+			delete(stored, k)
+		} else {
+			stored[k] = v
+		}
+	}
+	c, err := overrideConfig(getFileConfig(), stored)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseSettings(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := UpdateConfig(changes); err != nil {
+		return nil, err
+	}
+	s.apply()
+	if err := recordTemplateVersions(prev, c, author); err != nil {
+		return nil, err
+	}
+	return settingValues(c), nil
+}
+
+// ReloadConfig switches to the settings of c that can be changed while the service runs,
+// e.g. after secrets were read again from their files. Settings stored with PatchConfig
+// still override c, other settings of c only apply after a restart.
+func ReloadConfig(c Config) error {
+	configUpdateMu.Lock()
+	defer configUpdateMu.Unlock()
+
+	stored, err := GetAllConfig()
+	if err != nil {
+		return err
+	}
+	oc, err := overrideConfig(c, stored)
+	if err != nil {
+		return err
+	}
+	s, err := parseSettings(oc)
+	if err != nil {
+		return err
+	}
+	configMu.Lock()
+	fileConfig = c
+	configMu.Unlock()
+	s.apply()
+	return nil
+}
+
+// loadStoredConfig applies the settings stored in the config bucket over the config file
+func loadStoredConfig() error {
+	stored, err := GetAllConfig()
+	if err != nil {
+		return err
+	}
+	removed := ConfigMap{}
+	for _, k := range removedSettings {
+		if _, ok := stored[k]; ok {
+			removed[k] = nil
+		}
+	}
+	if len(removed) > 0 {
+		if err := UpdateConfig(removed); err != nil {
+			return err
+		}
+	}
+	n := 0
+	for k := range stored {
+		if _, ok := configSettings[k]; ok {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	c, err := overrideConfig(getFileConfig(), stored)
+	if err != nil {
+		return err
+	}
+	s, err := parseSettings(c)
+	if err != nil {
+		return fmt.Errorf("stored config: %s", err.Error())
+	}
+	s.apply()
+	return nil
+}
@@ -0,0 +1,104 @@
+package dist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchConfig(t *testing.T) {
+	defer PatchConfig(ConfigMap{"FilenameTemplate": nil, "Subscription.ConfirmTTL": nil, "NotifyTemplates": nil}, "")
+
+	rv, err := PatchConfig(ConfigMap{
+		"FilenameTemplate":        "sc2a-{{.Version}}.zip",
+		"Subscription.ConfirmTTL": "24h",
+		"NotifyTemplates":         map[string]interface{}{"zh": map[string]interface{}{"Subject": "新版本 {{.Release.Version}}"}},
+	}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "sc2a-{{.Version}}.zip", rv["FilenameTemplate"])
+	assert.NotContains(t, rv, "Mailgun.APIKey")
+
+	// applied without a restart
+	assert.Equal(t, "sc2a-1.2.zip", Release{Version: "1.2"}.FileName())
+	assert.Equal(t, 24*time.Hour, getConfirmTTL())
+	assert.Equal(t, []string{"zh"}, Locales())
+
+	// stored and returned
+	stored, err := GetAllConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "24h", stored["Subscription.ConfirmTTL"])
+	rv, err = GetRuntimeConfig()
+	assert.Nil(t, err)
+	assert.Len(t, rv, len(ConfigSettings()))
+
+	// invalid values change nothing
+	for _, bad := range []ConfigMap{
+		{"FilenameTemplate": "{{.Version"},
+		{"Subscription.ConfirmTTL": "soon"},
+		{"Subscription.ConfirmTTL": 24},
+		{"NotifyTemplates": map[string]interface{}{"zh": map[string]interface{}{"Title": "x"}}},
+		{"NotifyTemplates": map[string]interface{}{"": map[string]interface{}{"Subject": "x"}}},
+		{"LinkSecret": "x"},
+		// secrets only come from the config file, environment or flags
+		{"Mailgun.APIKey": "key-stored"},
+		{"Mailgun.WebhookSigningKey": "key-stored"},
+	} {
+		_, err = PatchConfig(bad, "")
+		if assert.IsType(t, &ValidationError{}, err) {
+			assert.Equal(t, "config", err.(*ValidationError).Code)
+		}
+	}
+	assert.Equal(t, "sc2a-1.2.zip", Release{Version: "1.2"}.FileName())
+	assert.Equal(t, 24*time.Hour, getConfirmTTL())
+	assert.NotEqual(t, "key-stored", webhookSigningKey)
+	stored, err = GetAllConfig()
+	assert.Nil(t, err)
+	assert.NotContains(t, stored, "Mailgun.WebhookSigningKey")
+
+	// null goes back to the config file
+	rv, err = PatchConfig(ConfigMap{"Subscription.ConfirmTTL": nil}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", rv["Subscription.ConfirmTTL"])
+	assert.Equal(t, defaultConfirmTTL, getConfirmTTL())
+	stored, err = GetAllConfig()
+	assert.Nil(t, err)
+	assert.NotContains(t, stored, "Subscription.ConfirmTTL")
+}
+
+func TestReloadConfig(t *testing.T) {
+	prev := getFileConfig()
+	defer ReloadConfig(prev)
+	defer PatchConfig(ConfigMap{"FilenameTemplate": nil}, "")
+
+	c := prev
+	c.Mailgun.WebhookSigningKey = "reloaded-key"
+	c.FilenameTemplate = "reloaded-{{.Version}}.zip"
+	assert.Nil(t, ReloadConfig(c))
+	assert.Equal(t, "reloaded-key", webhookSigningKey)
+	assert.Equal(t, "reloaded-1.2.zip", Release{Version: "1.2"}.FileName())
+
+	// stored settings still override the reloaded config
+	_, err := PatchConfig(ConfigMap{"FilenameTemplate": "stored-{{.Version}}.zip"}, "")
+	assert.Nil(t, err)
+	c.Mailgun.WebhookSigningKey = "rotated-key"
+	assert.Nil(t, ReloadConfig(c))
+	assert.Equal(t, "rotated-key", webhookSigningKey)
+	assert.Equal(t, "stored-1.2.zip", Release{Version: "1.2"}.FileName())
+
+	// an invalid config keeps the current settings
+	bad := c
+	bad.Subscription.ConfirmTTL = "soon"
+	assert.NotNil(t, ReloadConfig(bad))
+	assert.Equal(t, "rotated-key", webhookSigningKey)
+}
+
+func TestLoadStoredConfigRemovesSecrets(t *testing.T) {
+	prev := webhookSigningKey
+	assert.Nil(t, UpdateConfig(ConfigMap{"Mailgun.WebhookSigningKey": "key-stored"}))
+	assert.Nil(t, loadStoredConfig())
+	assert.Equal(t, prev, webhookSigningKey)
+	stored, err := GetAllConfig()
+	assert.Nil(t, err)
+	assert.NotContains(t, stored, "Mailgun.WebhookSigningKey")
+}
@@ -0,0 +1,265 @@
+package dist
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/fluxxu/util"
+)
+
+// signingKey is an Ed25519 key pair used to sign releases
+type signingKey struct {
+	ID         string
+	PublicKey  []byte
+	PrivateKey []byte
+	Date       util.JSONTime
+	Current    bool
+}
+
+// PublicKey is a trusted release signing key
+type PublicKey struct {
+	ID        string
+	PublicKey []byte
+	Date      util.JSONTime
+	Current   bool
+}
+
+// PublicKeysByDateDesc is slice of PublicKey sorted by date desc
+type PublicKeysByDateDesc []PublicKey
+
+func (l PublicKeysByDateDesc) Len() int      { return len(l) }
+func (l PublicKeysByDateDesc) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l PublicKeysByDateDesc) Less(i, j int) bool {
+	return !time.Time(l[i].Date).Before(time.Time(l[j].Date))
+}
+
+// ErrSigningKeyNotFound is returned when a signing key id is not found
+var ErrSigningKeyNotFound = errors.New("signing key was not found")
+
+// ErrRevokeCurrentKey is returned when trying to revoke the key currently in use
+var ErrRevokeCurrentKey = errors.New("current signing key can not be revoked, rotate it first")
+
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func putSigningKey(b *bolt.Bucket, key *signingKey) error {
+	j, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key.ID), j)
+}
+
+func newSigningKey(b *bolt.Bucket) (*signingKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{
+		ID:         keyID(pub),
+		PublicKey:  pub,
+		PrivateKey: priv,
+		Date:       util.JSONTime(time.Now()),
+		Current:    true,
+	}
+	return key, putSigningKey(b, key)
+}
+
+func forEachSigningKey(b *bolt.Bucket, fn func(key *signingKey) error) error {
+	return b.ForEach(func(k, v []byte) error {
+		key := signingKey{}
+		if err := json.Unmarshal(v, &key); err != nil {
+			return fmt.Errorf("unmarshal signing key %s: %s", string(k), err.Error())
+		}
+		return fn(&key)
+	})
+}
+
+func findCurrentSigningKey(b *bolt.Bucket) (rv *signingKey, err error) {
+	err = forEachSigningKey(b, func(key *signingKey) error {
+		if key.Current {
+			rv = key
+		}
+		return nil
+	})
+	return
+}
+
+// currentSigningKey returns the key used for new signatures, generating one on first use.
+// It only takes the write lock of the database when there is no key yet.
+func currentSigningKey() (rv *signingKey, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		rv, err = findCurrentSigningKey(tx.Bucket([]byte("signing_key")))
+		return err
+	})
+	if err != nil || rv != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("signing_key"))
+		rv, err = findCurrentSigningKey(b)
+		if err != nil || rv != nil {
+			return err
+		}
+		rv, err = newSigningKey(b)
+		return err
+	})
+	return
+}
+
+// sign signs a message with the current signing key
+func sign(message []byte) (keyID string, sig []byte, err error) {
+	key, err := currentSigningKey()
+	if err != nil {
+		return
+	}
+	return key.ID, ed25519.Sign(ed25519.PrivateKey(key.PrivateKey), message), nil
+}
+
+// PublicKeys lists all trusted signing keys
+func PublicKeys() (PublicKeysByDateDesc, error) {
+	if _, err := currentSigningKey(); err != nil {
+		return nil, err
+	}
+
+	list := PublicKeysByDateDesc{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return forEachSigningKey(tx.Bucket([]byte("signing_key")), func(key *signingKey) error {
+			list = append(list, PublicKey{
+				ID:        key.ID,
+				PublicKey: key.PublicKey,
+				Date:      key.Date,
+				Current:   key.Current,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Stable(list)
+
+	return list, nil
+}
+
+// RotateSigningKey generates a new current signing key, previous keys stay trusted until revoked
+func RotateSigningKey() (rv *PublicKey, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("signing_key"))
+		previous := []*signingKey{}
+		err := forEachSigningKey(b, func(key *signingKey) error {
+			if key.Current {
+				previous = append(previous, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range previous {
+			key.Current = false
+			if err := putSigningKey(b, key); err != nil {
+				return err
+			}
+		}
+
+		key, err := newSigningKey(b)
+		if err != nil {
+			return err
+		}
+		rv = &PublicKey{
+			ID:        key.ID,
+			PublicKey: key.PublicKey,
+			Date:      key.Date,
+			Current:   key.Current,
+		}
+		return nil
+	})
+	return
+}
+
+// RevokeSigningKey removes a retired key from the trusted list
+func RevokeSigningKey(id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("signing_key"))
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrSigningKeyNotFound
+		}
+		key := signingKey{}
+		if err := json.Unmarshal(v, &key); err != nil {
+			return err
+		}
+		if key.Current {
+			return ErrRevokeCurrentKey
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// ManifestEntry describes a release in the signed manifest
+type ManifestEntry struct {
+	ID             string
+	Version        string
+	Date           util.JSONTime
+	Size           int64
+	SHA256         string
+	Signature      []byte
+	SignatureKeyID string
+}
+
+// SignedManifest is the release manifest with a detached signature over its exact bytes
+type SignedManifest struct {
+	Manifest  json.RawMessage
+	KeyID     string
+	Signature []byte
+}
+
+// Manifest returns a signed list of all releases
+func Manifest() (*SignedManifest, error) {
+	list, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []ManifestEntry{}
+	for _, r := range list {
+		entries = append(entries, ManifestEntry{
+			ID:             r.ID,
+			Version:        r.Version,
+			Date:           r.Date,
+			Size:           r.Size,
+			SHA256:         r.SHA256,
+			Signature:      r.Signature,
+			SignatureKeyID: r.SignatureKeyID,
+		})
+	}
+	j, err := json.Marshal(struct {
+		Date     util.JSONTime
+		Releases []ManifestEntry
+	}{util.JSONTime(time.Now()), entries})
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, sig, err := sign(j)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedManifest{
+		Manifest:  j,
+		KeyID:     keyID,
+		Signature: sig,
+	}, nil
+}
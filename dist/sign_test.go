@@ -0,0 +1,105 @@
+package dist
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPublicKey(t *testing.T, id string) ed25519.PublicKey {
+	keys, err := PublicKeys()
+	assert.NoError(t, err)
+	for _, key := range keys {
+		if key.ID == id {
+			return ed25519.PublicKey(key.PublicKey)
+		}
+	}
+	return nil
+}
+
+func TestPublishSignature(t *testing.T) {
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("RELEASE"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+
+	digest := sha256.Sum256([]byte("RELEASE"))
+	assert.Equal(t, hex.EncodeToString(digest[:]), r.SHA256)
+
+	pub := testPublicKey(t, r.SignatureKeyID)
+	assert.NotNil(t, pub)
+	assert.True(t, ed25519.Verify(pub, digest[:], r.Signature))
+}
+
+func TestPublicKeysReadOnly(t *testing.T) {
+	_, err := currentSigningKey()
+	assert.NoError(t, err)
+
+	// listing keys and signing do not write once a key exists
+	writes := db.Stats().TxStats.Write
+	_, err = PublicKeys()
+	assert.NoError(t, err)
+	_, _, err = sign([]byte("message"))
+	assert.NoError(t, err)
+	assert.Equal(t, writes, db.Stats().TxStats.Write)
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("RELEASE"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+
+	key, err := RotateSigningKey()
+	assert.NoError(t, err)
+	assert.True(t, key.Current)
+	assert.NotEqual(t, r.SignatureKeyID, key.ID)
+
+	keys, err := PublicKeys()
+	assert.NoError(t, err)
+	current := 0
+	for _, k := range keys {
+		if k.Current {
+			current++
+			assert.Equal(t, key.ID, k.ID)
+		}
+	}
+	assert.Equal(t, 1, current)
+
+	digest := sha256.Sum256([]byte("RELEASE"))
+	pub := testPublicKey(t, r.SignatureKeyID)
+	assert.True(t, ed25519.Verify(pub, digest[:], r.Signature))
+
+	assert.Equal(t, ErrRevokeCurrentKey, RevokeSigningKey(key.ID))
+	assert.NoError(t, RevokeSigningKey(r.SignatureKeyID))
+	assert.Nil(t, testPublicKey(t, r.SignatureKeyID))
+	assert.Equal(t, ErrSigningKeyNotFound, RevokeSigningKey(r.SignatureKeyID))
+}
+
+func TestManifest(t *testing.T) {
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewBufferString("RELEASE"))
+	assert.NoError(t, err)
+	defer Unpublish(r.ID)
+
+	m, err := Manifest()
+	assert.NoError(t, err)
+	pub := testPublicKey(t, m.KeyID)
+	assert.True(t, ed25519.Verify(pub, m.Manifest, m.Signature))
+
+	manifest := struct {
+		Releases []ManifestEntry
+	}{}
+	assert.NoError(t, json.Unmarshal(m.Manifest, &manifest))
+	found := false
+	for _, entry := range manifest.Releases {
+		if entry.ID == r.ID {
+			found = true
+			assert.Equal(t, r.SHA256, entry.SHA256)
+			assert.Equal(t, r.Signature, entry.Signature)
+		}
+	}
+	assert.True(t, found)
+}
@@ -14,10 +14,31 @@ import (
 
 // Sub represents a email subscriber
 type Sub struct {
-	ID    string
-	Name  string
-	Email string
-	Date  time.Time
+	ID     string
+	Name   string
+	Email  string
+	Date   time.Time
+	Status string
+	Tags   []string `json:",omitempty"`
+	// Locale selects the notification language, e.g. "zh-cn"
+	Locale string `json:",omitempty"`
+}
+
+// Subscriber statuses, subscribers saved before statuses existed have none and are active
+const (
+	SubPending    = "pending"
+	SubActive     = "active"
+	SubSuspended  = "suspended"
+	SubBounced    = "bounced"
+	SubComplained = "complained"
+)
+
+// ErrInvalidStatus is returned when a subscriber status can not be set
+var ErrInvalidStatus = errors.New("status must be active, suspended, bounced or complained")
+
+// Active reports whether the subscriber should receive notifications
+func (s Sub) Active() bool {
+	return s.Status == "" || s.Status == SubActive
 }
 
 // SubsByDate is slice of Release sorted by date
@@ -54,17 +75,23 @@ func ListSubs() (SubsByDate, error) {
 
 // Subscribe adds a new subscriber
 func Subscribe(sub Sub) (rv *Sub, err error) {
-	id := uuid.NewV4().String()
-	sub.ID = id
-	sub.Date = time.Now()
-	j, err := json.Marshal(sub)
+	sub.Email, err = NormalizeEmail(sub.Email)
 	if err != nil {
 		return
 	}
+	if sub.Tags, err = normalizeTags(sub.Tags); err != nil {
+		return
+	}
+	if sub.Locale, err = normalizeLocale(sub.Locale); err != nil {
+		return
+	}
+	id := uuid.NewV4().String()
+	sub.ID = id
+	sub.Date = time.Now()
+	sub.Status = SubActive
 
 	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("sub"))
-		return b.Put([]byte(id), j)
+		return putNewSub(tx, sub, ConsentAdded)
 	})
 
 	if err != nil {
@@ -78,35 +105,93 @@ func Subscribe(sub Sub) (rv *Sub, err error) {
 // ErrSubNotFound is returned when subscriber is not found by id
 var ErrSubNotFound = errors.New("subscriber was not found")
 
-// UpdateSubscriber updates subscribes' email and name
+// GetSub returns a subscriber by id
+func GetSub(id string) (*Sub, error) {
+	return getSub(id)
+}
+
+// SubUpdate changes the email and name of a subscriber, and tags and locale unless they are nil
+type SubUpdate struct {
+	ID     string
+	Name   string
+	Email  string
+	Tags   []string
+	Locale *string
+}
+
+// UpdateSubscriber updates subscribes' email, name and locale, and tags unless they are nil
 func UpdateSubscriber(sub Sub) (rv *Sub, err error) {
-	id := sub.ID
-	fromDb := Sub{}
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("sub"))
-		v := b.Get([]byte(id))
+	return UpdateSub(SubUpdate{ID: sub.ID, Name: sub.Name, Email: sub.Email, Tags: sub.Tags, Locale: &sub.Locale})
+}
 
-		if v == nil {
-			return ErrSubNotFound
+// UpdateSub applies a SubUpdate, a missing locale keeps the stored one
+func UpdateSub(u SubUpdate) (rv *Sub, err error) {
+	id := u.ID
+	sub := Sub{Name: u.Name}
+	sub.Email, err = NormalizeEmail(u.Email)
+	if err != nil {
+		return
+	}
+	if sub.Tags, err = normalizeTags(u.Tags); err != nil {
+		return
+	}
+	if u.Locale != nil {
+		if sub.Locale, err = normalizeLocale(*u.Locale); err != nil {
+			return
 		}
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		rv, err = modifySub(tx, id, func(fromDb *Sub) {
+			fromDb.Email = sub.Email
+			fromDb.Name = sub.Name
+			if sub.Tags != nil {
+				fromDb.Tags = sub.Tags
+			}
+			if u.Locale != nil {
+				fromDb.Locale = sub.Locale
+			}
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
 
-		err := json.Unmarshal(v, &fromDb)
-		if err != nil {
-			return err
-		}
+// modifySub applies modify to a stored subscriber and reindexes a changed email
+func modifySub(tx *bolt.Tx, id string, modify func(*Sub)) (*Sub, error) {
+	b := tx.Bucket([]byte("sub"))
+	v := b.Get([]byte(id))
 
-		fromDb.Email = sub.Email
-		fromDb.Name = sub.Name
+	if v == nil {
+		return nil, ErrSubNotFound
+	}
 
-		j, err := json.Marshal(fromDb)
-		if err != nil {
-			return err
+	fromDb := Sub{}
+	err := json.Unmarshal(v, &fromDb)
+	if err != nil {
+		return nil, err
+	}
+
+	email := fromDb.Email
+	modify(&fromDb)
+	if fromDb.Email != email {
+		if err := indexSubEmail(tx, fromDb.Email, id); err != nil {
+			return nil, err
+		}
+		if err := unindexSubEmail(tx, email, id); err != nil {
+			return nil, err
 		}
+	}
 
-		return b.Put([]byte(id), j)
-	})
+	j, err := json.Marshal(fromDb)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	if err := b.Put([]byte(id), j); err != nil {
+		return nil, err
 	}
 	return &fromDb, nil
 }
@@ -114,7 +199,11 @@ func UpdateSubscriber(sub Sub) (rv *Sub, err error) {
 // Unsubscribe removes a subscriber by ID
 func Unsubscribe(id string) error {
 	err := db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte("sub")).Delete([]byte(id))
+		sub, err := deleteSub(tx, id)
+		if err != nil || sub == nil {
+			return err
+		}
+		return recordConsent(tx, id, ConsentUnsubscribed, "admin")
 	})
 
 	if err != nil {
@@ -123,3 +212,37 @@ func Unsubscribe(id string) error {
 
 	return nil
 }
+
+// SetSubStatus changes the status of a confirmed subscriber
+func SetSubStatus(id, status string) (rv *Sub, err error) {
+	switch status {
+	case SubActive, SubSuspended, SubBounced, SubComplained:
+	default:
+		return nil, ErrInvalidStatus
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sub"))
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrSubNotFound
+		}
+		sub := Sub{}
+		if err := json.Unmarshal(v, &sub); err != nil {
+			return err
+		}
+		if sub.Status == SubPending {
+			return ErrInvalidStatus
+		}
+		sub.Status = status
+		j, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		if err := recordConsent(tx, id, ConsentStatus, status); err != nil {
+			return err
+		}
+		rv = &sub
+		return b.Put([]byte(id), j)
+	})
+	return
+}
@@ -15,7 +15,7 @@ func TestListSubs(t *testing.T) {
 func TestSubscribe(t *testing.T) {
 	sub, err := Subscribe(Sub{
 		Name:  "Name",
-		Email: "Email",
+		Email: "subscribe@example.com",
 	})
 	assert.NoError(t, err)
 	assert.NotEmpty(t, sub.ID)
@@ -32,12 +32,12 @@ func TestSubscribe(t *testing.T) {
 func TestUpdateSubscriber(t *testing.T) {
 	sub, err := Subscribe(Sub{
 		Name:  "Name",
-		Email: "Email",
+		Email: "update@example.com",
 	})
 	assert.NoError(t, err)
 
 	sub.Name = "NAME"
-	sub.Email = "EMAIL"
+	sub.Email = "Updated@Example.com"
 	sub, err = UpdateSubscriber(*sub)
 	assert.NoError(t, err)
 
@@ -47,10 +47,28 @@ func TestUpdateSubscriber(t *testing.T) {
 	assert.True(t, reflect.DeepEqual(&list[len(list)-1], sub))
 }
 
+func TestUpdateSubKeepsLocale(t *testing.T) {
+	sub, err := Subscribe(Sub{Name: "Name", Email: "update.locale@example.com", Locale: "zh", Tags: []string{"beta"}})
+	assert.NoError(t, err)
+	defer Unsubscribe(sub.ID)
+
+	// absent tags and locale are kept
+	updated, err := UpdateSub(SubUpdate{ID: sub.ID, Name: "Renamed", Email: sub.Email})
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", updated.Name)
+	assert.Equal(t, "zh", updated.Locale)
+	assert.Equal(t, []string{"beta"}, updated.Tags)
+
+	empty := ""
+	updated, err = UpdateSub(SubUpdate{ID: sub.ID, Name: "Renamed", Email: sub.Email, Locale: &empty})
+	assert.NoError(t, err)
+	assert.Equal(t, "", updated.Locale)
+}
+
 func TestUnsubscribe(t *testing.T) {
 	sub, err := Subscribe(Sub{
 		Name:  "Name",
-		Email: "Email",
+		Email: "unsubscribe@example.com",
 	})
 	assert.NoError(t, err)
 	assert.NotEmpty(t, sub.ID)
@@ -62,3 +80,26 @@ func TestUnsubscribe(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, len(list) == 0 || list[len(list)-1].ID != sub.ID)
 }
+
+func TestSetSubStatus(t *testing.T) {
+	sub, err := Subscribe(Sub{
+		Name:  "Name",
+		Email: "status@example.com",
+	})
+	assert.NoError(t, err)
+	defer Unsubscribe(sub.ID)
+
+	sub, err = SetSubStatus(sub.ID, SubSuspended)
+	assert.NoError(t, err)
+	assert.Equal(t, SubSuspended, sub.Status)
+	assert.False(t, sub.Active())
+
+	_, err = SetSubStatus(sub.ID, SubPending)
+	assert.Equal(t, ErrInvalidStatus, err)
+	_, err = SetSubStatus("missing", SubActive)
+	assert.Equal(t, ErrSubNotFound, err)
+
+	sub, err = SetSubStatus(sub.ID, SubActive)
+	assert.NoError(t, err)
+	assert.True(t, sub.Active())
+}
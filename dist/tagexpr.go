@@ -0,0 +1,148 @@
+package dist
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]*$`)
+
+// normalizeTags lower cases, validates, deduplicates and sorts subscriber tags
+func normalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	rv := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if !tagPattern.MatchString(t) || isTagOperator(t) {
+			return nil, validationError(http.StatusBadRequest, "tag", "invalid tag: %q", t)
+		}
+		seen[t] = true
+		rv = append(rv, t)
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
+// splitTags splits a list of tags separated by spaces, commas or semicolons
+func splitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == ';'
+	})
+}
+
+func isTagOperator(t string) bool {
+	return t == "and" || t == "or" || t == "not"
+}
+
+// tagExpr is a parsed tag expression such as "organizer and (zh or ko) and not staff"
+type tagExpr interface {
+	match(tags map[string]bool) bool
+}
+
+type tagTerm string
+type tagNot struct{ x tagExpr }
+type tagAnd struct{ x, y tagExpr }
+type tagOr struct{ x, y tagExpr }
+
+func (e tagTerm) match(tags map[string]bool) bool { return tags[string(e)] }
+func (e tagNot) match(tags map[string]bool) bool  { return !e.x.match(tags) }
+func (e tagAnd) match(tags map[string]bool) bool  { return e.x.match(tags) && e.y.match(tags) }
+func (e tagOr) match(tags map[string]bool) bool   { return e.x.match(tags) || e.y.match(tags) }
+
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseTagExpr parses a tag expression of tags, and, or, not and parentheses.
+// and binds tighter than or.
+func parseTagExpr(s string) (tagExpr, error) {
+	p := &tagParser{tokens: strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(strings.ToLower(s)))}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("tag expression is empty")
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in tag expression", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+func (p *tagParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) or() (tagExpr, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.pos++
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = tagOr{x, y}
+	}
+	return x, nil
+}
+
+func (p *tagParser) and() (tagExpr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.pos++
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = tagAnd{x, y}
+	}
+	return x, nil
+}
+
+func (p *tagParser) unary() (tagExpr, error) {
+	t := p.peek()
+	p.pos++
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of tag expression")
+	case t == "not":
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{x}, nil
+	case t == "(":
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) in tag expression")
+		}
+		p.pos++
+		return x, nil
+	case tagPattern.MatchString(t) && !isTagOperator(t):
+		return tagTerm(t), nil
+	}
+	return nil, fmt.Errorf("unexpected %q in tag expression", t)
+}
@@ -0,0 +1,47 @@
+package dist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" ZH", "organizer", "zh", ""})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"organizer", "zh"}, tags)
+
+	tags, err = normalizeTags(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, tags)
+
+	for _, bad := range []string{"two words", "not", "(zh)", "-zh"} {
+		_, err = normalizeTags([]string{bad})
+		assert.Error(t, err, bad)
+	}
+	assert.Equal(t, []string{"zh", "organizer", "a"}, splitTags("zh organizer;a,"))
+}
+
+func TestTagExpr(t *testing.T) {
+	tags := map[string]bool{"organizer": true, "zh": true}
+	for expr, expected := range map[string]bool{
+		"organizer":                              true,
+		"ko":                                     false,
+		"not ko":                                 true,
+		"organizer and ko":                       false,
+		"ko or zh":                               true,
+		"ko and zh or organizer":                 true,
+		"ko and (zh or organizer)":               false,
+		"ORGANIZER and (ko or zh) and not staff": true,
+		"not not zh":                             true,
+	} {
+		e, err := parseTagExpr(expr)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, expected, e.match(tags), expr)
+	}
+
+	for _, bad := range []string{"", "and", "zh and", "(zh", "zh)", "zh ko", "or zh", "zh or ()"} {
+		_, err := parseTagExpr(bad)
+		assert.Error(t, err, bad)
+	}
+}
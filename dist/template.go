@@ -0,0 +1,390 @@
+package dist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/fluxxu/util"
+	"gopkg.in/mailgun/mailgun-go.v1"
+)
+
+// Templates the editor can change, named after their Config settings
+const (
+	TemplateFilename      = "FilenameTemplate"
+	TemplateNotifySubject = "NotifyEmailSubjectTemplate"
+	TemplateNotifyContent = "NotifyEmailContentTemplate"
+)
+
+var editableTemplates = []string{TemplateFilename, TemplateNotifySubject, TemplateNotifyContent}
+
+// ErrTemplateNotFound is returned for templates the editor can not change
+var ErrTemplateNotFound = errors.New("template was not found")
+
+// ErrTemplateVersionNotFound is returned when rolling back to an unknown version
+var ErrTemplateVersionNotFound = errors.New("template version was not found")
+
+// Template is the current text of a template
+type Template struct {
+	Name string
+	Text string
+	// Version is the latest version in the history, 0 if the template was never edited
+	Version uint64
+}
+
+// TemplateVersion is a saved text of a template, the first version of a template is the config file text
+type TemplateVersion struct {
+	Name    string
+	Version uint64
+	Text    string
+	// Author is the admin or api token that saved the version, empty for the config file
+	Author string
+	Date   time.Time
+}
+
+func isEditableTemplate(name string) bool {
+	for _, t := range editableTemplates {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+func templateText(c Config, name string) string {
+	return *configSettings[name].field(&c).(*string)
+}
+
+// templateVersionKey orders versions of a template by number
+func templateVersionKey(name string, version uint64) []byte {
+	k := make([]byte, len(name)+1+8)
+	copy(k, name+"/")
+	binary.BigEndian.PutUint64(k[len(name)+1:], version)
+	return k
+}
+
+// latestTemplateVersion returns the last version of a template, nil if there is none
+func latestTemplateVersion(tx *bolt.Tx, name string) (*TemplateVersion, error) {
+	prefix := []byte(name + "/")
+	c := tx.Bucket([]byte("template_version")).Cursor()
+	k, _ := c.Seek(templateVersionKey(name, ^uint64(0)))
+	if k == nil {
+		k, _ = c.Last()
+	} else {
+		k, _ = c.Prev()
+	}
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return nil, nil
+	}
+	v := TemplateVersion{}
+	if err := json.Unmarshal(tx.Bucket([]byte("template_version")).Get(k), &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func putTemplateVersion(tx *bolt.Tx, v TemplateVersion) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("template_version")).Put(templateVersionKey(v.Name, v.Version), j)
+}
+
+// recordTemplateVersions adds changed editable templates to their history,
+// the previous text is recorded first for templates without history so they can be rolled back to
+func recordTemplateVersions(prev, c Config, author string) error {
+	now := time.Now()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range editableTemplates {
+			before, after := templateText(prev, name), templateText(c, name)
+			if before == after {
+				continue
+			}
+			latest, err := latestTemplateVersion(tx, name)
+			if err != nil {
+				return err
+			}
+			if latest == nil {
+				latest = &TemplateVersion{Name: name, Version: 1, Text: before, Date: now}
+				if err := putTemplateVersion(tx, *latest); err != nil {
+					return err
+				}
+			}
+			err = putTemplateVersion(tx, TemplateVersion{Name: name, Version: latest.Version + 1, Text: after, Author: author, Date: now})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListTemplates returns the current editable templates
+func ListTemplates() (rv []Template, err error) {
+	stored, err := GetAllConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := overrideConfig(getFileConfig(), stored)
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, name := range editableTemplates {
+			t := Template{Name: name, Text: templateText(c, name)}
+			latest, err := latestTemplateVersion(tx, name)
+			if err != nil {
+				return err
+			}
+			if latest != nil {
+				t.Version = latest.Version
+			}
+			rv = append(rv, t)
+		}
+		return nil
+	})
+	return
+}
+
+// GetTemplate returns the current text of an editable template
+func GetTemplate(name string) (*Template, error) {
+	if !isEditableTemplate(name) {
+		return nil, ErrTemplateNotFound
+	}
+	list, err := ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range list {
+		if t.Name == name {
+			return &t, nil
+		}
+	}
+	return nil, ErrTemplateNotFound
+}
+
+// sampleRelease is rendered when validating templates and previewing without a release
+var sampleRelease = Release{
+	ID:          "sample",
+	Version:     "1.0.0",
+	Description: "Sample release notes",
+	Date:        util.JSONTime(time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)),
+}
+
+// ValidateTemplate checks that a template parses and renders a sample release
+func ValidateTemplate(name, text string) error {
+	if !isEditableTemplate(name) {
+		return ErrTemplateNotFound
+	}
+	t, err := parsePreviewTemplate(name, text)
+	if err != nil {
+		return err
+	}
+	if name == TemplateFilename {
+		if _, err := sampleRelease.fileName(t); err != nil {
+			return validationError(http.StatusBadRequest, "template", "%s: %s", name, err.Error())
+		}
+		return nil
+	}
+	_, err = executePreviewTemplate(name, t, notifyEmailContext{
+		Release: sampleRelease,
+		Date:    sampleRelease.Date.Time().Format("20060102150405"),
+	})
+	return err
+}
+
+// SaveTemplate validates a template, applies it without a restart and adds it to the history
+func SaveTemplate(name, text, author string) (*Template, error) {
+	if err := ValidateTemplate(name, text); err != nil {
+		return nil, err
+	}
+	if _, err := PatchConfig(ConfigMap{name: text}, author); err != nil {
+		return nil, err
+	}
+	return GetTemplate(name)
+}
+
+// TemplateHistory returns the versions of a template, newest first
+func TemplateHistory(name string) (rv []TemplateVersion, err error) {
+	if !isEditableTemplate(name) {
+		return nil, ErrTemplateNotFound
+	}
+	rv = []TemplateVersion{}
+	prefix := []byte(name + "/")
+	err = db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("template_version")).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			tv := TemplateVersion{}
+			if err := json.Unmarshal(v, &tv); err != nil {
+				return err
+			}
+			rv = append([]TemplateVersion{tv}, rv...)
+		}
+		return nil
+	})
+	return
+}
+
+// RollbackTemplate saves the text of an earlier version as a new version
+func RollbackTemplate(name string, version uint64, author string) (*Template, error) {
+	if !isEditableTemplate(name) {
+		return nil, ErrTemplateNotFound
+	}
+	var text *string
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte("template_version")).Get(templateVersionKey(name, version))
+		if v == nil {
+			return ErrTemplateVersionNotFound
+		}
+		tv := TemplateVersion{}
+		if err := json.Unmarshal(v, &tv); err != nil {
+			return err
+		}
+		text = &tv.Text
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return SaveTemplate(name, *text, author)
+}
+
+// TemplatePreview renders templates for a release and subscriber, nil texts use the current templates
+type TemplatePreview struct {
+	Subject  *string
+	Content  *string
+	Filename *string
+	// ReleaseID defaults to a sample release
+	ReleaseID string
+	// SubID renders in the locale of a subscriber, links always use a sample subscriber
+	SubID  string
+	Locale string
+}
+
+// RenderedTemplates are the rendered notification email and filename of a preview
+type RenderedTemplates struct {
+	Subject  string
+	Content  string
+	FileName string
+	Locale   string
+}
+
+func parsePreviewTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, validationError(http.StatusBadRequest, "template", "%s: %s", name, err.Error())
+	}
+	return t, nil
+}
+
+func executePreviewTemplate(name string, t *template.Template, ctx interface{}) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, ctx); err != nil {
+		return "", validationError(http.StatusBadRequest, "template", "%s: %s", name, err.Error())
+	}
+	return buf.String(), nil
+}
+
+// PreviewTemplates renders the notification email and filename of a release for a subscriber
+func PreviewTemplates(p TemplatePreview) (*RenderedTemplates, error) {
+	release := sampleRelease
+	if p.ReleaseID != "" {
+		r, err := getRelease(p.ReleaseID)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			return nil, validationError(http.StatusNotFound, "release", "release was not found")
+		}
+		release = *r
+	}
+	link := Link{ID: "preview", SubID: "sample", ReleaseID: release.ID}
+	locale, err := normalizeLocale(p.Locale)
+	if err != nil {
+		return nil, err
+	}
+	if p.SubID != "" {
+		sub, err := getSub(p.SubID)
+		if err != nil {
+			if err == ErrSubNotFound {
+				return nil, validationError(http.StatusNotFound, "sub", "%s", err.Error())
+			}
+			return nil, err
+		}
+		// only the locale is taken, the links of a real subscriber could unsubscribe or change them
+		locale = sub.Locale
+	}
+
+	rv := &RenderedTemplates{Locale: locale}
+	vars, err := notifyRecipientVariables(link)
+	if err != nil {
+		return nil, err
+	}
+	subject, content := localeNotifyTemplates(locale)
+	if p.Subject != nil {
+		if subject, err = parsePreviewTemplate(TemplateNotifySubject, *p.Subject); err != nil {
+			return nil, err
+		}
+	}
+	if p.Content != nil {
+		if content, err = parsePreviewTemplate(TemplateNotifyContent, *p.Content); err != nil {
+			return nil, err
+		}
+	}
+	var name *template.Template
+	if p.Filename != nil {
+		if name, err = parsePreviewTemplate(TemplateFilename, *p.Filename); err != nil {
+			return nil, err
+		}
+	}
+
+	localized := release
+	localized.Description = release.LocalizedDescription(locale)
+	ctx := notifyEmailContext{
+		Release: localized,
+		Date:    release.Date.Time().Format("20060102150405"),
+	}
+	if rv.Subject, err = executePreviewTemplate(TemplateNotifySubject, subject, ctx); err != nil {
+		return nil, err
+	}
+	if rv.Content, err = executePreviewTemplate(TemplateNotifyContent, content, ctx); err != nil {
+		return nil, err
+	}
+	rv.FileName = release.FileName()
+	if name != nil {
+		if rv.FileName, err = release.fileName(name); err != nil {
+			return nil, validationError(http.StatusBadRequest, "template", "%s: %s", TemplateFilename, err.Error())
+		}
+	}
+	// fill in what mailgun would for the subscriber
+	for k, v := range vars {
+		rv.Subject = strings.Replace(rv.Subject, "%recipient."+k+"%", fmt.Sprint(v), -1)
+		rv.Content = strings.Replace(rv.Content, "%recipient."+k+"%", fmt.Sprint(v), -1)
+	}
+	return rv, nil
+}
+
+// SendTestEmail sends a preview of the notification email to an address
+func SendTestEmail(p TemplatePreview, email string) error {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	rv, err := PreviewTemplates(p)
+	if err != nil {
+		return err
+	}
+	m := mailgun.NewMessage(mailFrom, "[Test] "+rv.Subject, rv.Content, email)
+	if _, _, err := mailer().Send(m); err != nil {
+		return fmt.Errorf("test email: send: %s", err.Error())
+	}
+	return nil
+}
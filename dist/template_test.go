@@ -0,0 +1,114 @@
+package dist
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveTemplate(t *testing.T) {
+	defer PatchConfig(ConfigMap{TemplateNotifySubject: nil}, "")
+	before, err := GetTemplate(TemplateNotifySubject)
+	assert.Nil(t, err)
+
+	saved, err := SaveTemplate(TemplateNotifySubject, "New {{.Release.Version}}", "template-alice")
+	assert.Nil(t, err)
+	assert.Equal(t, "New {{.Release.Version}}", saved.Text)
+	_, err = SaveTemplate(TemplateNotifySubject, "Version {{.Release.Version}}", "template-bob")
+	assert.Nil(t, err)
+
+	history, err := TemplateHistory(TemplateNotifySubject)
+	assert.Nil(t, err)
+	if assert.True(t, len(history) >= 3) {
+		assert.Equal(t, "Version {{.Release.Version}}", history[0].Text)
+		assert.Equal(t, "template-bob", history[0].Author)
+		assert.Equal(t, "New {{.Release.Version}}", history[1].Text)
+		assert.Equal(t, history[0].Version-1, history[1].Version)
+	}
+	current, err := GetTemplate(TemplateNotifySubject)
+	assert.Nil(t, err)
+	assert.Equal(t, history[0].Version, current.Version)
+
+	// the first version keeps the text from before any edit
+	first := history[len(history)-1]
+	assert.Equal(t, uint64(1), first.Version)
+	if before.Version == 0 {
+		assert.Equal(t, before.Text, first.Text)
+		assert.Equal(t, "", first.Author)
+	}
+
+	rolled, err := RollbackTemplate(TemplateNotifySubject, history[1].Version, "template-alice")
+	assert.Nil(t, err)
+	assert.Equal(t, "New {{.Release.Version}}", rolled.Text)
+	assert.Equal(t, history[0].Version+1, rolled.Version)
+	subject, _ := localeNotifyTemplates("")
+	buf := &bytes.Buffer{}
+	assert.Nil(t, subject.Execute(buf, notifyEmailContext{Release: sampleRelease}))
+	assert.Equal(t, "New 1.0.0", buf.String())
+
+	_, err = RollbackTemplate(TemplateNotifySubject, 999999, "template-alice")
+	assert.Equal(t, ErrTemplateVersionNotFound, err)
+	_, err = SaveTemplate("LinkSecret", "x", "template-alice")
+	assert.Equal(t, ErrTemplateNotFound, err)
+}
+
+func TestValidateTemplate(t *testing.T) {
+	assert.Nil(t, ValidateTemplate(TemplateNotifyContent, "{{.Release.Description}} %recipient.Link%"))
+	assert.Nil(t, ValidateTemplate(TemplateFilename, "sc2a-{{.Version}}-{{.Date}}.zip"))
+	for name, text := range map[string]string{
+		TemplateNotifyContent: "{{.Release.Description",
+		TemplateNotifySubject: "{{.Release.Missing}}",
+		TemplateFilename:      "{{.Release.Version}}",
+	} {
+		err := ValidateTemplate(name, text)
+		if assert.IsType(t, &ValidationError{}, err, name) {
+			assert.Equal(t, "template", err.(*ValidationError).Code)
+		}
+	}
+	history, err := TemplateHistory(TemplateNotifyContent)
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+}
+
+func TestPreviewTemplates(t *testing.T) {
+	defer useTestNotifyConfig()()
+	sub, err := Subscribe(Sub{Name: "Preview", Email: "preview@example.com", Locale: "zh"})
+	assert.Nil(t, err)
+	defer Unsubscribe(sub.ID)
+	r, err := Publish(Release{Version: "2.0", Description: "Notes", Descriptions: map[string]string{"zh": "说明"}}, bytes.NewBufferString("preview"))
+	assert.Nil(t, err)
+	defer Unpublish(r.ID)
+
+	content := "{{.Release.Description}} %recipient.Unsubscribe%"
+	filename := "sc2a-{{.Version}}.zip"
+	rv, err := PreviewTemplates(TemplatePreview{Content: &content, Filename: &filename})
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0.0", rv.Subject)
+	assert.True(t, strings.HasPrefix(rv.Content, "Sample release notes /unsubscribe?token="), rv.Content)
+	assert.Equal(t, "sc2a-1.0.0.zip", rv.FileName)
+
+	rv, err = PreviewTemplates(TemplatePreview{Content: &content, ReleaseID: r.ID, SubID: sub.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, "2.0", rv.Subject)
+	assert.Equal(t, "zh", rv.Locale)
+	assert.True(t, strings.HasPrefix(rv.Content, "说明 "), rv.Content)
+	// links of the real subscriber are never rendered
+	real, err := unsubscribeLink(sub.ID)
+	assert.Nil(t, err)
+	assert.NotContains(t, rv.Content, real)
+	assert.NotContains(t, rv.Content, sub.ID)
+
+	_, err = PreviewTemplates(TemplatePreview{ReleaseID: "missing"})
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, 404, err.(*ValidationError).Status)
+	}
+	_, err = PreviewTemplates(TemplatePreview{SubID: "missing"})
+	assert.IsType(t, &ValidationError{}, err)
+
+	sent := testMg.Sent()
+	assert.Nil(t, SendTestEmail(TemplatePreview{ReleaseID: r.ID}, "Editor@Example.com"))
+	assert.Equal(t, sent+1, testMg.Sent())
+	assert.Equal(t, ErrInvalidEmail, SendTestEmail(TemplatePreview{}, "not an address"))
+}
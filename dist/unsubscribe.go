@@ -0,0 +1,188 @@
+package dist
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var linkSecretLock sync.Mutex
+var linkSecret []byte
+
+// getLinkSecret returns the key signing public links, generated and stored on first use
+// unless LinkSecret is configured
+func getLinkSecret() (rv []byte, err error) {
+	linkSecretLock.Lock()
+	defer linkSecretLock.Unlock()
+	if linkSecret != nil {
+		return linkSecret, nil
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("secret"))
+		if v := b.Get([]byte("link")); v != nil {
+			rv = append([]byte{}, v...)
+			return nil
+		}
+		rv = make([]byte, 32)
+		if _, err := rand.Read(rv); err != nil {
+			return err
+		}
+		return b.Put([]byte("link"), rv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	linkSecret = rv
+	return
+}
+
+// signLinkToken returns a token binding id to a purpose, e.g. "unsubscribe"
+func signLinkToken(purpose, id string) (string, error) {
+	secret, err := getLinkSecret()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose + ":" + id))
+	return id + "." + hex.EncodeToString(mac.Sum(nil)[:16]), nil
+}
+
+// ErrLinkTokenInvalid is returned when a signed link token does not verify
+var ErrLinkTokenInvalid = errors.New("link is invalid")
+
+// verifyLinkToken checks a token made by signLinkToken and returns its id
+func verifyLinkToken(purpose, token string) (string, error) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return "", ErrLinkTokenInvalid
+	}
+	expected, err := signLinkToken(purpose, token[:i])
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(expected), []byte(token)) {
+		return "", ErrLinkTokenInvalid
+	}
+	return token[:i], nil
+}
+
+var makeUnsubscribeLink = func(token string) string {
+	return "/unsubscribe?token=" + token
+}
+
+func unsubscribeLink(subID string) (string, error) {
+	token, err := signLinkToken("unsubscribe", subID)
+	if err != nil {
+		return "", err
+	}
+	return makeUnsubscribeLink(token), nil
+}
+
+// Unsubscribe methods recorded with an UnsubscribeEvent and as consent event details
+const (
+	UnsubscribeOneClick = "one-click"
+	UnsubscribePage     = "page"
+	UnsubscribeMailgun  = "mailgun"
+)
+
+// UnsubscribeEvent records why a subscriber left
+type UnsubscribeEvent struct {
+	SubID  string
+	Method string
+	Reason string
+	Date   time.Time
+}
+
+// UnsubscribeReport summarizes unsubscribe events
+type UnsubscribeReport struct {
+	Total   int
+	Reasons map[string]int
+	Events  []UnsubscribeEvent
+}
+
+const maxUnsubscribeReasonLen = 500
+
+// UnsubscribeByToken removes the subscriber a signed unsubscribe token was issued for
+func UnsubscribeByToken(token, method, reason string) (rv *Sub, err error) {
+	id, err := verifyLinkToken("unsubscribe", token)
+	if err != nil {
+		return
+	}
+	return unsubscribe(id, method, reason)
+}
+
+// unsubscribe removes a subscriber and records an UnsubscribeEvent
+func unsubscribe(id, method, reason string) (rv *Sub, err error) {
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxUnsubscribeReasonLen {
+		reason = reason[:maxUnsubscribeReasonLen]
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		sub, err := deleteSub(tx, id)
+		if err != nil {
+			return err
+		}
+		if sub == nil {
+			return ErrSubNotFound
+		}
+		rv = sub
+		if err := recordConsent(tx, id, ConsentUnsubscribed, method); err != nil {
+			return err
+		}
+
+		eb := tx.Bucket([]byte("unsubscribe"))
+		seq, err := eb.NextSequence()
+		if err != nil {
+			return err
+		}
+		j, err := json.Marshal(UnsubscribeEvent{
+			SubID:  id,
+			Method: method,
+			Reason: reason,
+			Date:   time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return eb.Put(key, j)
+	})
+	return
+}
+
+// GetUnsubscribeReport returns all unsubscribe events, newest first, with counts per reason
+func GetUnsubscribeReport() (*UnsubscribeReport, error) {
+	rv := &UnsubscribeReport{
+		Reasons: map[string]int{},
+		Events:  []UnsubscribeEvent{},
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("unsubscribe")).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			e := UnsubscribeEvent{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("unmarshal unsubscribe event: %s", err.Error())
+			}
+			rv.Events = append(rv.Events, e)
+			rv.Reasons[e.Reason]++
+			rv.Total++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
@@ -0,0 +1,60 @@
+package dist
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkToken(t *testing.T) {
+	token, err := signLinkToken("unsubscribe", "SUB_ID")
+	assert.NoError(t, err)
+
+	id, err := verifyLinkToken("unsubscribe", token)
+	assert.NoError(t, err)
+	assert.Equal(t, "SUB_ID", id)
+
+	_, err = verifyLinkToken("preferences", token)
+	assert.Equal(t, ErrLinkTokenInvalid, err)
+	_, err = verifyLinkToken("unsubscribe", "OTHER_ID"+token[strings.LastIndex(token, "."):])
+	assert.Equal(t, ErrLinkTokenInvalid, err)
+	_, err = verifyLinkToken("unsubscribe", "SUB_ID")
+	assert.Equal(t, ErrLinkTokenInvalid, err)
+}
+
+func TestNotifyRecipientVariables(t *testing.T) {
+	defer useTestNotifyConfig()()
+
+	vars, err := notifyRecipientVariables(Link{ID: "LINK_ID", SubID: "SUB_ID"})
+	assert.NoError(t, err)
+	assert.Contains(t, vars["Link"], "LINK_ID")
+	assert.Contains(t, vars["Unsubscribe"], "SUB_ID.")
+	assert.Contains(t, vars["Preferences"], "/preferences?token=SUB_ID.")
+}
+
+func TestUnsubscribeByToken(t *testing.T) {
+	sub, err := Subscribe(Sub{Name: "Name", Email: "leaving@example.com"})
+	assert.NoError(t, err)
+
+	token, err := signLinkToken("unsubscribe", sub.ID)
+	assert.NoError(t, err)
+
+	_, err = UnsubscribeByToken(token+"0", UnsubscribePage, "")
+	assert.Equal(t, ErrLinkTokenInvalid, err)
+
+	removed, err := UnsubscribeByToken(token, UnsubscribePage, " I never signed up ")
+	assert.NoError(t, err)
+	assert.Equal(t, sub.ID, removed.ID)
+	assert.Nil(t, findSubByEmail(t, "leaving@example.com"))
+
+	_, err = UnsubscribeByToken(token, UnsubscribeOneClick, "")
+	assert.Equal(t, ErrSubNotFound, err)
+
+	report, err := GetUnsubscribeReport()
+	assert.NoError(t, err)
+	assert.True(t, report.Total >= 1)
+	assert.True(t, report.Reasons["I never signed up"] >= 1)
+	assert.Equal(t, sub.ID, report.Events[0].SubID)
+	assert.Equal(t, UnsubscribePage, report.Events[0].Method)
+}
@@ -0,0 +1,224 @@
+package dist
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// UploadConfig limits what can be published
+type UploadConfig struct {
+	MinSize       int64
+	MaxSize       int64
+	AllowedTypes  []string
+	VerifyVersion bool
+}
+
+var uploadConfig UploadConfig
+
+// File types recognized by upload validation
+const (
+	FileTypeZip = "zip"
+	FileTypeExe = "exe"
+	FileTypeMsi = "msi"
+)
+
+// ValidationError is returned when a release, its file or other input is rejected
+type ValidationError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func validationError(status int, code string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Status:  status,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+var (
+	magicZip      = []byte("PK\x03\x04")
+	magicZipEmpty = []byte("PK\x05\x06")
+	magicOLE      = []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")
+)
+
+// detectFileType sniffs the type of a file by its magic number
+func detectFileType(f io.ReaderAt) string {
+	head := make([]byte, 64)
+	n, _ := f.ReadAt(head, 0)
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, magicZip), bytes.HasPrefix(head, magicZipEmpty):
+		return FileTypeZip
+	case bytes.HasPrefix(head, magicOLE):
+		return FileTypeMsi
+	case bytes.HasPrefix(head, []byte("MZ")) && len(head) >= 0x40:
+		off := int64(binary.LittleEndian.Uint32(head[0x3c:]))
+		sig := make([]byte, 4)
+		if _, err := f.ReadAt(sig, off); err == nil && bytes.Equal(sig, []byte("PE\x00\x00")) {
+			return FileTypeExe
+		}
+	}
+	return ""
+}
+
+func isAllowedType(t string) bool {
+	if len(uploadConfig.AllowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range uploadConfig.AllowedTypes {
+		if strings.EqualFold(allowed, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkZip reads every entry of a zip archive to verify its checksums
+func checkZip(f io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, entry := range zr.File {
+		rc, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %s", entry.Name, err.Error())
+		}
+		_, err = io.Copy(ioutil.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", entry.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// readPEVersion returns the file version from the VS_FIXEDFILEINFO of an executable
+func readPEVersion(f io.ReaderAt) (string, error) {
+	pf, err := pe.NewFile(f)
+	if err != nil {
+		return "", err
+	}
+	defer pf.Close()
+
+	s := pf.Section(".rsrc")
+	if s == nil {
+		return "", nil
+	}
+	data, err := s.Data()
+	if err != nil {
+		return "", err
+	}
+
+	// VS_FIXEDFILEINFO starts with dwSignature, dwStrucVersion, dwFileVersionMS, dwFileVersionLS
+	i := bytes.Index(data, []byte{0xbd, 0x04, 0xef, 0xfe})
+	if i < 0 || len(data) < i+16 {
+		return "", nil
+	}
+	ms := binary.LittleEndian.Uint32(data[i+8:])
+	ls := binary.LittleEndian.Uint32(data[i+12:])
+	return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xffff, ls>>16, ls&0xffff), nil
+}
+
+// versionMatches compares dotted versions numerically, ignoring a "v" prefix and trailing zeros
+func versionMatches(a, b string) bool {
+	parse := func(v string) ([]uint64, bool) {
+		parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(v), "v"), ".")
+		rv := make([]uint64, 0, len(parts))
+		for _, p := range parts {
+			n, err := strconv.ParseUint(p, 10, 32)
+			if err != nil {
+				return nil, false
+			}
+			rv = append(rv, n)
+		}
+		for len(rv) > 0 && rv[len(rv)-1] == 0 {
+			rv = rv[:len(rv)-1]
+		}
+		return rv, true
+	}
+	av, ok := parse(a)
+	if !ok {
+		return false
+	}
+	bv, ok := parse(b)
+	if !ok || len(av) != len(bv) {
+		return false
+	}
+	for i := range av {
+		if av[i] != bv[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func validateRelease(release Release) error {
+	if strings.TrimSpace(release.Version) == "" {
+		return validationError(http.StatusBadRequest, "version_required", "Version is required")
+	}
+	return nil
+}
+
+// validateFile checks a stored upload against the upload config and returns its detected type
+func validateFile(release Release, f *os.File, size int64) (string, error) {
+	if size == 0 {
+		return "", validationError(http.StatusBadRequest, "empty_file", "uploaded file is empty")
+	}
+	if uploadConfig.MinSize > 0 && size < uploadConfig.MinSize {
+		return "", validationError(http.StatusBadRequest, "file_too_small",
+			"uploaded file is %d bytes, minimum is %d", size, uploadConfig.MinSize)
+	}
+
+	t := detectFileType(f)
+	if !isAllowedType(t) {
+		if t == "" {
+			t = "unknown"
+		}
+		return "", validationError(http.StatusUnsupportedMediaType, "file_type_not_allowed",
+			"file type %s is not allowed, expected one of: %s", t, strings.Join(uploadConfig.AllowedTypes, ", "))
+	}
+
+	switch t {
+	case FileTypeZip:
+		if err := checkZip(f, size); err != nil {
+			return "", validationError(http.StatusUnprocessableEntity, "corrupt_archive",
+				"zip archive is corrupt: %s", err.Error())
+		}
+	case FileTypeExe:
+		if !uploadConfig.VerifyVersion {
+			break
+		}
+		v, err := readPEVersion(f)
+		if err != nil {
+			return "", validationError(http.StatusUnprocessableEntity, "corrupt_executable",
+				"executable is corrupt: %s", err.Error())
+		}
+		if v == "" {
+			return "", validationError(http.StatusUnprocessableEntity, "version_missing",
+				"executable has no version resource")
+		}
+		if !versionMatches(release.Version, v) {
+			return "", validationError(http.StatusUnprocessableEntity, "version_mismatch",
+				"executable version %s does not match %s", v, release.Version)
+		}
+	}
+
+	return t, nil
+}
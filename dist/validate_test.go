@@ -0,0 +1,131 @@
+package dist
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testZip(t *testing.T) []byte {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	w, err := zw.Create("sc2a/readme.txt")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("SC2Advanced release data"))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func testExe(t *testing.T, version string) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0x80))
+	copy(buf.Bytes(), "MZ")
+	binary.LittleEndian.PutUint32(buf.Bytes()[0x3c:], 0x80)
+	buf.WriteString("PE\x00\x00")
+
+	rsrc := bytes.NewBuffer(nil)
+	if version != "" {
+		var v [4]uint16
+		_, err := fmt.Sscanf(version, "%d.%d.%d.%d", &v[0], &v[1], &v[2], &v[3])
+		assert.NoError(t, err)
+		binary.Write(rsrc, binary.LittleEndian, []uint32{
+			0xfeef04bd, 0x00010000,
+			uint32(v[0])<<16 | uint32(v[1]), uint32(v[2])<<16 | uint32(v[3]),
+		})
+	}
+
+	headersSize := uint32(buf.Len() + 20 + 40)
+	binary.Write(buf, binary.LittleEndian, pe.FileHeader{
+		Machine:          pe.IMAGE_FILE_MACHINE_I386,
+		NumberOfSections: 1,
+	})
+	var name [8]uint8
+	copy(name[:], ".rsrc")
+	binary.Write(buf, binary.LittleEndian, pe.SectionHeader32{
+		Name:             name,
+		VirtualSize:      uint32(rsrc.Len()),
+		SizeOfRawData:    uint32(rsrc.Len()),
+		PointerToRawData: headersSize,
+	})
+	buf.Write(rsrc.Bytes())
+	return buf.Bytes()
+}
+
+func TestDetectFileType(t *testing.T) {
+	assert.Equal(t, FileTypeZip, detectFileType(bytes.NewReader(testZip(t))))
+	assert.Equal(t, FileTypeExe, detectFileType(bytes.NewReader(testExe(t, ""))))
+	assert.Equal(t, FileTypeMsi, detectFileType(bytes.NewReader(append(magicOLE, 0, 0, 0, 0))))
+	assert.Equal(t, "", detectFileType(bytes.NewReader([]byte("MZ"))))
+	assert.Equal(t, "", detectFileType(bytes.NewReader([]byte("RELEASE"))))
+}
+
+func TestVersionMatches(t *testing.T) {
+	assert.True(t, versionMatches("0.1.2", "0.1.2.0"))
+	assert.True(t, versionMatches("v1.0", "1.0.0.0"))
+	assert.False(t, versionMatches("0.1.2", "0.1.3.0"))
+	assert.False(t, versionMatches("0.1.2-beta", "0.1.2.0"))
+}
+
+func testPublishError(t *testing.T, release Release, data []byte) *ValidationError {
+	r, err := Publish(release, bytes.NewReader(data))
+	assert.Nil(t, r)
+	verr, ok := err.(*ValidationError)
+	assert.True(t, ok, "expected ValidationError, got %v", err)
+	return verr
+}
+
+func TestPublishValidation(t *testing.T) {
+	defer func(c UploadConfig) { uploadConfig = c }(uploadConfig)
+
+	verr := testPublishError(t, Release{}, []byte("RELEASE"))
+	assert.Equal(t, http.StatusBadRequest, verr.Status)
+	assert.Equal(t, "version_required", verr.Code)
+
+	verr = testPublishError(t, Release{Version: "0.0.1"}, nil)
+	assert.Equal(t, "empty_file", verr.Code)
+
+	uploadConfig = UploadConfig{MinSize: 16}
+	verr = testPublishError(t, Release{Version: "0.0.1"}, []byte("RELEASE"))
+	assert.Equal(t, "file_too_small", verr.Code)
+
+	uploadConfig = UploadConfig{MaxSize: 4}
+	verr = testPublishError(t, Release{Version: "0.0.1"}, []byte("RELEASE"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, verr.Status)
+	assert.Equal(t, "file_too_large", verr.Code)
+
+	uploadConfig = UploadConfig{AllowedTypes: []string{"zip", "exe"}}
+	verr = testPublishError(t, Release{Version: "0.0.1"}, []byte("RELEASE"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, verr.Status)
+
+	corrupt := testZip(t)
+	i := bytes.Index(corrupt, []byte("SC2Advanced"))
+	corrupt[i] = 'X'
+	verr = testPublishError(t, Release{Version: "0.0.1"}, corrupt)
+	assert.Equal(t, http.StatusUnprocessableEntity, verr.Status)
+	assert.Equal(t, "corrupt_archive", verr.Code)
+
+	r, err := Publish(Release{Version: "0.0.1"}, bytes.NewReader(testZip(t)))
+	assert.NoError(t, err)
+	assert.Equal(t, FileTypeZip, r.Type)
+	assert.Equal(t, int64(len(testZip(t))), r.Size)
+	assert.NoError(t, Unpublish(r.ID))
+
+	uploadConfig = UploadConfig{AllowedTypes: []string{"exe"}, VerifyVersion: true}
+	verr = testPublishError(t, Release{Version: "0.0.1"}, testExe(t, ""))
+	assert.Equal(t, "version_missing", verr.Code)
+
+	verr = testPublishError(t, Release{Version: "0.0.1"}, testExe(t, "0.0.2.0"))
+	assert.Equal(t, http.StatusUnprocessableEntity, verr.Status)
+	assert.Equal(t, "version_mismatch", verr.Code)
+
+	r, err = Publish(Release{Version: "0.0.1"}, bytes.NewReader(testExe(t, "0.0.1.0")))
+	assert.NoError(t, err)
+	assert.Equal(t, FileTypeExe, r.Type)
+	assert.NoError(t, Unpublish(r.ID))
+}
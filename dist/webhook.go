@@ -0,0 +1,124 @@
+package dist
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var webhookSigningKey string
+
+// MailgunEvent is a delivery event posted to the mailgun webhook, in either the
+// legacy form format or the json "event-data" format
+type MailgunEvent struct {
+	Timestamp string
+	Token     string
+	Signature string
+	// Event is e.g. "bounced", "failed", "complained" or "unsubscribed"
+	Event string
+	// Severity is "permanent" or "temporary" for "failed" events
+	Severity  string
+	Recipient string
+	Reason    string
+}
+
+// ErrWebhookSignature is returned when a webhook event is not signed by mailgun
+var ErrWebhookSignature = errors.New("webhook signature is invalid")
+
+// webhookMaxAge rejects old and replayed events
+const webhookMaxAge = 15 * time.Minute
+
+var webhookTokensLock sync.Mutex
+var webhookTokens = map[string]time.Time{}
+
+func verifyWebhookSignature(e MailgunEvent, now time.Time) error {
+	configMu.RLock()
+	key := webhookSigningKey
+	configMu.RUnlock()
+	if key == "" {
+		return ErrWebhookSignature
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(e.Timestamp + e.Token))
+	sig, err := hex.DecodeString(e.Signature)
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return ErrWebhookSignature
+	}
+	ts, err := strconv.ParseInt(e.Timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > webhookMaxAge || age < -webhookMaxAge {
+		return ErrWebhookSignature
+	}
+
+	webhookTokensLock.Lock()
+	defer webhookTokensLock.Unlock()
+	for t, seen := range webhookTokens {
+		if now.Sub(seen) > 2*webhookMaxAge {
+			delete(webhookTokens, t)
+		}
+	}
+	if _, ok := webhookTokens[e.Token]; ok {
+		return ErrWebhookSignature
+	}
+	webhookTokens[e.Token] = now
+	return nil
+}
+
+// forgetWebhookToken accepts the token of an event again, so mailgun can retry an event that failed
+func forgetWebhookToken(token string) {
+	webhookTokensLock.Lock()
+	defer webhookTokensLock.Unlock()
+	delete(webhookTokens, token)
+}
+
+// HandleMailgunEvent verifies an event and updates the status of its recipient.
+// Permanent failures mark subscribers bounced, complaints complained, and
+// unsubscribes remove them. Other events and unknown recipients are ignored.
+// The token of an event that fails is accepted again, so mailgun can retry it.
+func HandleMailgunEvent(e MailgunEvent) (rv *Sub, err error) {
+	if err = verifyWebhookSignature(e, time.Now()); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			forgetWebhookToken(e.Token)
+		}
+	}()
+
+	var status string
+	switch e.Event {
+	case "bounced":
+		status = SubBounced
+	case "failed":
+		if e.Severity != "permanent" {
+			return nil, nil
+		}
+		status = SubBounced
+	case "complained":
+		status = SubComplained
+	case "unsubscribed":
+	default:
+		return nil, nil
+	}
+
+	sub, err := FindSubByEmail(e.Recipient)
+	if err == ErrSubNotFound || err == ErrInvalidEmail {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	if status == "" {
+		return unsubscribe(sub.ID, UnsubscribeMailgun, e.Reason)
+	}
+	if sub.Status == SubPending {
+		return nil, nil
+	}
+	return SetSubStatus(sub.ID, status)
+}
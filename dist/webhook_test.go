@@ -0,0 +1,128 @@
+package dist
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedMailgunEvent(event, recipient string, at time.Time) MailgunEvent {
+	e := MailgunEvent{
+		Timestamp: strconv.FormatInt(at.Unix(), 10),
+		Token:     uuid.NewV4().String(),
+		Event:     event,
+		Recipient: recipient,
+	}
+	mac := hmac.New(sha256.New, []byte(webhookSigningKey))
+	mac.Write([]byte(e.Timestamp + e.Token))
+	e.Signature = hex.EncodeToString(mac.Sum(nil))
+	return e
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	defer func(k string) { webhookSigningKey = k }(webhookSigningKey)
+	webhookSigningKey = "key-test"
+	now := time.Now()
+
+	e := signedMailgunEvent("bounced", "a@example.com", now)
+	assert.NoError(t, verifyWebhookSignature(e, now))
+	// replayed
+	assert.Equal(t, ErrWebhookSignature, verifyWebhookSignature(e, now))
+
+	e = signedMailgunEvent("bounced", "a@example.com", now.Add(-time.Hour))
+	assert.Equal(t, ErrWebhookSignature, verifyWebhookSignature(e, now))
+
+	e = signedMailgunEvent("bounced", "a@example.com", now)
+	e.Timestamp = strconv.FormatInt(now.Unix()+1, 10)
+	assert.Equal(t, ErrWebhookSignature, verifyWebhookSignature(e, now))
+
+	e = signedMailgunEvent("bounced", "a@example.com", now)
+	webhookSigningKey = "other"
+	assert.Equal(t, ErrWebhookSignature, verifyWebhookSignature(e, now))
+	webhookSigningKey = ""
+	assert.Equal(t, ErrWebhookSignature, verifyWebhookSignature(e, now))
+}
+
+func TestHandleMailgunEvent(t *testing.T) {
+	defer func(k string) { webhookSigningKey = k }(webhookSigningKey)
+	webhookSigningKey = "key-test"
+	now := time.Now()
+
+	sub, err := Subscribe(Sub{Name: "Bouncy", Email: "bouncy@example.com"})
+	assert.NoError(t, err)
+	defer Unsubscribe(sub.ID)
+
+	e := signedMailgunEvent("bounced", "bouncy@example.com", now)
+	e.Signature = "00"
+	_, err = HandleMailgunEvent(e)
+	assert.Equal(t, ErrWebhookSignature, err)
+
+	e = signedMailgunEvent("failed", "Bouncy@example.com", now)
+	e.Severity = "temporary"
+	updated, err := HandleMailgunEvent(e)
+	assert.NoError(t, err)
+	assert.Nil(t, updated)
+
+	e = signedMailgunEvent("failed", "Bouncy@example.com", now)
+	e.Severity = "permanent"
+	updated, err = HandleMailgunEvent(e)
+	assert.NoError(t, err)
+	assert.Equal(t, SubBounced, updated.Status)
+	assert.False(t, updated.Active())
+
+	updated, err = HandleMailgunEvent(signedMailgunEvent("complained", "bouncy@example.com", now))
+	assert.NoError(t, err)
+	assert.Equal(t, SubComplained, updated.Status)
+
+	updated, err = HandleMailgunEvent(signedMailgunEvent("bounced", "nobody@example.com", now))
+	assert.NoError(t, err)
+	assert.Nil(t, updated)
+
+	e = signedMailgunEvent("unsubscribed", "bouncy@example.com", now)
+	updated, err = HandleMailgunEvent(e)
+	assert.NoError(t, err)
+	assert.Equal(t, sub.ID, updated.ID)
+	_, err = FindSubByEmail("bouncy@example.com")
+	assert.Equal(t, ErrSubNotFound, err)
+	report, err := GetUnsubscribeReport()
+	assert.NoError(t, err)
+	assert.Equal(t, UnsubscribeMailgun, report.Events[0].Method)
+}
+
+func TestHandleMailgunEventRetry(t *testing.T) {
+	defer func(k string) { webhookSigningKey = k }(webhookSigningKey)
+	webhookSigningKey = "key-test"
+
+	sub, err := Subscribe(Sub{Name: "Retry", Email: "retry@example.com"})
+	assert.NoError(t, err)
+	defer Unsubscribe(sub.ID)
+	var stored []byte
+	assert.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sub"))
+		stored = append([]byte{}, b.Get([]byte(sub.ID))...)
+		return b.Put([]byte(sub.ID), []byte("{"))
+	}))
+
+	// a failed event can be retried with the same token
+	e := signedMailgunEvent("complained", "retry@example.com", time.Now())
+	_, err = HandleMailgunEvent(e)
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrWebhookSignature, err)
+	assert.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("sub")).Put([]byte(sub.ID), stored)
+	}))
+	updated, err := HandleMailgunEvent(e)
+	assert.NoError(t, err)
+	assert.Equal(t, SubComplained, updated.Status)
+
+	// once handled it is a replay
+	_, err = HandleMailgunEvent(e)
+	assert.Equal(t, ErrWebhookSignature, err)
+}
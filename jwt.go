@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// Admin tokens are HS256 jwts. New tokens are signed with the JWTSigningKey of JWTKeys, or with
+// JWTKey when it is empty, and name their key in the "kid" header. Every configured key verifies
+// tokens, so a new key can sign while tokens of the previous one stay valid until they expire.
+const (
+	tokenRealm      = "auth required"
+	tokenTimeout    = time.Hour
+	tokenMaxRefresh = time.Hour * 24
+	// tokenClaimsKey holds the claims of the admin token a request was authenticated with
+	tokenClaimsKey = "JWT_PAYLOAD"
+)
+
+var errNoSigningKey = errors.New("no jwt signing key is configured")
+
+// lookupAdmin loads the account of a token, so deleted, locked or changed accounts apply at once
+var lookupAdmin = dist.GetAdmin
+
+// jwtKeyring holds the keys of admin tokens by id, JWTKey has the empty id
+type jwtKeyring struct {
+	mu      sync.RWMutex
+	signing string
+	keys    map[string][]byte
+}
+
+var jwtKeys = &jwtKeyring{keys: map[string][]byte{}}
+
+// loadJWTKeys reads the jwt keys of c, including key files, and the id of the signing key
+func loadJWTKeys(c Config) (signing string, keys map[string][]byte, err error) {
+	keys = map[string][]byte{}
+	if c.JWTKey != "" {
+		keys[""] = []byte(c.JWTKey)
+	}
+	for id, key := range c.JWTKeys {
+		if id == "" || key == "" {
+			return "", nil, errors.New("JWTKeys: key ids and keys must not be empty")
+		}
+		keys[id] = []byte(key)
+	}
+	for id, path := range c.JWTKeyFiles {
+		if id == "" {
+			return "", nil, errors.New("JWTKeyFiles: key ids must not be empty")
+		}
+		if _, ok := keys[id]; ok {
+			return "", nil, fmt.Errorf("JWTKeyFiles: key %q is also in JWTKeys", id)
+		}
+		key, err := readSecretFile(path)
+		if err != nil {
+			return "", nil, fmt.Errorf("JWTKeyFiles: %s", err.Error())
+		}
+		if key == "" {
+			return "", nil, fmt.Errorf("JWTKeyFiles: %s is empty", path)
+		}
+		keys[id] = []byte(key)
+	}
+	if _, ok := keys[c.JWTSigningKey]; !ok {
+		if c.JWTSigningKey == "" {
+			return "", nil, errors.New("JWTKey: is required, or JWTSigningKey naming one of JWTKeys")
+		}
+		return "", nil, fmt.Errorf("JWTSigningKey: %q is not in JWTKeys or JWTKeyFiles", c.JWTSigningKey)
+	}
+	return c.JWTSigningKey, keys, nil
+}
+
+// reload switches to the keys of c, the current keys are kept if the keys of c are invalid
+func (k *jwtKeyring) reload(c Config) error {
+	signing, keys, err := loadJWTKeys(c)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.signing, k.keys = signing, keys
+	return nil
+}
+
+func (k *jwtKeyring) sign(claims jwt.MapClaims) (string, error) {
+	k.mu.RLock()
+	id, key := k.signing, k.keys[k.signing]
+	k.mu.RUnlock()
+	if key == nil {
+		return "", errNoSigningKey
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if id != "" {
+		token.Header["kid"] = id
+	}
+	return token.SignedString(key)
+}
+
+// derive returns a key for another purpose from the signing key, replicas with the same config share it
+func (k *jwtKeyring) derive(purpose string) []byte {
+	k.mu.RLock()
+	key := k.keys[k.signing]
+	k.mu.RUnlock()
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// parse verifies a token with the key named by its kid, tokens without a kid use JWTKey
+func (k *jwtKeyring) parse(s string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(s, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("invalid signing algorithm")
+		}
+		id := ""
+		if kid, ok := t.Header["kid"]; ok {
+			if id, ok = kid.(string); !ok {
+				return nil, errors.New("invalid key id")
+			}
+		}
+		k.mu.RLock()
+		key, ok := k.keys[id]
+		k.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", id)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token.Claims.(jwt.MapClaims), nil
+}
+
+// issueToken signs an admin token for the current account, origIat is the time of the login.
+// The roles are for clients, requests are authorized by the account.
+func issueToken(username string, origIat time.Time) (string, time.Time, error) {
+	admin, err := lookupAdmin(username)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expire := time.Now().Add(tokenTimeout)
+	token, err := jwtKeys.sign(jwt.MapClaims{
+		"id":       admin.Username,
+		"roles":    admin.Roles,
+		"exp":      expire.Unix(),
+		"orig_iat": origIat.Unix(),
+	})
+	return token, expire, err
+}
+
+// tokenClaims returns the claims of the admin token a request was authenticated with
+func tokenClaims(c *gin.Context) jwt.MapClaims {
+	v, _ := c.Get(tokenClaimsKey)
+	claims, _ := v.(jwt.MapClaims)
+	return claims
+}
+
+func tokenUnauthorized(c *gin.Context, code int, message string) {
+	c.Header("WWW-Authenticate", "JWT realm="+tokenRealm)
+	c.Abort()
+	c.JSON(code, gin.H{
+		"code":    code,
+		"message": message,
+	})
+}
+
+// tokenMiddleware authenticates admins by the bearer token of the Authorization header
+func tokenMiddleware(c *gin.Context) {
+	header := c.Request.Header.Get("Authorization")
+	if header == "" {
+		tokenUnauthorized(c, http.StatusUnauthorized, "auth header empty")
+		return
+	}
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		tokenUnauthorized(c, http.StatusUnauthorized, "invalid auth header")
+		return
+	}
+	claims, err := jwtKeys.parse(parts[1])
+	if err != nil {
+		tokenUnauthorized(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	id, ok := claims["id"].(string)
+	if !ok {
+		tokenUnauthorized(c, http.StatusUnauthorized, "token has no admin id")
+		return
+	}
+	admin, err := lookupAdmin(id)
+	if err != nil {
+		code := http.StatusUnauthorized
+		if err != dist.ErrAdminNotFound {
+			log.Printf("token %s: %s", id, err.Error())
+			code = http.StatusInternalServerError
+		}
+		tokenUnauthorized(c, code, err.Error())
+		return
+	}
+	if admin.Locked(time.Now()) {
+		tokenUnauthorized(c, http.StatusUnauthorized, dist.ErrAccountLocked.Error())
+		return
+	}
+	c.Set(tokenClaimsKey, claims)
+	if !allowed(admin.Roles, c.Request.Method, c.Request.URL.Path) {
+		tokenUnauthorized(c, http.StatusForbidden, "You don't have permission to access.")
+		return
+	}
+	c.Next()
+}
+
+func loginHandler(c *gin.Context) {
+	req := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{}
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil || req.Username == "" || req.Password == "" {
+		tokenUnauthorized(c, http.StatusBadRequest, "Missing Username or Password")
+		return
+	}
+	admin, err := dist.Authenticate(req.Username, req.Password)
+	if err != nil {
+		if err != dist.ErrInvalidCredentials && err != dist.ErrAccountLocked {
+			log.Printf("login %s: %s", req.Username, err.Error())
+		}
+		tokenUnauthorized(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	token, expire, err := issueToken(admin.Username, time.Now())
+	if err != nil {
+		log.Printf("login %s: %s", admin.Username, err.Error())
+		tokenUnauthorized(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expire": expire.Format(time.RFC3339)})
+}
+
+// refreshHandler issues a new token with the current key and account, for up to tokenMaxRefresh after login
+func refreshHandler(c *gin.Context) {
+	claims := tokenClaims(c)
+	id, _ := claims["id"].(string)
+	origIat, _ := claims["orig_iat"].(float64)
+	if int64(origIat) < time.Now().Add(-tokenMaxRefresh).Unix() {
+		tokenUnauthorized(c, http.StatusUnauthorized, "Token is expired.")
+		return
+	}
+	token, expire, err := issueToken(id, time.Unix(int64(origIat), 0))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == dist.ErrAdminNotFound {
+			code = http.StatusUnauthorized
+		}
+		tokenUnauthorized(c, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expire": expire.Format(time.RFC3339)})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func testClaims(username string, roles ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"id":       username,
+		"roles":    roles,
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"orig_iat": time.Now().Unix(),
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	k := &jwtKeyring{}
+	assert.Nil(t, k.reload(Config{JWTKey: "old-key"}))
+	old, err := k.sign(testClaims("alice"))
+	assert.Nil(t, err)
+
+	// the new key signs, tokens of the old key stay valid
+	assert.Nil(t, k.reload(Config{JWTKey: "old-key", JWTKeys: map[string]string{"2016-11": "new-key"}, JWTSigningKey: "2016-11"}))
+	signed, err := k.sign(testClaims("bob"))
+	assert.Nil(t, err)
+	token, _ := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return []byte("new-key"), nil })
+	if assert.NotNil(t, token) {
+		assert.Equal(t, "2016-11", token.Header["kid"])
+	}
+	claims, err := k.parse(old)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", claims["id"])
+	claims, err = k.parse(signed)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", claims["id"])
+
+	// once the old key is removed its tokens are rejected
+	assert.Nil(t, k.reload(Config{JWTKeys: map[string]string{"2016-11": "new-key"}, JWTSigningKey: "2016-11"}))
+	_, err = k.parse(old)
+	assert.NotNil(t, err)
+	_, err = k.parse(signed)
+	assert.Nil(t, err)
+
+	// invalid keys keep the current ones
+	assert.NotNil(t, k.reload(Config{JWTSigningKey: "2017-01"}))
+	_, err = k.parse(signed)
+	assert.Nil(t, err)
+
+	for name, token := range map[string]*jwt.Token{
+		"unknown kid": jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("eve")),
+		"other alg":   jwt.NewWithClaims(jwt.SigningMethodHS512, testClaims("eve")),
+	} {
+		token.Header["kid"] = "2017-01"
+		if name == "other alg" {
+			token.Header["kid"] = "2016-11"
+		}
+		s, err := token.SignedString([]byte("new-key"))
+		assert.Nil(t, err, name)
+		_, err = k.parse(s)
+		assert.NotNil(t, err, name)
+	}
+	expired := testClaims("eve")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	s, err := k.sign(expired)
+	assert.Nil(t, err)
+	_, err = k.parse(s)
+	assert.NotNil(t, err)
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sc2a-jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := writeConfigFile(t, dir, "2016-11", "file-key\n")
+
+	signing, keys, err := loadJWTKeys(Config{JWTKeyFiles: map[string]string{"2016-11": p}, JWTSigningKey: "2016-11"})
+	assert.Nil(t, err)
+	assert.Equal(t, "2016-11", signing)
+	assert.Equal(t, map[string][]byte{"2016-11": []byte("file-key")}, keys)
+
+	for _, c := range []Config{
+		{},
+		{JWTKeys: map[string]string{"2016-11": "key"}},
+		{JWTKey: "key", JWTSigningKey: "2016-11"},
+		{JWTKey: "key", JWTKeys: map[string]string{"": "key"}},
+		{JWTKeys: map[string]string{"2016-11": "key"}, JWTKeyFiles: map[string]string{"2016-11": p}, JWTSigningKey: "2016-11"},
+		{JWTKeyFiles: map[string]string{"2016-11": p + ".missing"}, JWTSigningKey: "2016-11"},
+		{JWTKeyFiles: map[string]string{"2016-11": writeConfigFile(t, dir, "empty", "\n")}, JWTSigningKey: "2016-11"},
+	} {
+		_, _, err := loadJWTKeys(c)
+		assert.NotNil(t, err, "%+v", c)
+	}
+}
+
+func TestTokenMiddleware(t *testing.T) {
+	prev := jwtKeys
+	defer func() { jwtKeys = prev }()
+	jwtKeys = &jwtKeyring{}
+	assert.Nil(t, jwtKeys.reload(Config{JWTKey: "old-key", JWTKeys: map[string]string{"2016-11": "new-key"}, JWTSigningKey: "2016-11"}))
+	admins := map[string]*dist.Admin{
+		"alice": {Username: "alice", Roles: []string{dist.RoleViewer}},
+		"bob":   {Username: "bob", Roles: []string{}},
+	}
+	defer func() { lookupAdmin = dist.GetAdmin }()
+	lookupAdmin = func(username string) (*dist.Admin, error) {
+		if a, ok := admins[username]; ok {
+			return a, nil
+		}
+		return nil, dist.ErrAdminNotFound
+	}
+
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(tokenMiddleware)
+	api.GET("/token", refreshHandler)
+	api.GET("/release", func(c *gin.Context) {
+		c.String(http.StatusOK, currentAdmin(c))
+	})
+	request := func(path, header string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	old := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("alice", dist.RoleViewer))
+	oldToken, err := old.SignedString([]byte("old-key"))
+	assert.Nil(t, err)
+	w := request("/api/release", "Bearer "+oldToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alice", w.Body.String())
+
+	// refreshing moves a token to the signing key
+	w = request("/api/token", "Bearer "+oldToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	rv := struct{ Token string }{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &rv))
+	refreshed, _ := jwt.Parse(rv.Token, func(*jwt.Token) (interface{}, error) { return []byte("new-key"), nil })
+	if assert.NotNil(t, refreshed) && assert.True(t, refreshed.Valid) {
+		claims := refreshed.Claims.(jwt.MapClaims)
+		assert.Equal(t, "2016-11", refreshed.Header["kid"])
+		assert.Equal(t, "alice", claims["id"])
+		assert.Equal(t, old.Claims.(jwt.MapClaims)["orig_iat"], int64(claims["orig_iat"].(float64)))
+	}
+	// refreshed claims come from the account, not the old token
+	admins["alice"].Roles = []string{dist.RolePublisher}
+	w = request("/api/token", "Bearer "+oldToken)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &rv))
+	refreshed, _ = jwt.Parse(rv.Token, func(*jwt.Token) (interface{}, error) { return []byte("new-key"), nil })
+	if assert.NotNil(t, refreshed) {
+		assert.Equal(t, []interface{}{dist.RolePublisher}, refreshed.Claims.(jwt.MapClaims)["roles"])
+	}
+	admins["alice"].Roles = []string{dist.RoleViewer}
+	w = request("/api/release", "Bearer "+rv.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stale := testClaims("alice", dist.RoleViewer)
+	stale["orig_iat"] = time.Now().Add(-tokenMaxRefresh - time.Minute).Unix()
+	staleToken, err := jwtKeys.sign(stale)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request("/api/token", "Bearer "+staleToken).Code)
+
+	for _, header := range []string{"", "Token " + oldToken, "Bearer " + oldToken + "x"} {
+		w = request("/api/release", header)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, header)
+		assert.Equal(t, "JWT realm="+tokenRealm, w.Header().Get("WWW-Authenticate"))
+	}
+	// roles in the token are not trusted, the account is
+	noRoles, err := jwtKeys.sign(testClaims("bob", dist.RoleAdmin))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, request("/api/release", "Bearer "+noRoles).Code)
+
+	// removed roles, locked and deleted accounts apply to issued tokens at once
+	admins["alice"].Roles = []string{}
+	assert.Equal(t, http.StatusForbidden, request("/api/release", "Bearer "+oldToken).Code)
+	admins["alice"].Roles = []string{dist.RoleViewer}
+	until := time.Now().Add(time.Minute)
+	admins["alice"].LockedUntil = &until
+	assert.Equal(t, http.StatusUnauthorized, request("/api/release", "Bearer "+oldToken).Code)
+	admins["alice"].LockedUntil = nil
+	delete(admins, "alice")
+	assert.Equal(t, http.StatusUnauthorized, request("/api/release", "Bearer "+oldToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("/api/token", "Bearer "+oldToken).Code)
+}
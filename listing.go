@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+// pageOptions reads the cursor, limit, q and sort query parameters of a listing
+func pageOptions(c *gin.Context) (opts dist.PageOptions, ok bool) {
+	opts = dist.PageOptions{
+		Cursor: c.Query("cursor"),
+		Query:  c.Query("q"),
+		Sort:   c.Query("sort"),
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(errors.New("limit must be a number"))
+			return opts, false
+		}
+		opts.Limit = n
+	}
+	return opts, true
+}
+
+// setPageHeaders adds the total and next cursor of a page, the body stays a plain list
+func setPageHeaders(c *gin.Context, info *dist.PageInfo) {
+	c.Header("X-Total-Count", strconv.Itoa(info.Total))
+	if info.Next != "" {
+		c.Header("X-Next-Cursor", info.Next)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/DreamHacks/sc2a-service/dist"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is layered: defaults, then a config file, then SC2A_* environment variables, then flags.
+// Settings are named by their path in Config with Dist left out, so Dist.Mailgun.APIKey is
+// SC2A_MAILGUN_APIKEY in the environment and -mailgun.apikey on the command line.
+// Lists of strings are comma separated, maps and other lists are JSON.
+// Secrets can be read from files instead, e.g. mounted kubernetes secrets, with
+// SC2A_MAILGUN_APIKEY_FILE or -mailgun.apikey-file. The config is read again on SIGHUP.
+const (
+	defaultConfigFile = "./data/config.json"
+	envPrefix         = "SC2A_"
+	// envConfigFile names the config file, like the -config flag
+	envConfigFile = envPrefix + "CONFIG"
+	// secretFileSuffix names the file a secret is read from, after its environment variable
+	secretFileSuffix = "_FILE"
+	// secretFileFlagSuffix names the file a secret is read from, after its flag
+	secretFileFlagSuffix = "-file"
+)
+
+func defaultConfig() Config {
+	return Config{
+		Dist: dist.Config{
+			DataFile: dist.DefaultDataFile,
+			DataDir:  dist.DefaultDataDir,
+		},
+	}
+}
+
+// configField is a setting of Config
+type configField struct {
+	// path is the field path with Dist left out, e.g. "Mailgun.APIKey"
+	path   string
+	value  reflect.Value
+	secret bool
+}
+
+func (f configField) envName() string {
+	return envPrefix + strings.ToUpper(strings.Replace(f.path, ".", "_", -1))
+}
+
+func (f configField) flagName() string {
+	return strings.ToLower(f.path)
+}
+
+// fromFile reports whether the setting can be read from a secret file
+func (f configField) fromFile() bool {
+	return f.secret && f.value.Kind() == reflect.String
+}
+
+// readSecretFile reads a secret from a file, surrounding whitespace such as a trailing newline is dropped
+func readSecretFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// configFields lists the settings of the struct v points into, nested structs are walked
+func configFields(v reflect.Value, prefix string) (rv []configField) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		if sf.Type.Kind() == reflect.Struct {
+			p := prefix + sf.Name + "."
+			if prefix == "" && sf.Name == "Dist" {
+				p = ""
+			}
+			rv = append(rv, configFields(v.Field(i), p)...)
+			continue
+		}
+		rv = append(rv, configField{path: prefix + sf.Name, value: v.Field(i), secret: sf.Tag.Get("secret") == "true"})
+	}
+	return
+}
+
+// set parses an environment variable or flag value into the setting
+func (f configField) set(s string) error {
+	v := f.value
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", s)
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", s)
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			list := []string{}
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			v.Set(reflect.ValueOf(list))
+			return nil
+		}
+	}
+	p := reflect.New(v.Type())
+	if err := json.Unmarshal([]byte(s), p.Interface()); err != nil {
+		return fmt.Errorf("invalid JSON: %s", err.Error())
+	}
+	v.Set(p.Elem())
+	return nil
+}
+
+// decodeConfigFile reads a .json, .yaml or .toml config file over c, unknown settings are errors
+func decodeConfigFile(path string, c *Config) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	j := b
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yaml", ".yml":
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		if j, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+	case ".toml":
+		v := map[string]interface{}{}
+		if err := toml.Unmarshal(b, &v); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		if j, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+	default:
+		return fmt.Errorf("%s: unknown config format, use .json, .yaml or .toml", path)
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// settingFlag passes a flag value to a func, so flags can be applied after the environment
+type settingFlag func(string) error
+
+func (f settingFlag) String() string     { return "" }
+func (f settingFlag) Set(s string) error { return f(s) }
+
+// loadConfig layers the config file, environment and flags over the defaults
+// and returns the arguments after the flags, e.g. a command
+func loadConfig(args []string, environ []string) (c Config, rest []string, err error) {
+	c = defaultConfig()
+	fields := configFields(reflect.ValueOf(&c).Elem(), "")
+
+	fs := flag.NewFlagSet("sc2a-service", flag.ContinueOnError)
+	fs.Usage = func() {
+		printUsage()
+		fmt.Fprintf(os.Stderr, "\nflags:\n")
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", "", "config file, .json, .yaml or .toml (default "+defaultConfigFile+")")
+	type flagValue struct {
+		field configField
+		value string
+		file  bool
+	}
+	flagged := []flagValue{}
+	for _, f := range fields {
+		f := f
+		fs.Var(settingFlag(func(s string) error {
+			flagged = append(flagged, flagValue{field: f, value: s})
+			return nil
+		}), f.flagName(), "sets "+f.path+", also "+f.envName())
+		if f.fromFile() {
+			fs.Var(settingFlag(func(s string) error {
+				flagged = append(flagged, flagValue{field: f, value: s, file: true})
+				return nil
+			}), f.flagName()+secretFileFlagSuffix, "reads "+f.path+" from a file, also "+f.envName()+secretFileSuffix)
+		}
+	}
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+
+	env := map[string]string{}
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, envPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	path := *configFile
+	if path == "" {
+		path = env[envConfigFile]
+	}
+	if path != "" {
+		err = decodeConfigFile(path, &c)
+	} else if _, serr := os.Stat(defaultConfigFile); serr == nil {
+		err = decodeConfigFile(defaultConfigFile, &c)
+	}
+	if err != nil {
+		return
+	}
+
+	// other SC2A_ variables are left alone, kubernetes adds some for a service named sc2a
+	for _, f := range fields {
+		v, ok := env[f.envName()]
+		if path, file := env[f.envName()+secretFileSuffix]; file && f.fromFile() {
+			if ok {
+				return c, nil, fmt.Errorf("%s: set only one of %s and %s", f.envName(), f.envName(), f.envName()+secretFileSuffix)
+			}
+			if v, err = readSecretFile(path); err != nil {
+				return c, nil, fmt.Errorf("%s: %s", f.envName()+secretFileSuffix, err.Error())
+			}
+			ok = true
+		}
+		if ok {
+			if err = f.set(v); err != nil {
+				return c, nil, fmt.Errorf("%s: %s", f.envName(), err.Error())
+			}
+		}
+	}
+	for _, fv := range flagged {
+		name := fv.field.flagName()
+		if fv.file {
+			name += secretFileFlagSuffix
+			if fv.value, err = readSecretFile(fv.value); err != nil {
+				return c, nil, fmt.Errorf("-%s: %s", name, err.Error())
+			}
+		}
+		if err = fv.field.set(fv.value); err != nil {
+			return c, nil, fmt.Errorf("-%s: %s", name, err.Error())
+		}
+	}
+	return c, fs.Args(), nil
+}
+
+// validateConfig reports every invalid setting the server can check before starting
+func validateConfig(c Config) error {
+	problems := []string{}
+	if c.BaseURI == "" {
+		problems = append(problems, "BaseURI: is required")
+	} else if u, err := url.Parse(c.BaseURI); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.HasSuffix(c.BaseURI, "/") {
+		problems = append(problems, "BaseURI: must be an http or https URL without a trailing slash, e.g. https://sc2a.example.com")
+	}
+	if _, _, err := loadJWTKeys(c); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Subscribe.RateLimit < 0 {
+		problems = append(problems, "Subscribe.RateLimit: must not be negative")
+	}
+	if c.Dist.DataFile == "" {
+		problems = append(problems, "DataFile: is required")
+	}
+	if c.Dist.DataDir == "" {
+		problems = append(problems, "DataDir: is required")
+	}
+	for _, p := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			problems = append(problems, fmt.Sprintf("TrustedProxies: %q is not an IP or CIDR", p))
+		}
+	}
+	if _, err := corsConfigs(c.CORS); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.OIDC.Issuer != "" {
+		if err := c.OIDC.validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if err := dist.CheckConfig(c.Dist); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// redactConfig returns c with secrets replaced, for printing
+func redactConfig(c Config) Config {
+	for _, f := range configFields(reflect.ValueOf(&c).Elem(), "") {
+		if !f.secret || f.value.Len() == 0 {
+			continue
+		}
+		switch f.value.Kind() {
+		case reflect.String:
+			f.value.SetString(dist.ConfigRedacted)
+		case reflect.Slice:
+			list := make([]string, f.value.Len())
+			for i := range list {
+				list[i] = dist.ConfigRedacted
+			}
+			f.value.Set(reflect.ValueOf(list))
+		case reflect.Map:
+			m := map[string]string{}
+			for _, k := range f.value.MapKeys() {
+				m[k.String()] = dist.ConfigRedacted
+			}
+			f.value.Set(reflect.ValueOf(m))
+		}
+	}
+	return c
+}
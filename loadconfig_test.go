@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sc2a-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := writeConfigFile(t, dir, "config.json", `{
+		"BaseURI": "https://file.example.com",
+		"JWTKey": "file-key",
+		"Dist": {"Mailgun": {"Domain": "file.example.com", "APIKey": "file-api-key"}, "DataDir": "/srv/release"}
+	}`)
+
+	c, rest, err := loadConfig([]string{"-mailgun.domain", "flag.example.com", "-cors", `{"api": {"Origins": "https://ui.example.com"}}`, "config", "print"}, []string{
+		"SC2A_CONFIG=" + p,
+		"SC2A_MAILGUN_APIKEY=env-api-key",
+		"SC2A_MAILGUN_DOMAIN=env.example.com",
+		"SC2A_SUBSCRIPTION_CHANNELS=beta, stable",
+		"SC2A_SUBSCRIBE_RATELIMIT=5",
+		"SC2A_SUBSCRIBE_CAPTCHA_SECRET=captcha",
+		// kubernetes service variables are not settings
+		"SC2A_PORT=tcp://10.0.0.1:8080",
+		"PATH=/usr/bin",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"config", "print"}, rest)
+	assert.Equal(t, "https://file.example.com", c.BaseURI)
+	assert.Equal(t, "env-api-key", c.Dist.Mailgun.APIKey)
+	assert.Equal(t, "flag.example.com", c.Dist.Mailgun.Domain)
+	assert.Equal(t, []string{"beta", "stable"}, c.Dist.Subscription.Channels)
+	assert.Equal(t, 5, c.Subscribe.RateLimit)
+	assert.Equal(t, "captcha", c.Subscribe.Captcha.Secret)
+	assert.Equal(t, "https://ui.example.com", c.CORS["api"].Origins)
+	assert.Equal(t, "/srv/release", c.Dist.DataDir)
+	assert.Equal(t, dist.DefaultDataFile, c.Dist.DataFile)
+
+	_, _, err = loadConfig([]string{"-config", p}, []string{"SC2A_SUBSCRIBE_RATELIMIT=lots"})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "SC2A_SUBSCRIBE_RATELIMIT")
+	}
+	_, _, err = loadConfig([]string{"-config", filepath.Join(dir, "missing.json")}, nil)
+	assert.NotNil(t, err)
+	_, _, err = loadConfig([]string{"-nosuchflag", "x"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sc2a-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	apiKey := writeConfigFile(t, dir, "mailgun-apikey", "file-api-key\n")
+	jwtKey := writeConfigFile(t, dir, "jwtkey", "  file-jwt-key\n")
+
+	c, _, err := loadConfig([]string{"-jwtkey-file", jwtKey}, []string{"SC2A_MAILGUN_APIKEY_FILE=" + apiKey})
+	assert.Nil(t, err)
+	assert.Equal(t, "file-api-key", c.Dist.Mailgun.APIKey)
+	assert.Equal(t, "file-jwt-key", c.JWTKey)
+
+	// files are read again on every load, e.g. on SIGHUP
+	writeConfigFile(t, dir, "mailgun-apikey", "rotated-api-key")
+	c, _, err = loadConfig(nil, []string{"SC2A_MAILGUN_APIKEY_FILE=" + apiKey})
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated-api-key", c.Dist.Mailgun.APIKey)
+
+	for _, environ := range [][]string{
+		{"SC2A_MAILGUN_APIKEY=env-api-key", "SC2A_MAILGUN_APIKEY_FILE=" + apiKey},
+		{"SC2A_MAILGUN_APIKEY_FILE=" + filepath.Join(dir, "missing")},
+	} {
+		_, _, err = loadConfig(nil, environ)
+		if assert.NotNil(t, err, "%v", environ) {
+			assert.Contains(t, err.Error(), "SC2A_MAILGUN_APIKEY")
+		}
+	}
+	// only secrets are read from files
+	_, _, err = loadConfig([]string{"-mailgun.domain-file", apiKey}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestConfigFileFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sc2a-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"config.yaml": "BaseURI: https://sc2a.example.com\nDist:\n  Mailgun:\n    Domain: mg.example.com\n  Subscription:\n    ConfirmTTL: 24h\n    Channels: [beta]\n",
+		"config.toml": "BaseURI = \"https://sc2a.example.com\"\n[Dist.Mailgun]\nDomain = \"mg.example.com\"\n[Dist.Subscription]\nConfirmTTL = \"24h\"\nChannels = [\"beta\"]\n",
+		"config.json": `{"BaseURI": "https://sc2a.example.com", "Dist": {"Mailgun": {"Domain": "mg.example.com"}, "Subscription": {"ConfirmTTL": "24h", "Channels": ["beta"]}}}`,
+	} {
+		c, _, err := loadConfig([]string{"-config", writeConfigFile(t, dir, name, content)}, nil)
+		assert.Nil(t, err, name)
+		assert.Equal(t, "https://sc2a.example.com", c.BaseURI, name)
+		assert.Equal(t, "mg.example.com", c.Dist.Mailgun.Domain, name)
+		assert.Equal(t, "24h", c.Dist.Subscription.ConfirmTTL, name)
+		assert.Equal(t, []string{"beta"}, c.Dist.Subscription.Channels, name)
+	}
+
+	for name, content := range map[string]string{
+		"typo.yaml":   "BaseURL: https://sc2a.example.com\n",
+		"typo.json":   `{"Dist": {"Mailgun": {"Key": "x"}}}`,
+		"broken.toml": "BaseURI = \n",
+		"config.ini":  "BaseURI=https://sc2a.example.com\n",
+	} {
+		_, _, err := loadConfig([]string{"-config", writeConfigFile(t, dir, name, content)}, nil)
+		if assert.NotNil(t, err, name) {
+			assert.Contains(t, err.Error(), name)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	err := validateConfig(defaultConfig())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "BaseURI: is required")
+		assert.Contains(t, err.Error(), "JWTKey: is required")
+	}
+
+	c := defaultConfig()
+	c.BaseURI = "https://sc2a.example.com"
+	c.JWTKey = "key"
+	assert.Nil(t, validateConfig(c))
+
+	for _, change := range []func(c *Config){
+		func(c *Config) { c.BaseURI = "https://sc2a.example.com/" },
+		func(c *Config) { c.BaseURI = "sc2a.example.com" },
+		func(c *Config) { c.Dist.DataDir = "" },
+		func(c *Config) { c.Dist.Compression = "lzma" },
+		func(c *Config) { c.Dist.FilenameTemplate = "{{.Version" },
+		func(c *Config) { c.CORS = map[string]CORSConfig{"admin": {}} },
+		func(c *Config) { c.OIDC.Issuer = "https://accounts.example.com" },
+		func(c *Config) { c.JWTSigningKey = "2016-11" },
+		func(c *Config) { c.TrustedProxies = []string{"load-balancer"} },
+	} {
+		invalid := c
+		change(&invalid)
+		assert.NotNil(t, validateConfig(invalid))
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	c := defaultConfig()
+	c.JWTKey = "jwt-key"
+	c.OIDC.ClientSecret = "client-secret"
+	c.Dist.Mailgun.Domain = "mg.example.com"
+	c.Dist.Mailgun.APIKey = "api-key"
+	c.Dist.Encryption.PreviousKeys = []string{"old-key"}
+	c.JWTKeys = map[string]string{"2016-11": "new-jwt-key"}
+
+	redacted := redactConfig(c)
+	assert.Equal(t, dist.ConfigRedacted, redacted.JWTKey)
+	assert.Equal(t, dist.ConfigRedacted, redacted.OIDC.ClientSecret)
+	assert.Equal(t, dist.ConfigRedacted, redacted.Dist.Mailgun.APIKey)
+	assert.Equal(t, []string{dist.ConfigRedacted}, redacted.Dist.Encryption.PreviousKeys)
+	assert.Equal(t, map[string]string{"2016-11": dist.ConfigRedacted}, redacted.JWTKeys)
+	assert.Equal(t, "mg.example.com", redacted.Dist.Mailgun.Domain)
+	// unset secrets stay empty, and c is unchanged
+	assert.Equal(t, "", redacted.Password)
+	assert.Equal(t, "api-key", c.Dist.Mailgun.APIKey)
+	assert.Equal(t, []string{"old-key"}, c.Dist.Encryption.PreviousKeys)
+	assert.Equal(t, "new-jwt-key", c.JWTKeys["2016-11"])
+
+	j, err := json.Marshal(redacted)
+	assert.Nil(t, err)
+	for _, secret := range []string{"jwt-key", "client-secret", "api-key", "old-key", "new-jwt-key"} {
+		assert.False(t, strings.Contains(string(j), secret), secret)
+	}
+}
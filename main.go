@@ -1,11 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -14,17 +19,39 @@ import (
 	"github.com/DreamHacks/sc2a-service/dist"
 	assets "github.com/DreamHacks/sc2a-service/ui"
 	"github.com/gin-gonic/gin"
-	"github.com/itsjamie/gin-cors"
-	"gopkg.in/appleboy/gin-jwt.v2"
 )
 
 // Config is the app config
 type Config struct {
-	BaseURI  string
-	JWTKey   string
-	User     string
-	Password string
-	Dist     dist.Config
+	BaseURI string
+	// JWTKey signs admin tokens, or only verifies tokens without a key id once JWTSigningKey is set
+	JWTKey string `secret:"true"`
+	// JWTKeys verify admin tokens by key id, JWTKeyFiles reads keys by id from files.
+	// A new key is added here first, then made JWTSigningKey, and removed after tokens of the old key expired.
+	JWTKeys     map[string]string `secret:"true"`
+	JWTKeyFiles map[string]string
+	// JWTSigningKey is the id of the key new admin tokens are signed with
+	JWTSigningKey string
+	// User and Password create the first admin account if there is none yet, they are not used afterwards
+	User      string
+	Password  string `secret:"true"`
+	Subscribe SubscribeConfig
+	// OIDC signs admins in with an identity provider when its Issuer is set
+	OIDC OIDCConfig
+	// CORS configures cross origin requests by route group: "api", "login" or "public"
+	CORS            map[string]CORSConfig
+	SecurityHeaders SecurityHeadersConfig
+	// TrustedProxies are the IPs or CIDRs whose X-Forwarded-For header names the client IP,
+	// e.g. the load balancer. Without them the client IP is the connecting address.
+	TrustedProxies []string
+	Dist           dist.Config
+}
+
+// SubscribeConfig protects the public subscription endpoint
+type SubscribeConfig struct {
+	// RateLimit is the number of subscription requests allowed per client IP per hour
+	RateLimit int
+	Captcha   CaptchaConfig
 }
 
 var configLock sync.RWMutex
@@ -32,17 +59,6 @@ var configLock sync.RWMutex
 var config Config
 var nameTemplate *template.Template
 
-func init() {
-	f, err := os.Open("./data/config.json")
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = json.NewDecoder(f).Decode(&config)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
 func useErrorHandler(g *gin.RouterGroup) {
 	g.Use(func(c *gin.Context) {
 		c.Next()
@@ -56,76 +72,235 @@ func useErrorHandler(g *gin.RouterGroup) {
 	})
 }
 
+// reportError adds err to the context, with the status and code of a *dist.ValidationError
+func reportError(c *gin.Context, err error) {
+	if verr, ok := err.(*dist.ValidationError); ok {
+		c.Status(verr.Status)
+		c.Error(err).SetMeta(gin.H{"code": verr.Code, "message": verr.Message})
+		return
+	}
+	c.Error(err)
+}
+
+// uploadHeadroom allows for the multipart framing and form fields sent along with a release file
+const uploadHeadroom = 1 << 20
+
+// parseUpload reads a release upload, bodies over maxSize plus uploadHeadroom are rejected while
+// they stream instead of after they were written to disk
+func parseUpload(c *gin.Context, maxSize int64) bool {
+	if maxSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+uploadHeadroom)
+	}
+	err := c.Request.ParseMultipartForm(32 << 20)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.Status(http.StatusRequestEntityTooLarge)
+		message := fmt.Sprintf("uploaded file exceeds %d bytes", maxSize)
+		c.Error(errors.New(message)).SetMeta(gin.H{"code": "file_too_large", "message": message})
+		return false
+	}
+	return true
+}
+
 func useAuth(r *gin.Engine, g *gin.RouterGroup) {
-	authMiddleware := &jwt.GinJWTMiddleware{
-		Realm:      "auth required",
-		Key:        []byte(config.JWTKey),
-		Timeout:    time.Hour,
-		MaxRefresh: time.Hour * 24,
-		Authenticator: func(userId string, password string, c *gin.Context) (string, bool) {
-			if userId == config.User && password == config.Password {
-				return userId, true
-			}
+	g.Use(apiTokenOr(tokenMiddleware))
+	g.GET("/token", refreshHandler)
+	r.POST("/login", loginHandler)
 
-			return userId, false
-		},
-		Authorizator: func(userId string, c *gin.Context) bool {
-			return true
-		},
-		Unauthorized: func(c *gin.Context, code int, message string) {
-			c.JSON(code, gin.H{
-				"code":    code,
-				"message": message,
-			})
-		},
+	if config.OIDC.Issuer != "" {
+		l, err := newOIDCLogin(context.Background(), config.OIDC, config.BaseURI+"/login/oidc/callback")
+		if err != nil {
+			log.Fatal(err)
+		}
+		l.issue = func(username string) (string, time.Time, error) {
+			return issueToken(username, time.Now())
+		}
+		l.cookieKey = func() []byte {
+			return jwtKeys.derive("oidc sign in")
+		}
+		l.provision = func(username string, roles []string) error {
+			_, err := dist.ProvisionAdmin(username, config.OIDC.Issuer, roles)
+			return err
+		}
+		oidcSignIn = l
+		r.GET("/login/oidc", l.start)
+		r.GET("/login/oidc/callback", l.callback)
 	}
-	g.Use(authMiddleware.MiddlewareFunc())
-	g.GET("/token", authMiddleware.RefreshHandler)
-	r.POST("/login", authMiddleware.LoginHandler)
 }
 
 func main() {
+	c, args, err := loadConfig(os.Args[1:], os.Environ())
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	config = c
+	if len(args) > 0 && commands[args[0]].Offline {
+		runCommand(args[0], args[1:])
+		return
+	}
+	if err := validateConfig(config); err != nil {
+		log.Fatal(err)
+	}
+
 	dist.Configure(config.BaseURI, config.Dist)
 	dist.OpenDB()
 	defer dist.CloseDB()
 
+	if len(args) > 0 {
+		runCommand(args[0], args[1:])
+		return
+	}
+
+	if config.User != "" && config.Password != "" {
+		admin, err := dist.BootstrapAdmin(config.User, config.Password)
+		if _, invalid := err.(*dist.ValidationError); invalid {
+			log.Printf("admin %s from config was not created: %s", config.User, err.Error())
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		if admin != nil {
+			log.Printf("created admin %s from config, Password can now be removed from the config", admin.Username)
+		}
+	}
+	if n, err := dist.CountAdmins(); err != nil {
+		log.Fatal(err)
+	} else if n == 0 {
+		log.Printf("there are no admin accounts yet, create one with `%s create-admin <username>`", os.Args[0])
+	}
+
+	if err := jwtKeys.reload(config); err != nil {
+		log.Fatal(err)
+	}
+	go reloadOnSIGHUP()
+
+	go func() {
+		for {
+			n, err := dist.PurgeUnconfirmed()
+			if err != nil {
+				log.Printf("purge unconfirmed: %s", err.Error())
+			} else if n > 0 {
+				log.Printf("purge unconfirmed: removed %d subscribers", n)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	newRouter().Run()
+}
+
+// reloadConfig reads the config again and switches to its secrets: the jwt keys, the Mailgun keys,
+// Subscribe.Captcha and OIDC.ClientSecret, and to the dist settings that can be changed while
+// the service runs. Other settings need a restart.
+func reloadConfig(args []string, environ []string) error {
+	c, _, err := loadConfig(args, environ)
+	if err != nil {
+		return err
+	}
+	if err := validateConfig(c); err != nil {
+		return err
+	}
+	if err := dist.ReloadConfig(c.Dist); err != nil {
+		return err
+	}
+	if err := jwtKeys.reload(c); err != nil {
+		return err
+	}
+	configureCaptcha(c.Subscribe.Captcha)
+	if oidcSignIn != nil {
+		oidcSignIn.setClientSecret(c.OIDC.ClientSecret)
+	}
+	return nil
+}
+
+// reloadOnSIGHUP reloads the config on SIGHUP, e.g. after a mounted secret was rotated
+func reloadOnSIGHUP() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if err := reloadConfig(os.Args[1:], os.Environ()); err != nil {
+			log.Printf("reload: %s, keeping the current config", err.Error())
+			continue
+		}
+		log.Printf("reload: switched to the reloaded secrets and settings")
+	}
+}
+
+// newRouter sets up all routes
+func newRouter() *gin.Engine {
 	r := gin.Default()
+	// rate limits and the audit log go by the client IP, it must not be spoofable
+	if err := r.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
 
-	r.Use(cors.Middleware(cors.Config{
-		Origins:         "*",
-		Methods:         "GET, PUT, POST, DELETE",
-		RequestHeaders:  "Origin, Authorization, Content-Type",
-		ExposedHeaders:  "",
-		MaxAge:          50 * time.Second,
-		Credentials:     true,
-		ValidateHeaders: false,
-	}))
+	corsConfig, err := corsConfigs(config.CORS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	useCORS(r, corsConfig)
+	useSecurityHeaders(r, config.SecurityHeaders, strings.HasPrefix(config.BaseURI, "https://"))
 
 	api := r.Group("/api")
 
 	useErrorHandler(api)
 	useAuth(r, api)
+	useAudit(api)
+	useAdmins(api)
+	useAPITokens(api)
+	useAuditLog(api)
+	useConfig(api)
+	useTemplates(api)
 
 	api.GET("/release", func(c *gin.Context) {
-		list, err := dist.List()
+		opts, ok := pageOptions(c)
+		if !ok {
+			return
+		}
+		list, info, err := dist.ListPage(opts)
 		if err != nil {
-			c.Error(err)
+			reportError(c, err)
 			return
 		}
+		setPageHeaders(c, info)
 		c.JSON(http.StatusOK, list)
 	})
 
 	api.POST("/release", func(c *gin.Context) {
+		if !parseUpload(c, config.Dist.Upload.MaxSize) {
+			return
+		}
 		req := c.Request
 		r := dist.Release{
-			Version:     req.FormValue("Version"),
-			Description: req.FormValue("Description"),
+			Version:      req.FormValue("Version"),
+			Description:  req.FormValue("Description"),
+			Descriptions: map[string]string{},
+		}
+		// localized descriptions are posted as e.g. "Description.zh"
+		if req.MultipartForm != nil {
+			for k, v := range req.MultipartForm.Value {
+				if strings.HasPrefix(k, "Description.") && len(v) > 0 {
+					r.Descriptions[strings.TrimPrefix(k, "Description.")] = v[0]
+				}
+			}
 		}
 		if r.Version == "" {
 			c.Status(http.StatusBadRequest)
 			c.Error(errors.New("Version is required"))
 			return
 		}
+		segment := req.FormValue("Segment")
+		if segment != "" {
+			if _, err := dist.GetSegment(segment); err != nil {
+				if err == dist.ErrSegmentNotFound {
+					c.Status(http.StatusBadRequest)
+				}
+				c.Error(err)
+				return
+			}
+		}
 		f, _, err := req.FormFile("File")
 		if err != nil {
 			c.Status(http.StatusBadRequest)
@@ -139,11 +314,16 @@ func main() {
 
 		published, err := dist.Publish(r, f)
 		if err != nil {
-			c.Error(err)
+			reportError(c, err)
 			return
 		}
+		auditCreated(c, published.ID)
 
-		err = dist.NotifyAll(*published)
+		if segment != "" {
+			err = dist.NotifySegment(*published, segment)
+		} else {
+			err = dist.NotifyAll(*published)
+		}
 		if err != nil {
 			c.Error(err)
 			return
@@ -152,6 +332,40 @@ func main() {
 		c.JSON(http.StatusOK, published)
 	})
 
+	api.POST("/release/:id/notify", func(c *gin.Context) {
+		req := struct {
+			Segment string
+		}{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		r, err := dist.Get(c.Param("id"))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if r == nil {
+			c.Status(http.StatusNotFound)
+			c.Error(errors.New("release was not found"))
+			return
+		}
+		if req.Segment != "" {
+			err = dist.NotifySegment(*r, req.Segment)
+		} else {
+			err = dist.NotifyAll(*r)
+		}
+		if err != nil {
+			if err == dist.ErrSegmentNotFound {
+				c.Status(http.StatusBadRequest)
+			}
+			c.Error(err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
 	api.GET("/release/:id", func(c *gin.Context) {
 		id := c.Param("id")
 		r, err := dist.Get(id)
@@ -172,12 +386,77 @@ func main() {
 		c.Status(http.StatusNoContent)
 	})
 
-	api.GET("/sub", func(c *gin.Context) {
-		list, err := dist.ListSubs()
+	api.GET("/quarantine", func(c *gin.Context) {
+		list, err := dist.ListQuarantined()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	api.DELETE("/quarantine/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		err := dist.DeleteQuarantined(id)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	api.POST("/keys/rotate", func(c *gin.Context) {
+		key, err := dist.RotateSigningKey()
 		if err != nil {
 			c.Error(err)
 			return
 		}
+		auditCreated(c, key.ID)
+		c.JSON(http.StatusOK, key)
+	})
+
+	api.DELETE("/keys/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		err := dist.RevokeSigningKey(id)
+		if err != nil {
+			switch err {
+			case dist.ErrSigningKeyNotFound:
+				c.Status(http.StatusNotFound)
+			case dist.ErrRevokeCurrentKey:
+				c.Status(http.StatusBadRequest)
+			}
+			c.Error(err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	api.GET("/sub", func(c *gin.Context) {
+		if email := c.Query("email"); email != "" {
+			sub, err := dist.FindSubByEmail(email)
+			if err != nil {
+				switch err {
+				case dist.ErrSubNotFound:
+					c.Status(http.StatusNotFound)
+				case dist.ErrInvalidEmail:
+					c.Status(http.StatusBadRequest)
+				}
+				c.Error(err)
+				return
+			}
+			c.JSON(http.StatusOK, sub)
+			return
+		}
+		opts, ok := pageOptions(c)
+		if !ok {
+			return
+		}
+		list, info, err := dist.ListSubsPage(opts)
+		if err != nil {
+			reportError(c, err)
+			return
+		}
+		setPageHeaders(c, info)
 		c.JSON(http.StatusOK, list)
 	})
 
@@ -191,33 +470,138 @@ func main() {
 		}
 		created, err := dist.Subscribe(sub)
 		if err != nil {
-			c.Error(err)
+			switch err {
+			case dist.ErrInvalidEmail:
+				c.Status(http.StatusBadRequest)
+			case dist.ErrEmailExists:
+				c.Status(http.StatusConflict)
+			}
+			reportError(c, err)
 			return
 		}
+		auditCreated(c, created.ID)
 		c.JSON(http.StatusOK, created)
 	})
 
 	api.PUT("/sub", func(c *gin.Context) {
-		sub := dist.Sub{}
+		sub := dist.SubUpdate{}
 		err := c.BindJSON(&sub)
 		if err != nil {
 			c.Status(http.StatusBadRequest)
 			c.Error(err)
 			return
 		}
-		updated, err := dist.UpdateSubscriber(sub)
+		auditTarget(c, sub.ID)
+		updated, err := dist.UpdateSub(sub)
 		if err != nil {
-			if err == dist.ErrSubNotFound {
+			switch err {
+			case dist.ErrSubNotFound, dist.ErrInvalidEmail:
 				c.Status(http.StatusBadRequest)
+			case dist.ErrEmailExists:
+				c.Status(http.StatusConflict)
 			}
-			c.Error(err)
+			reportError(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, updated)
 	})
 
+	api.POST("/sub/import", func(c *gin.Context) {
+		format := c.Query("format")
+		if format == "" {
+			format = dist.FormatJSON
+			if strings.HasPrefix(c.ContentType(), "text/csv") {
+				format = dist.FormatCSV
+			}
+		}
+		result, err := dist.ImportSubs(c.Request.Body, format, dist.ImportOptions{
+			DryRun:     c.Query("dry_run") == "true",
+			Duplicates: c.Query("duplicates"),
+		})
+		if err != nil {
+			reportError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	api.GET("/sub/export", func(c *gin.Context) {
+		format := c.DefaultQuery("format", dist.FormatJSON)
+		switch format {
+		case dist.FormatCSV:
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+		case dist.FormatJSON:
+			c.Header("Content-Type", "application/x-ndjson")
+		default:
+			c.Status(http.StatusBadRequest)
+			c.Error(errors.New("format must be csv or json"))
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=subscribers."+format)
+		c.Status(http.StatusOK)
+		if err := dist.ExportSubs(c.Writer, format); err != nil {
+			log.Printf("export subscribers: %s", err.Error())
+		}
+	})
+
+	api.PUT("/sub/:id/status", func(c *gin.Context) {
+		req := struct {
+			Status string
+		}{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		sub, err := dist.SetSubStatus(c.Param("id"), req.Status)
+		if err != nil {
+			switch err {
+			case dist.ErrSubNotFound:
+				c.Status(http.StatusNotFound)
+			case dist.ErrInvalidStatus:
+				c.Status(http.StatusBadRequest)
+			}
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, sub)
+	})
+
+	api.GET("/sub/:id/data", func(c *gin.Context) {
+		data, err := dist.GetSubData(c.Param("id"))
+		if err != nil {
+			if err == dist.ErrSubNotFound {
+				c.Status(http.StatusNotFound)
+			}
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, data)
+	})
+
+	api.GET("/erasure", func(c *gin.Context) {
+		list, err := dist.ListTombstones()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
 	api.DELETE("/sub/:id", func(c *gin.Context) {
 		id := c.Param("id")
+		if c.Query("erase") == "true" {
+			tombstone, err := dist.EraseSub(id)
+			if err != nil {
+				if err == dist.ErrSubNotFound {
+					c.Status(http.StatusNotFound)
+				}
+				c.Error(err)
+				return
+			}
+			c.JSON(http.StatusOK, tombstone)
+			return
+		}
 		err := dist.Unsubscribe(id)
 		if err != nil {
 			c.Error(err)
@@ -226,6 +610,75 @@ func main() {
 		c.Status(http.StatusNoContent)
 	})
 
+	api.GET("/segment", func(c *gin.Context) {
+		list, err := dist.ListSegments()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	saveSegment := func(c *gin.Context) {
+		s := dist.Segment{}
+		if err := c.BindJSON(&s); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		if c.Request.Method == "POST" {
+			s.ID = ""
+		} else {
+			auditTarget(c, s.ID)
+		}
+		saved, err := dist.SaveSegment(s)
+		if err != nil {
+			if err == dist.ErrSegmentNotFound {
+				c.Status(http.StatusBadRequest)
+			}
+			reportError(c, err)
+			return
+		}
+		auditCreated(c, saved.ID)
+		c.JSON(http.StatusOK, saved)
+	}
+	api.POST("/segment", saveSegment)
+	api.PUT("/segment", saveSegment)
+
+	api.DELETE("/segment/:id", func(c *gin.Context) {
+		if err := dist.DeleteSegment(c.Param("id")); err != nil {
+			c.Error(err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	api.GET("/segment/:id/subs", func(c *gin.Context) {
+		s, err := dist.GetSegment(c.Param("id"))
+		if err != nil {
+			if err == dist.ErrSegmentNotFound {
+				c.Status(http.StatusNotFound)
+			}
+			c.Error(err)
+			return
+		}
+		list, err := dist.SegmentSubs(*s)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	api.GET("/unsubscribe", func(c *gin.Context) {
+		report, err := dist.GetUnsubscribeReport()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
 	api.GET("/sub/:id/stats", func(c *gin.Context) {
 		id := c.Param("id")
 		stats, err := dist.GetSubDowloadStats(id)
@@ -240,6 +693,8 @@ func main() {
 
 	r.GET("/download/:id", func(c *gin.Context) {
 		id := c.Param("id")
+		detached := strings.HasSuffix(id, ".sig")
+		id = strings.TrimSuffix(id, ".sig")
 		link, err := dist.GetLink(id)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
@@ -255,18 +710,137 @@ func main() {
 			c.String(http.StatusNotFound, err.Error())
 			return
 		}
+		if detached {
+			if len(release.Signature) == 0 {
+				c.String(http.StatusNotFound, "release is not signed")
+				return
+			}
+			c.Header("Content-Disposition", "attachment; filename="+release.FileName()+".sig")
+			c.Header("X-Signature-Key-Id", release.SignatureKeyID)
+			c.Data(http.StatusOK, "application/octet-stream", release.Signature)
+			return
+		}
 		c.Header("Content-Disposition", "attachment; filename="+release.FileName())
 		c.Header("Content-Type", "application/octet-stream")
-		err = dist.StreamLink(id, c.Writer)
+		c.Header("Vary", "Accept-Encoding")
+		if release.Compression != "" && dist.AcceptsEncoding(c.Request.Header.Get("Accept-Encoding"), release.Compression) {
+			c.Header("Content-Encoding", release.Compression)
+			err = dist.StreamLinkRaw(id, c.Writer)
+		} else {
+			err = dist.StreamLink(id, c.Writer)
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	})
+
+	r.GET("/keys", func(c *gin.Context) {
+		keys, err := dist.PublicKeys()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, keys)
+	})
+
+	r.GET("/manifest", func(c *gin.Context) {
+		m, err := dist.Manifest()
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
+		c.JSON(http.StatusOK, m)
+	})
+
+	rateLimit := config.Subscribe.RateLimit
+	if rateLimit <= 0 {
+		rateLimit = 5
+	}
+	subscribeLimiter := newRateLimiter(rateLimit, time.Hour)
+	configureCaptcha(config.Subscribe.Captcha)
+
+	r.POST("/subscribe", subscribeLimiter.Middleware(), func(c *gin.Context) {
+		form := struct {
+			Name    string
+			Email   string
+			Website string // honeypot, left empty by humans
+			Captcha string
+			Locale  string
+		}{}
+		if err := c.Bind(&form); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": err.Error()})
+			return
+		}
+		accepted := gin.H{"message": "Please check your inbox to confirm your subscription"}
+		if form.Website != "" {
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+		ok, err := verifyCaptcha(form.Captcha, c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"code": http.StatusBadGateway, "message": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": "CAPTCHA verification failed"})
+			return
+		}
+		err = dist.RequestSubscription(dist.Sub{Name: form.Name, Email: form.Email, Locale: form.Locale})
+		if err != nil {
+			code := http.StatusInternalServerError
+			if err == dist.ErrInvalidEmail {
+				code = http.StatusBadRequest
+			}
+			if verr, ok := err.(*dist.ValidationError); ok {
+				code = verr.Status
+			}
+			c.JSON(code, gin.H{"code": code, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, accepted)
+	})
+
+	r.GET("/subscribe/confirm", func(c *gin.Context) {
+		_, err := dist.ConfirmSubscription(c.Query("token"))
+		if err != nil {
+			if err == dist.ErrConfirmTokenInvalid {
+				renderPage(c, http.StatusNotFound, "Confirmation failed", err.Error())
+				return
+			}
+			renderPage(c, http.StatusInternalServerError, "Confirmation failed", err.Error())
+			return
+		}
+		renderPage(c, http.StatusOK, "Subscription confirmed", "You will be notified about new SC2Advanced releases.")
+	})
+
+	r.POST("/webhook/mailgun", handleMailgunWebhook)
+
+	usePreferences(r)
+
+	r.GET("/unsubscribe", func(c *gin.Context) {
+		renderUnsubscribePage(c)
+	})
+
+	r.POST("/unsubscribe", func(c *gin.Context) {
+		method := dist.UnsubscribePage
+		if c.PostForm("List-Unsubscribe") == "One-Click" {
+			method = dist.UnsubscribeOneClick
+		}
+		_, err := dist.UnsubscribeByToken(c.Query("token"), method, c.PostForm("Reason"))
+		switch err {
+		case nil, dist.ErrSubNotFound:
+			renderPage(c, http.StatusOK, "Unsubscribed", "You have been unsubscribed from SC2Advanced release notifications.")
+		case dist.ErrLinkTokenInvalid:
+			renderPage(c, http.StatusNotFound, "Unsubscribe failed", err.Error())
+		default:
+			renderPage(c, http.StatusInternalServerError, "Unsubscribe failed", err.Error())
+		}
 	})
 
 	r.GET("/", func(c *gin.Context) {
 		c.Redirect(http.StatusTemporaryRedirect, "/ui")
 	})
 
-	r.Run()
+	return r
 }
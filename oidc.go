@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig enables signing in to the admin ui with an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string `secret:"true"`
+	// RedirectURL defaults to BaseURI + "/login/oidc/callback"
+	RedirectURL string
+	// Scopes are requested besides openid, defaults to email and profile
+	Scopes []string
+	// GroupsClaim names the id token claim listing the user's groups, defaults to "groups"
+	GroupsClaim string
+	// EmailRoles maps email addresses, or domains such as "@example.com", to admin roles
+	EmailRoles map[string][]string
+	// GroupRoles maps groups to admin roles
+	GroupRoles map[string][]string
+}
+
+// roles returns the admin roles of a user, none if they may not sign in
+func (c OIDCConfig) roles(email string, groups []string) []string {
+	set := map[string]bool{}
+	email = strings.ToLower(email)
+	for k, roles := range c.EmailRoles {
+		k = strings.ToLower(k)
+		if k == email || (strings.HasPrefix(k, "@") && strings.HasSuffix(email, k)) {
+			for _, role := range roles {
+				set[role] = true
+			}
+		}
+	}
+	for _, g := range groups {
+		for _, role := range c.GroupRoles[g] {
+			set[role] = true
+		}
+	}
+	rv := []string{}
+	for role := range set {
+		rv = append(rv, role)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func (c OIDCConfig) validate() error {
+	known := map[string]bool{}
+	for _, role := range dist.Roles() {
+		known[role] = true
+	}
+	for _, mapping := range []map[string][]string{c.EmailRoles, c.GroupRoles} {
+		for k, roles := range mapping {
+			for _, role := range roles {
+				if !known[role] {
+					return fmt.Errorf("OIDC: unknown role %q for %q", role, k)
+				}
+			}
+		}
+	}
+	if c.ClientID == "" {
+		return fmt.Errorf("OIDC: ClientID is required")
+	}
+	return nil
+}
+
+const (
+	// oidcLoginTTL is how long a sign in may take at the provider
+	oidcLoginTTL = 10 * time.Minute
+	// oidcCookie holds the pending sign in in the browser that started it, so no other browser
+	// can complete it and any replica can
+	oidcCookie = "sc2a_oidc"
+)
+
+type oidcPending struct {
+	State    string
+	Verifier string
+	Nonce    string
+	Expires  time.Time
+}
+
+// oidcLogin runs the authorization code flow with PKCE and issues the service jwt
+type oidcLogin struct {
+	config   OIDCConfig
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	// issue returns the service jwt of an admin
+	issue func(username string) (token string, expire time.Time, err error)
+	// provision creates or updates the admin account of a user
+	provision func(username string, roles []string) error
+	// cookieKey signs the sign in cookie, replicas must share it
+	cookieKey    func() []byte
+	secureCookie bool
+
+	// mu guards oauth
+	mu sync.Mutex
+}
+
+// oidcSignIn is the identity provider sign in, nil unless OIDC.Issuer is set
+var oidcSignIn *oidcLogin
+
+// oauthConfig returns the oauth2 config, it is replaced when the client secret is reloaded
+func (l *oidcLogin) oauthConfig() *oauth2.Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.oauth
+}
+
+func (l *oidcLogin) setClientSecret(secret string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	oauth := *l.oauth
+	oauth.ClientSecret = secret
+	l.oauth = &oauth
+}
+
+func newOIDCLogin(ctx context.Context, c OIDCConfig, redirectURL string) (*oidcLogin, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	provider, err := oidc.NewProvider(ctx, c.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC: %s", err.Error())
+	}
+	if c.RedirectURL != "" {
+		redirectURL = c.RedirectURL
+	}
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	return &oidcLogin{
+		config: c,
+		oauth: &oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier:     provider.Verifier(&oidc.Config{ClientID: c.ClientID}),
+		secureCookie: strings.HasPrefix(redirectURL, "https://"),
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge is the S256 code challenge of a verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (l *oidcLogin) pendingMAC(payload string) string {
+	mac := hmac.New(sha256.New, l.cookieKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (l *oidcLogin) setCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     "/login/oidc",
+		MaxAge:   maxAge,
+		Secure:   l.secureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// putPending signs a pending sign in into the cookie
+func (l *oidcLogin) putPending(c *gin.Context, p oidcPending) error {
+	j, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(j)
+	l.setCookie(c, payload+"."+l.pendingMAC(payload), int(oidcLoginTTL/time.Second))
+	return nil
+}
+
+// takePending returns the pending sign in of the cookie if it started with state, the cookie is cleared
+func (l *oidcLogin) takePending(c *gin.Context, state string) (p oidcPending, ok bool) {
+	v, err := c.Cookie(oidcCookie)
+	if err != nil {
+		return p, false
+	}
+	l.setCookie(c, "", -1)
+	i := strings.LastIndex(v, ".")
+	if i < 0 || !hmac.Equal([]byte(l.pendingMAC(v[:i])), []byte(v[i+1:])) {
+		return p, false
+	}
+	j, err := base64.RawURLEncoding.DecodeString(v[:i])
+	if err != nil || json.Unmarshal(j, &p) != nil {
+		return p, false
+	}
+	return p, state != "" && hmac.Equal([]byte(p.State), []byte(state)) && time.Now().Before(p.Expires)
+}
+
+func wantsJSON(c *gin.Context) bool {
+	return strings.Contains(c.Request.Header.Get("Accept"), "application/json")
+}
+
+func (l *oidcLogin) fail(c *gin.Context, code int, message string) {
+	if wantsJSON(c) {
+		c.JSON(code, gin.H{"code": code, "message": message})
+		return
+	}
+	renderPage(c, code, "Sign in failed", message)
+}
+
+// start redirects to the provider
+func (l *oidcLogin) start(c *gin.Context) {
+	p := oidcPending{Expires: time.Now().Add(oidcLoginTTL)}
+	var err error
+	for _, v := range []*string{&p.State, &p.Verifier, &p.Nonce} {
+		if *v, err = randomString(); err != nil {
+			l.fail(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := l.putPending(c, p); err != nil {
+		l.fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Redirect(http.StatusFound, l.oauthConfig().AuthCodeURL(p.State,
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(p.Verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oidc.Nonce(p.Nonce),
+	))
+}
+
+// claimStrings reads a claim that is a string or a list of strings
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		rv := []string{}
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				rv = append(rv, s)
+			}
+		}
+		return rv
+	}
+	return nil
+}
+
+// callback exchanges the code, checks the id token and signs the admin in
+func (l *oidcLogin) callback(c *gin.Context) {
+	if e := c.Query("error"); e != "" {
+		l.fail(c, http.StatusUnauthorized, strings.TrimSpace(e+": "+c.Query("error_description")))
+		return
+	}
+	p, ok := l.takePending(c, c.Query("state"))
+	if !ok {
+		l.fail(c, http.StatusBadRequest, "sign in has expired, please try again")
+		return
+	}
+	ctx := c.Request.Context()
+	token, err := l.oauthConfig().Exchange(ctx, c.Query("code"), oauth2.SetAuthURLParam("code_verifier", p.Verifier))
+	if err != nil {
+		log.Printf("oidc: exchange: %s", err.Error())
+		l.fail(c, http.StatusUnauthorized, "the identity provider did not accept the sign in")
+		return
+	}
+	raw, _ := token.Extra("id_token").(string)
+	idToken, err := l.verifier.Verify(ctx, raw)
+	if err != nil {
+		log.Printf("oidc: verify: %s", err.Error())
+		l.fail(c, http.StatusUnauthorized, "id token is invalid")
+		return
+	}
+	if idToken.Nonce != p.Nonce {
+		l.fail(c, http.StatusUnauthorized, "id token is invalid")
+		return
+	}
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		l.fail(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(email)
+	if verified, ok := claims["email_verified"].(bool); email == "" || (ok && !verified) {
+		l.fail(c, http.StatusForbidden, "a verified email address is required")
+		return
+	}
+	roles := l.config.roles(email, claimStrings(claims[l.config.GroupsClaim]))
+	if len(roles) == 0 {
+		l.fail(c, http.StatusForbidden, email+" has no admin role")
+		return
+	}
+	if err := l.provision(email, roles); err != nil {
+		code := http.StatusInternalServerError
+		if err == dist.ErrAdminProvider {
+			code = http.StatusForbidden
+		}
+		l.fail(c, code, err.Error())
+		return
+	}
+
+	jwt, expire, err := l.issue(email)
+	if err != nil {
+		l.fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if wantsJSON(c) {
+		c.JSON(http.StatusOK, gin.H{"token": jwt, "expire": expire.Format(time.RFC3339)})
+		return
+	}
+	renderSignInPage(c, jwt)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider issuing one code at a time
+type mockOIDCProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	// challenge is the PKCE challenge of the current code
+	challenge string
+	nonce     string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDCProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/auth",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &m.key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != "good-code" || pkceChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":   m.URL,
+			"aud":   "sc2a",
+			"sub":   "user-1",
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": m.nonce,
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		payload, _ := json.Marshal(claims)
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: m.key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := signer.Sign(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idToken, _ := obj.CompactSerialize()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+type oidcTest struct {
+	provider    *mockOIDCProvider
+	router      *gin.Engine
+	provisioned map[string][]string
+	// cookie is the sign in cookie of the browser
+	cookie *http.Cookie
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	gin.SetMode(gin.TestMode)
+	ot := &oidcTest{provider: newMockOIDCProvider(t), provisioned: map[string][]string{}}
+	l, err := newOIDCLogin(context.Background(), OIDCConfig{
+		Issuer:     ot.provider.URL,
+		ClientID:   "sc2a",
+		EmailRoles: map[string][]string{"@example.com": {dist.RoleViewer}, "boss@example.com": {dist.RoleAdmin}},
+		GroupRoles: map[string][]string{"release-team": {dist.RolePublisher}},
+	}, "http://sc2a.test/login/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.issue = func(username string) (string, time.Time, error) {
+		return "jwt-for-" + username, time.Now().Add(time.Hour), nil
+	}
+	l.cookieKey = func() []byte {
+		return []byte("oidc-test-key")
+	}
+	l.provision = func(username string, roles []string) error {
+		ot.provisioned[username] = roles
+		return nil
+	}
+	ot.router = gin.New()
+	ot.router.GET("/login/oidc", l.start)
+	ot.router.GET("/login/oidc/callback", l.callback)
+	return ot
+}
+
+// start begins a sign in and returns its state, as the provider would redirect back with it
+func (ot *oidcTest) start(t *testing.T) string {
+	w := httptest.NewRecorder()
+	ot.router.ServeHTTP(w, httptest.NewRequest("GET", "/login/oidc", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	ot.keepCookie(w)
+	if assert.NotNil(t, ot.cookie) {
+		assert.True(t, ot.cookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, ot.cookie.SameSite)
+	}
+	u, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	assert.Equal(t, ot.provider.URL+"/auth", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, "http://sc2a.test/login/oidc/callback", q.Get("redirect_uri"))
+	ot.provider.challenge = q.Get("code_challenge")
+	ot.provider.nonce = q.Get("nonce")
+	return q.Get("state")
+}
+
+// keepCookie stores or clears the sign in cookie like a browser would
+func (ot *oidcTest) keepCookie(w *httptest.ResponseRecorder) {
+	for _, c := range w.Result().Cookies() {
+		if c.Name != oidcCookie {
+			continue
+		}
+		ot.cookie = c
+		if c.MaxAge < 0 {
+			ot.cookie = nil
+		}
+	}
+}
+
+func (ot *oidcTest) callback(state, code string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/login/oidc/callback?state="+url.QueryEscape(state)+"&code="+code, nil)
+	req.Header.Set("Accept", "application/json")
+	if ot.cookie != nil {
+		req.AddCookie(ot.cookie)
+	}
+	ot.router.ServeHTTP(w, req)
+	ot.keepCookie(w)
+	return w
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ot := newOIDCTest(t)
+	defer ot.provider.Close()
+
+	ot.provider.claims = map[string]interface{}{"email": "Dana@example.com", "email_verified": true, "groups": []string{"release-team"}}
+	state := ot.start(t)
+	w := ot.callback(state, "good-code")
+	assert.Equal(t, http.StatusOK, w.Code)
+	rv := map[string]string{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &rv))
+	assert.Equal(t, "jwt-for-dana@example.com", rv["token"])
+	assert.Equal(t, []string{dist.RolePublisher, dist.RoleViewer}, ot.provisioned["dana@example.com"])
+
+	// the callback clears the cookie, so a state is used once
+	assert.Nil(t, ot.cookie)
+	w = ot.callback(state, "good-code")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestOIDCLoginCSRF(t *testing.T) {
+	ot := newOIDCTest(t)
+	defer ot.provider.Close()
+	ot.provider.claims = map[string]interface{}{"email": "dana@example.com", "email_verified": true}
+
+	// the state of a sign in started in another browser is refused
+	attacker := ot.start(t)
+	ot.cookie = nil
+	w := ot.callback(attacker, "good-code")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	ot.start(t)
+	w = ot.callback(attacker, "good-code")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, ot.provisioned)
+
+	// a tampered cookie is refused
+	state := ot.start(t)
+	ot.cookie.Value = "x" + ot.cookie.Value
+	w = ot.callback(state, "good-code")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, ot.provisioned)
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	ot := newOIDCTest(t)
+	defer ot.provider.Close()
+
+	// the provider checks the PKCE verifier along with the code
+	ot.provider.claims = map[string]interface{}{"email": "dana@example.com", "email_verified": true}
+	w := ot.callback(ot.start(t), "bad-code")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	state := ot.start(t)
+	ot.provider.challenge = pkceChallenge("another verifier")
+	w = ot.callback(state, "good-code")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	state = ot.start(t)
+	ot.provider.nonce = "replayed"
+	w = ot.callback(state, "good-code")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	ot.provider.claims = map[string]interface{}{"email": "dana@example.com", "email_verified": false}
+	w = ot.callback(ot.start(t), "good-code")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	ot.provider.claims = map[string]interface{}{"email": "eve@elsewhere.com", "email_verified": true}
+	w = ot.callback(ot.start(t), "good-code")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, ot.provisioned)
+
+	w = ot.callback("unknown", "good-code")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestOIDCRoles(t *testing.T) {
+	c := OIDCConfig{
+		EmailRoles: map[string][]string{"@Example.com": {dist.RoleViewer}, "boss@example.com": {dist.RoleAdmin}},
+		GroupRoles: map[string][]string{"subs": {dist.RoleSubscriberManager}},
+	}
+	assert.Equal(t, []string{dist.RoleAdmin, dist.RoleViewer}, c.roles("Boss@example.com", nil))
+	assert.Equal(t, []string{dist.RoleSubscriberManager}, c.roles("a@other.com", []string{"subs"}))
+	assert.Empty(t, c.roles("a@notexample.com", nil))
+
+	c.ClientID = "sc2a"
+	assert.Nil(t, c.validate())
+	c.GroupRoles["x"] = []string{"owner"}
+	assert.NotNil(t, c.validate())
+}
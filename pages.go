@@ -0,0 +1,110 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - SC2Advanced</title>
+<style>body{font-family:sans-serif;max-width:36em;margin:4em auto;padding:0 1em;color:#333}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{block "form" .}}{{end}}
+</body>
+</html>
+`))
+
+var unsubscribeTemplate = template.Must(template.Must(pageTemplate.Clone()).Parse(`{{define "form"}}
+<form method="post">
+<p>Would you tell us why?</p>
+<p>{{range .Reasons}}<label><input type="radio" name="Reason" value="{{.}}"> {{.}}</label><br>{{end}}</p>
+<p><button type="submit">Unsubscribe</button></p>
+</form>
+{{end}}`))
+
+// unsubscribeReasons are offered on the unsubscribe page
+var unsubscribeReasons = []string{
+	"I receive too many emails",
+	"I no longer use SC2Advanced",
+	"I never signed up",
+	"Other",
+}
+
+// signInTemplate hands a service jwt to the admin ui, which keeps it in local storage
+var signInTemplate = template.Must(template.Must(pageTemplate.Clone()).Parse(`{{define "form"}}
+<script>localStorage.setItem("token", {{.Token}}); location.replace("/ui");</script>
+<noscript><p>Please enable JavaScript to use the admin ui.</p></noscript>
+{{end}}`))
+
+// renderPage renders a minimal public html page
+func renderPage(c *gin.Context, code int, title, message string) {
+	c.Status(code)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	pageTemplate.Execute(c.Writer, gin.H{
+		"Title":   title,
+		"Message": message,
+	})
+}
+
+func renderUnsubscribePage(c *gin.Context) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	unsubscribeTemplate.Execute(c.Writer, gin.H{
+		"Title":   "Unsubscribe",
+		"Message": "You will no longer receive SC2Advanced release notifications.",
+		"Reasons": unsubscribeReasons,
+	})
+}
+
+var preferencesTemplate = template.Must(template.Must(pageTemplate.Clone()).Funcs(template.FuncMap{
+	"contains": func(l []string, s string) bool {
+		for _, v := range l {
+			if v == s {
+				return true
+			}
+		}
+		return false
+	},
+}).Parse(`{{define "form"}}
+{{with .Preferences}}
+{{if .PendingEmail}}<p>Please confirm {{.PendingEmail}} from the link we sent to it.</p>{{end}}
+<form method="post">
+<p><label>Name<br><input name="Name" value="{{.Name}}"></label></p>
+<p><label>Email<br><input type="email" name="Email" value="{{.Email}}" required></label></p>
+{{if .AvailableLocales}}<p><label>Language<br><select name="Locale"><option value="">Default</option>{{$locale := .Locale}}{{range .AvailableLocales}}<option value="{{.}}"{{if eq . $locale}} selected{{end}}>{{.}}</option>{{end}}</select></label></p>{{end}}
+{{if .AvailableChannels}}<p>Notify me about:<br>{{$selected := .Channels}}{{range .AvailableChannels}}<label><input type="checkbox" name="Channels" value="{{.}}"{{if contains $selected .}} checked{{end}}> {{.}}</label><br>{{end}}</p>{{end}}
+<p><button type="submit">Save</button></p>
+</form>
+{{end}}
+{{end}}`))
+
+func renderPreferencesPage(c *gin.Context, code int, message string, prefs *dist.Preferences) {
+	c.Status(code)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	preferencesTemplate.Execute(c.Writer, gin.H{
+		"Title":       "Preferences",
+		"Message":     message,
+		"Preferences": prefs,
+	})
+}
+
+func renderSignInPage(c *gin.Context, token string) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	signInTemplate.Execute(c.Writer, gin.H{
+		"Title":   "Signed in",
+		"Message": "Opening the admin ui...",
+		"Token":   token,
+	})
+}
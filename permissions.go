@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+)
+
+// routePermission is the permission an /api route needs, an empty Permission only needs a signed in admin
+type routePermission struct {
+	Method     string
+	Path       string
+	Permission string
+}
+
+// routePermissions lists every /api route, routes that are not listed are denied
+var routePermissions = []routePermission{
+	{"GET", "/api/token", ""},
+	{"PUT", "/api/password", ""},
+
+	{"GET", "/api/release", dist.PermReleaseRead},
+	{"POST", "/api/release", dist.PermReleaseWrite},
+	{"GET", "/api/release/:id", dist.PermReleaseRead},
+	{"DELETE", "/api/release/:id", dist.PermReleaseWrite},
+	{"POST", "/api/release/:id/notify", dist.PermReleaseWrite},
+	{"GET", "/api/quarantine", dist.PermReleaseRead},
+	{"DELETE", "/api/quarantine/:id", dist.PermReleaseWrite},
+	{"GET", "/api/template", dist.PermReleaseRead},
+	{"GET", "/api/template/:name", dist.PermReleaseRead},
+	{"PUT", "/api/template/:name", dist.PermReleaseWrite},
+	{"POST", "/api/template/:name/validate", dist.PermReleaseRead},
+	{"GET", "/api/template/:name/history", dist.PermReleaseRead},
+	{"POST", "/api/template/:name/rollback", dist.PermReleaseWrite},
+	{"POST", "/api/notify/preview", dist.PermReleaseRead},
+	{"POST", "/api/notify/test", dist.PermReleaseWrite},
+	{"POST", "/api/keys/rotate", dist.PermAdmin},
+	{"DELETE", "/api/keys/:id", dist.PermAdmin},
+
+	{"GET", "/api/sub", dist.PermSubRead},
+	{"POST", "/api/sub", dist.PermSubWrite},
+	{"PUT", "/api/sub", dist.PermSubWrite},
+	{"POST", "/api/sub/import", dist.PermSubWrite},
+	{"GET", "/api/sub/export", dist.PermSubRead},
+	{"PUT", "/api/sub/:id/status", dist.PermSubWrite},
+	{"GET", "/api/sub/:id/data", dist.PermSubRead},
+	{"GET", "/api/sub/:id/stats", dist.PermSubRead},
+	{"DELETE", "/api/sub/:id", dist.PermSubWrite},
+	{"GET", "/api/erasure", dist.PermSubRead},
+	{"GET", "/api/unsubscribe", dist.PermSubRead},
+	{"GET", "/api/segment", dist.PermSubRead},
+	{"POST", "/api/segment", dist.PermSubWrite},
+	{"PUT", "/api/segment", dist.PermSubWrite},
+	{"DELETE", "/api/segment/:id", dist.PermSubWrite},
+	{"GET", "/api/segment/:id/subs", dist.PermSubRead},
+
+	{"GET", "/api/admin", dist.PermAdmin},
+	{"POST", "/api/admin", dist.PermAdmin},
+	{"GET", "/api/admin/:username", dist.PermAdmin},
+	{"DELETE", "/api/admin/:username", dist.PermAdmin},
+	{"PUT", "/api/admin/:username/password", dist.PermAdmin},
+	{"PUT", "/api/admin/:username/roles", dist.PermAdmin},
+	{"POST", "/api/admin/:username/unlock", dist.PermAdmin},
+	{"GET", "/api/apitoken", dist.PermAdmin},
+	{"POST", "/api/apitoken", dist.PermAdmin},
+	{"DELETE", "/api/apitoken/:id", dist.PermAdmin},
+	{"GET", "/api/config", dist.PermAdmin},
+	{"PATCH", "/api/config", dist.PermAdmin},
+	{"GET", "/api/audit", dist.PermAdmin},
+	{"GET", "/api/audit/export", dist.PermAdmin},
+}
+
+// matchPath reports whether path matches a route pattern, and how many :params it took
+func matchPath(pattern, path string) (params int, ok bool) {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	s := strings.Split(strings.Trim(path, "/"), "/")
+	if len(ps) != len(s) {
+		return 0, false
+	}
+	for i, p := range ps {
+		if strings.HasPrefix(p, ":") {
+			if s[i] == "" {
+				return 0, false
+			}
+			params++
+		} else if p != s[i] {
+			return 0, false
+		}
+	}
+	return params, true
+}
+
+// findRoutePermission returns the permission a request needs, static segments win over :params like the router
+func findRoutePermission(method, path string) (rv *routePermission) {
+	best := -1
+	for i, rp := range routePermissions {
+		if rp.Method != method {
+			continue
+		}
+		if params, ok := matchPath(rp.Path, path); ok && (best < 0 || params < best) {
+			best = params
+			rv = &routePermissions[i]
+		}
+	}
+	return
+}
+
+// allowed reports whether roles may call an /api route
+func allowed(roles []string, method, path string) bool {
+	rp := findRoutePermission(method, path)
+	if rp == nil {
+		return false
+	}
+	return rp.Permission == "" || dist.RolesAllow(roles, rp.Permission)
+}
+
+// scopesAllow reports whether an api token with scopes may call an /api route,
+// routes that only need a signed in admin are not open to tokens
+func scopesAllow(scopes []string, method, path string) bool {
+	rp := findRoutePermission(method, path)
+	if rp == nil || rp.Permission == "" {
+		return false
+	}
+	for _, s := range scopes {
+		if s == rp.Permission {
+			return true
+		}
+	}
+	return false
+}
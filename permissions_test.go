@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	viewer    = dist.RoleViewer
+	publisher = dist.RolePublisher
+	manager   = dist.RoleSubscriberManager
+	admin     = dist.RoleAdmin
+)
+
+// routeRoles lists the roles allowed to call each /api route
+var routeRoles = map[string][]string{
+	"GET /api/token":    {viewer, publisher, manager, admin},
+	"PUT /api/password": {viewer, publisher, manager, admin},
+
+	"GET /api/release":                  {viewer, publisher, manager, admin},
+	"POST /api/release":                 {publisher, admin},
+	"GET /api/release/:id":              {viewer, publisher, manager, admin},
+	"DELETE /api/release/:id":           {publisher, admin},
+	"POST /api/release/:id/notify":      {publisher, admin},
+	"GET /api/quarantine":               {viewer, publisher, manager, admin},
+	"DELETE /api/quarantine/:id":        {publisher, admin},
+	"GET /api/template":                 {viewer, publisher, manager, admin},
+	"GET /api/template/:name":           {viewer, publisher, manager, admin},
+	"PUT /api/template/:name":           {publisher, admin},
+	"POST /api/template/:name/validate": {viewer, publisher, manager, admin},
+	"GET /api/template/:name/history":   {viewer, publisher, manager, admin},
+	"POST /api/template/:name/rollback": {publisher, admin},
+	"POST /api/notify/preview":          {viewer, publisher, manager, admin},
+	"POST /api/notify/test":             {publisher, admin},
+	"POST /api/keys/rotate":             {admin},
+	"DELETE /api/keys/:id":              {admin},
+	"GET /api/sub":                      {viewer, manager, admin},
+	"POST /api/sub":                     {manager, admin},
+	"PUT /api/sub":                      {manager, admin},
+	"POST /api/sub/import":              {manager, admin},
+	"GET /api/sub/export":               {viewer, manager, admin},
+	"PUT /api/sub/:id/status":           {manager, admin},
+	"GET /api/sub/:id/data":             {viewer, manager, admin},
+	"GET /api/sub/:id/stats":            {viewer, manager, admin},
+	"DELETE /api/sub/:id":               {manager, admin},
+	"GET /api/erasure":                  {viewer, manager, admin},
+	"GET /api/unsubscribe":              {viewer, manager, admin},
+	"GET /api/segment":                  {viewer, manager, admin},
+	"POST /api/segment":                 {manager, admin},
+	"PUT /api/segment":                  {manager, admin},
+	"DELETE /api/segment/:id":           {manager, admin},
+	"GET /api/segment/:id/subs":         {viewer, manager, admin},
+	"GET /api/admin":                    {admin},
+	"POST /api/admin":                   {admin},
+	"GET /api/admin/:username":          {admin},
+	"DELETE /api/admin/:username":       {admin},
+	"PUT /api/admin/:username/password": {admin},
+	"PUT /api/admin/:username/roles":    {admin},
+	"POST /api/admin/:username/unlock":  {admin},
+	"GET /api/apitoken":                 {admin},
+	"POST /api/apitoken":                {admin},
+	"DELETE /api/apitoken/:id":          {admin},
+	"GET /api/audit":                    {admin},
+	"GET /api/audit/export":             {admin},
+	"GET /api/config":                   {admin},
+	"PATCH /api/config":                 {admin},
+}
+
+// examplePath fills the :params of a route pattern
+func examplePath(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "x" + p[1:]
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func TestRoutePermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	routes := map[string]bool{}
+	for _, route := range newRouter().Routes() {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		routes[key] = true
+		roles, ok := routeRoles[key]
+		if !assert.True(t, ok, "no expected roles for %s", key) {
+			continue
+		}
+		for _, role := range dist.Roles() {
+			want := false
+			for _, r := range roles {
+				want = want || r == role
+			}
+			assert.Equal(t, want, allowed([]string{role}, route.Method, examplePath(route.Path)), "%s as %s", key, role)
+		}
+		if len(roles) < len(dist.Roles()) {
+			assert.False(t, allowed(nil, route.Method, examplePath(route.Path)), "%s without roles", key)
+		}
+	}
+	for key := range routeRoles {
+		assert.True(t, routes[key], "%s is not routed", key)
+	}
+}
+
+func TestRoutePermissionMatching(t *testing.T) {
+	rp := findRoutePermission("GET", "/api/sub/export")
+	if assert.NotNil(t, rp) {
+		assert.Equal(t, "/api/sub/export", rp.Path)
+	}
+	assert.Nil(t, findRoutePermission("GET", "/api/nothing"))
+	assert.Nil(t, findRoutePermission("PATCH", "/api/release/1"))
+	assert.False(t, allowed([]string{admin}, "GET", "/api/nothing"))
+	assert.False(t, allowed([]string{viewer}, "DELETE", "/api/release/1"))
+	assert.True(t, allowed([]string{viewer, publisher}, "DELETE", "/api/release/1"))
+	assert.False(t, allowed([]string{"owner"}, "GET", "/api/release"))
+}
+
+func TestScopesAllow(t *testing.T) {
+	scopes := []string{dist.PermReleaseWrite}
+	assert.True(t, scopesAllow(scopes, "POST", "/api/release"))
+	assert.True(t, scopesAllow(scopes, "POST", "/api/release/1/notify"))
+	assert.False(t, scopesAllow(scopes, "GET", "/api/release"))
+	assert.False(t, scopesAllow(scopes, "GET", "/api/sub"))
+	// tokens can not refresh sessions or change passwords, whatever their scopes
+	assert.False(t, scopesAllow(dist.Permissions(), "GET", "/api/token"))
+	assert.False(t, scopesAllow(dist.Permissions(), "PUT", "/api/password"))
+	assert.False(t, scopesAllow(dist.Permissions(), "GET", "/api/nothing"))
+	assert.True(t, scopesAllow(dist.Permissions(), "DELETE", "/api/apitoken/1"))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+// preferencesErrorStatus maps preference center errors to http statuses
+func preferencesErrorStatus(err error) int {
+	if verr, ok := err.(*dist.ValidationError); ok {
+		return verr.Status
+	}
+	switch err {
+	case dist.ErrLinkTokenInvalid, dist.ErrSubNotFound, dist.ErrChangeEmailTokenInvalid:
+		return http.StatusNotFound
+	case dist.ErrInvalidEmail:
+		return http.StatusBadRequest
+	case dist.ErrEmailExists:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// usePreferences adds the public preference center, as html pages and a json api, reached with signed tokens
+func usePreferences(r *gin.Engine) {
+	r.GET("/preferences", func(c *gin.Context) {
+		prefs, err := dist.GetPreferences(c.Query("token"))
+		if err != nil {
+			renderPage(c, preferencesErrorStatus(err), "Preferences", err.Error())
+			return
+		}
+		renderPreferencesPage(c, http.StatusOK, "Choose how you hear about SC2Advanced releases.", prefs)
+	})
+
+	r.POST("/preferences", func(c *gin.Context) {
+		token := c.Query("token")
+		prefs, err := dist.UpdatePreferences(token, dist.Preferences{
+			Name:     c.PostForm("Name"),
+			Email:    c.PostForm("Email"),
+			Channels: c.PostFormArray("Channels"),
+			Locale:   c.PostForm("Locale"),
+		})
+		if err != nil {
+			code := preferencesErrorStatus(err)
+			if code == http.StatusNotFound {
+				renderPage(c, code, "Preferences", err.Error())
+				return
+			}
+			if prefs, perr := dist.GetPreferences(token); perr == nil {
+				renderPreferencesPage(c, code, err.Error(), prefs)
+				return
+			}
+			renderPage(c, code, "Preferences", err.Error())
+			return
+		}
+		renderPreferencesPage(c, http.StatusOK, "Your preferences were saved.", prefs)
+	})
+
+	r.GET("/preferences/api", func(c *gin.Context) {
+		prefs, err := dist.GetPreferences(c.Query("token"))
+		if err != nil {
+			code := preferencesErrorStatus(err)
+			c.JSON(code, gin.H{"code": code, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, prefs)
+	})
+
+	r.PUT("/preferences/api", func(c *gin.Context) {
+		prefs := dist.Preferences{}
+		if err := c.BindJSON(&prefs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": err.Error()})
+			return
+		}
+		updated, err := dist.UpdatePreferences(c.Query("token"), prefs)
+		if err != nil {
+			code := preferencesErrorStatus(err)
+			c.JSON(code, gin.H{"code": code, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	})
+
+	r.GET("/preferences/confirm-email", func(c *gin.Context) {
+		sub, err := dist.ConfirmEmailChange(c.Query("token"))
+		if err != nil {
+			renderPage(c, preferencesErrorStatus(err), "Email change failed", err.Error())
+			return
+		}
+		renderPage(c, http.StatusOK, "Email changed", "Release notifications will be sent to "+sub.Email+".")
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimiter allows a fixed number of requests per key within a time window
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   map[string]*rateWindow{},
+	}
+}
+
+// Allow records a request for key and reports whether it is within the limit
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w := l.hits[key]
+	if w == nil || now.Sub(w.start) >= l.window {
+		if len(l.hits) > 10000 {
+			for k, v := range l.hits {
+				if now.Sub(v.start) >= l.window {
+					delete(l.hits, k)
+				}
+			}
+		}
+		w = &rateWindow{start: now}
+		l.hits[key] = w
+	}
+	w.count++
+	return w.count <= l.limit
+}
+
+// Middleware rejects requests over the limit per client IP
+func (l *rateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.Allow(c.ClientIP()) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"code":    http.StatusTooManyRequests,
+				"message": "Too many requests, please try again later",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	for _, trusted := range [][]string{nil, {"10.0.0.0/8"}} {
+		r := gin.New()
+		assert.Nil(t, r.SetTrustedProxies(trusted))
+		r.POST("/subscribe", newRateLimiter(1, time.Hour).Middleware(), func(c *gin.Context) {
+			c.Status(http.StatusAccepted)
+		})
+		codes := []int{}
+		for _, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/subscribe", nil)
+			req.RemoteAddr = "10.0.0.1:4321"
+			req.Header.Set("X-Forwarded-For", ip)
+			r.ServeHTTP(w, req)
+			codes = append(codes, w.Code)
+		}
+		if trusted == nil {
+			// a client can not get around the limit by rotating the header
+			assert.Equal(t, []int{http.StatusAccepted, http.StatusTooManyRequests}, codes)
+		} else {
+			assert.Equal(t, []int{http.StatusAccepted, http.StatusAccepted}, codes)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/itsjamie/gin-cors"
+)
+
+// CORSConfig allows cross origin requests to a group of routes
+type CORSConfig struct {
+	// Origins is a comma separated list of allowed origins, "*" allows any origin but not with Credentials,
+	// and empty refuses cross origin requests
+	Origins string
+	// Methods is a comma separated list of allowed methods
+	Methods string
+	// MaxAge is how long browsers may cache preflight results, e.g. "10m"
+	MaxAge      string
+	Credentials bool
+}
+
+// CORS route groups, /login covers the password and OIDC sign in and public is everything outside /api
+const (
+	corsAPI    = "api"
+	corsLogin  = "login"
+	corsPublic = "public"
+)
+
+// defaultCORS allows any origin without credentials, the api and login take bearer tokens rather than cookies
+var defaultCORS = map[string]CORSConfig{
+	corsAPI:    {Origins: "*", Methods: "GET, PUT, PATCH, POST, DELETE", MaxAge: "50s"},
+	corsLogin:  {Origins: "*", Methods: "GET, POST", MaxAge: "50s"},
+	corsPublic: {Origins: "*", Methods: "GET, POST", MaxAge: "50s"},
+}
+
+// corsGroup returns the CORS route group of a path
+func corsGroup(path string) string {
+	switch {
+	case path == "/api" || strings.HasPrefix(path, "/api/"):
+		return corsAPI
+	case path == "/login" || strings.HasPrefix(path, "/login/"):
+		return corsLogin
+	}
+	return corsPublic
+}
+
+// corsConfigs merges configured route groups over the defaults
+func corsConfigs(configured map[string]CORSConfig) (map[string]cors.Config, error) {
+	rv := map[string]cors.Config{}
+	for group := range configured {
+		if _, ok := defaultCORS[group]; !ok {
+			return nil, fmt.Errorf("CORS: unknown route group %q, must be %s, %s or %s", group, corsAPI, corsLogin, corsPublic)
+		}
+	}
+	for group, d := range defaultCORS {
+		c, ok := configured[group]
+		if !ok {
+			c = d
+		}
+		if c.Methods == "" {
+			c.Methods = d.Methods
+		}
+		if c.MaxAge == "" {
+			c.MaxAge = d.MaxAge
+		}
+		maxAge, err := time.ParseDuration(c.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("CORS %s: MaxAge: %s", group, err.Error())
+		}
+		if c.Credentials {
+			for _, o := range strings.Split(c.Origins, ",") {
+				if strings.TrimSpace(o) == "*" {
+					return nil, fmt.Errorf("CORS %s: Credentials can not be allowed for any origin", group)
+				}
+			}
+		}
+		rv[group] = cors.Config{
+			Origins:         c.Origins,
+			Methods:         c.Methods,
+			RequestHeaders:  "Origin, Authorization, Content-Type",
+			ExposedHeaders:  "X-Total-Count, X-Next-Cursor",
+			MaxAge:          maxAge,
+			Credentials:     c.Credentials,
+			ValidateHeaders: false,
+		}
+	}
+	return rv, nil
+}
+
+// useCORS answers cross origin requests with the settings of their route group.
+// It is global rather than per group so preflight requests, which match no route, are answered too.
+func useCORS(r *gin.Engine, configs map[string]cors.Config) {
+	handlers := map[string]gin.HandlerFunc{}
+	for group, c := range configs {
+		if c.Origins != "" {
+			handlers[group] = cors.Middleware(c)
+		}
+	}
+	r.Use(func(c *gin.Context) {
+		if h, ok := handlers[corsGroup(c.Request.URL.Path)]; ok {
+			h(c)
+			return
+		}
+		c.Next()
+	})
+}
+
+// SecurityHeadersConfig overrides the security headers, an empty value keeps the default and "-" leaves the header out
+type SecurityHeadersConfig struct {
+	// UIContentSecurityPolicy is sent with the admin ui under /ui
+	UIContentSecurityPolicy string
+	// StrictTransportSecurity is only sent when BaseURI is https
+	StrictTransportSecurity string
+	ContentTypeOptions      string
+	FrameOptions            string
+	ReferrerPolicy          string
+}
+
+var defaultSecurityHeaders = SecurityHeadersConfig{
+	UIContentSecurityPolicy: "default-src 'self'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; font-src 'self' data:; connect-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+	StrictTransportSecurity: "max-age=31536000; includeSubDomains",
+	ContentTypeOptions:      "nosniff",
+	FrameOptions:            "DENY",
+	// public links carry tokens in their query
+	ReferrerPolicy: "no-referrer",
+}
+
+func securityHeader(value, fallback string) string {
+	switch value {
+	case "":
+		return fallback
+	case "-":
+		return ""
+	}
+	return value
+}
+
+// useSecurityHeaders adds security headers to every response
+func useSecurityHeaders(r *gin.Engine, c SecurityHeadersConfig, https bool) {
+	d := defaultSecurityHeaders
+	headers := map[string]string{
+		"X-Content-Type-Options": securityHeader(c.ContentTypeOptions, d.ContentTypeOptions),
+		"X-Frame-Options":        securityHeader(c.FrameOptions, d.FrameOptions),
+		"Referrer-Policy":        securityHeader(c.ReferrerPolicy, d.ReferrerPolicy),
+	}
+	if https {
+		headers["Strict-Transport-Security"] = securityHeader(c.StrictTransportSecurity, d.StrictTransportSecurity)
+	}
+	csp := securityHeader(c.UIContentSecurityPolicy, d.UIContentSecurityPolicy)
+	r.Use(func(c *gin.Context) {
+		h := c.Writer.Header()
+		for k, v := range headers {
+			if v != "" {
+				h.Set(k, v)
+			}
+		}
+		if csp != "" && (c.Request.URL.Path == "/ui" || strings.HasPrefix(c.Request.URL.Path, "/ui/")) {
+			h.Set("Content-Security-Policy", csp)
+		}
+		c.Next()
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSConfigs(t *testing.T) {
+	configs, err := corsConfigs(nil)
+	assert.Nil(t, err)
+	for _, group := range []string{corsAPI, corsLogin, corsPublic} {
+		assert.Equal(t, "*", configs[group].Origins)
+		assert.False(t, configs[group].Credentials, group)
+	}
+
+	configs, err = corsConfigs(map[string]CORSConfig{
+		corsAPI: {Origins: "https://admin.example.com", Credentials: true, MaxAge: "10m"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://admin.example.com", configs[corsAPI].Origins)
+	assert.True(t, configs[corsAPI].Credentials)
+	assert.Equal(t, "GET, PUT, PATCH, POST, DELETE", configs[corsAPI].Methods)
+	assert.Equal(t, "10m0s", configs[corsAPI].MaxAge.String())
+	assert.Equal(t, "*", configs[corsPublic].Origins)
+
+	_, err = corsConfigs(map[string]CORSConfig{corsAPI: {Origins: "https://a.example.com, *", Credentials: true}})
+	assert.NotNil(t, err)
+	_, err = corsConfigs(map[string]CORSConfig{"admin": {Origins: "*"}})
+	assert.NotNil(t, err)
+	_, err = corsConfigs(map[string]CORSConfig{corsLogin: {Origins: "*", MaxAge: "soon"}})
+	assert.NotNil(t, err)
+}
+
+func TestCORSGroup(t *testing.T) {
+	assert.Equal(t, corsAPI, corsGroup("/api/release"))
+	assert.Equal(t, corsLogin, corsGroup("/login"))
+	assert.Equal(t, corsLogin, corsGroup("/login/oidc/callback"))
+	assert.Equal(t, corsPublic, corsGroup("/subscribe"))
+	assert.Equal(t, corsPublic, corsGroup("/apiary"))
+}
+
+func securityHeadersFor(c SecurityHeadersConfig, https bool, path string) http.Header {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	useSecurityHeaders(r, c, https)
+	r.GET(path, func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Header()
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	h := securityHeadersFor(SecurityHeadersConfig{}, false, "/subscribe")
+	assert.Equal(t, "nosniff", h.Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", h.Get("X-Frame-Options"))
+	assert.Equal(t, "no-referrer", h.Get("Referrer-Policy"))
+	assert.Empty(t, h.Get("Strict-Transport-Security"))
+	assert.Empty(t, h.Get("Content-Security-Policy"))
+
+	h = securityHeadersFor(SecurityHeadersConfig{}, true, "/ui/index.html")
+	assert.Equal(t, defaultSecurityHeaders.StrictTransportSecurity, h.Get("Strict-Transport-Security"))
+	assert.Equal(t, defaultSecurityHeaders.UIContentSecurityPolicy, h.Get("Content-Security-Policy"))
+
+	h = securityHeadersFor(SecurityHeadersConfig{
+		UIContentSecurityPolicy: "default-src 'self'",
+		FrameOptions:            "SAMEORIGIN",
+		ReferrerPolicy:          "-",
+	}, true, "/ui")
+	assert.Equal(t, "default-src 'self'", h.Get("Content-Security-Policy"))
+	assert.Equal(t, "SAMEORIGIN", h.Get("X-Frame-Options"))
+	_, ok := h["Referrer-Policy"]
+	assert.False(t, ok)
+}
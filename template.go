@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+func reportTemplateError(c *gin.Context, err error) {
+	if err == dist.ErrTemplateNotFound || err == dist.ErrTemplateVersionNotFound {
+		c.Status(http.StatusNotFound)
+	}
+	reportError(c, err)
+}
+
+// useTemplates adds the notification template editor to the api
+func useTemplates(api *gin.RouterGroup) {
+	api.GET("/template", func(c *gin.Context) {
+		list, err := dist.ListTemplates()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	api.GET("/template/:name", func(c *gin.Context) {
+		t, err := dist.GetTemplate(c.Param("name"))
+		if err != nil {
+			reportTemplateError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	})
+
+	api.PUT("/template/:name", func(c *gin.Context) {
+		req := struct{ Text string }{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		auditTarget(c, c.Param("name"))
+		actor, _ := auditActor(c)
+		t, err := dist.SaveTemplate(c.Param("name"), req.Text, actor)
+		if err != nil {
+			reportTemplateError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	})
+
+	api.POST("/template/:name/validate", func(c *gin.Context) {
+		req := struct{ Text string }{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		if err := dist.ValidateTemplate(c.Param("name"), req.Text); err != nil {
+			reportTemplateError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	api.GET("/template/:name/history", func(c *gin.Context) {
+		list, err := dist.TemplateHistory(c.Param("name"))
+		if err != nil {
+			reportTemplateError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	api.POST("/template/:name/rollback", func(c *gin.Context) {
+		req := struct{ Version uint64 }{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		auditTarget(c, c.Param("name"))
+		actor, _ := auditActor(c)
+		t, err := dist.RollbackTemplate(c.Param("name"), req.Version, actor)
+		if err != nil {
+			reportTemplateError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	})
+
+	// unsaved Subject, Content and Filename texts are rendered in place of the current templates
+	api.POST("/notify/preview", func(c *gin.Context) {
+		req := dist.TemplatePreview{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		rv, err := dist.PreviewTemplates(req)
+		if err != nil {
+			reportError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, rv)
+	})
+
+	api.POST("/notify/test", func(c *gin.Context) {
+		req := struct {
+			dist.TemplatePreview
+			Email string
+		}{}
+		if err := c.BindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			c.Error(err)
+			return
+		}
+		// the audit log keeps no email addresses, the recipient is not recorded
+		auditCreated(c, req.ReleaseID)
+		if err := dist.SendTestEmail(req.TemplatePreview, req.Email); err != nil {
+			if err == dist.ErrInvalidEmail {
+				c.Status(http.StatusBadRequest)
+			}
+			reportError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingReader counts the bytes the server read of a request body
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestParseUpload(t *testing.T) {
+	r := gin.New()
+	useErrorHandler(&r.RouterGroup)
+	r.POST("/release", func(c *gin.Context) {
+		if !parseUpload(c, 1024) {
+			return
+		}
+		f, _, err := c.Request.FormFile("File")
+		if !assert.Nil(t, err) {
+			return
+		}
+		n, _ := io.Copy(ioutil.Discard, f)
+		c.JSON(http.StatusOK, gin.H{"size": n, "version": c.Request.FormValue("Version")})
+	})
+	upload := func(size int) (*httptest.ResponseRecorder, *countingReader) {
+		buf := &bytes.Buffer{}
+		m := multipart.NewWriter(buf)
+		m.WriteField("Version", "0.0.1")
+		w, _ := m.CreateFormFile("File", "release.zip")
+		w.Write(bytes.Repeat([]byte("x"), size))
+		m.Close()
+		body := &countingReader{r: buf}
+		req, _ := http.NewRequest("POST", "/release", body)
+		req.Header.Set("Content-Type", m.FormDataContentType())
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec, body
+	}
+
+	w, _ := upload(1024)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"version":"0.0.1"`)
+
+	// the body is cut off once it exceeds the limit and the headroom
+	size := 4 * uploadHeadroom
+	w, body := upload(size)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "file_too_large")
+	assert.True(t, body.n < size, "read %d bytes", body.n)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/DreamHacks/sc2a-service/dist"
+	"github.com/gin-gonic/gin"
+)
+
+// mailgunWebhookBody is the json format of mailgun webhooks
+type mailgunWebhookBody struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+	EventData struct {
+		Event     string `json:"event"`
+		Severity  string `json:"severity"`
+		Recipient string `json:"recipient"`
+		Reason    string `json:"reason"`
+	} `json:"event-data"`
+}
+
+// readMailgunEvent reads a webhook event posted as json or, by legacy webhooks, as a form
+func readMailgunEvent(c *gin.Context) (e dist.MailgunEvent, err error) {
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		body := mailgunWebhookBody{}
+		if err = json.NewDecoder(c.Request.Body).Decode(&body); err != nil {
+			return
+		}
+		return dist.MailgunEvent{
+			Timestamp: body.Signature.Timestamp,
+			Token:     body.Signature.Token,
+			Signature: body.Signature.Signature,
+			Event:     body.EventData.Event,
+			Severity:  body.EventData.Severity,
+			Recipient: body.EventData.Recipient,
+			Reason:    body.EventData.Reason,
+		}, nil
+	}
+	e = dist.MailgunEvent{
+		Timestamp: c.PostForm("timestamp"),
+		Token:     c.PostForm("token"),
+		Signature: c.PostForm("signature"),
+		Event:     c.PostForm("event"),
+		Recipient: c.PostForm("recipient"),
+		Reason:    c.PostForm("error"),
+	}
+	return
+}
+
+// handleMailgunWebhook updates subscribers from bounce, complaint and unsubscribe events
+func handleMailgunWebhook(c *gin.Context) {
+	e, err := readMailgunEvent(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	_, err = dist.HandleMailgunEvent(e)
+	switch err {
+	case nil, dist.ErrSubNotFound:
+		c.String(http.StatusOK, "ok")
+	case dist.ErrWebhookSignature:
+		c.String(http.StatusUnauthorized, err.Error())
+	default:
+		c.String(http.StatusInternalServerError, err.Error())
+	}
+}